@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/akeil/rmtool"
+)
+
+// debugEntry is the machine readable result for the debug command.
+type debugEntry struct {
+	ID       string          `json:"id"`
+	Name     string          `json:"name"`
+	Content  json.RawMessage `json:"content,omitempty"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	Pagedata []string        `json:"pagedata,omitempty"`
+}
+
+// doDebug prints the raw .content, .metadata and .pagedata files
+// for the matching documents, as they are stored in the repository.
+func doDebug(s settings, match string) error {
+	repo, err := setupRepo(s)
+	if err != nil {
+		return err
+	}
+
+	items, err := repo.List()
+	if err != nil {
+		return err
+	}
+
+	root := rmtool.BuildTree(items)
+	root = root.Filtered(rmtool.IsDocument, rmtool.MatchName(match))
+
+	entries := make([]debugEntry, 0)
+	root.Walk(func(n *rmtool.Node) error {
+		if !n.IsLeaf() {
+			return nil
+		}
+
+		e := debugEntry{ID: n.ID(), Name: n.Name()}
+		if !out.json {
+			fmt.Printf("%v (%v)\n", n.Name(), n.ID())
+		}
+
+		for _, ext := range []string{"content", "metadata", "pagedata"} {
+			p := n.ID() + "." + ext
+			data, err := readRaw(repo, n, p)
+			if !out.json {
+				fmt.Printf("\n--- %v\n", p)
+			}
+			if err != nil {
+				out.failure("%v Failed to read %q: %v", crossmark, p, err)
+				continue
+			}
+
+			// a RawMessage with invalid JSON cannot be encoded
+			valid := json.Valid(data)
+			if !valid && out.json {
+				out.failure("%v Invalid JSON in %q", crossmark, p)
+			}
+
+			switch ext {
+			case "content":
+				if valid {
+					e.Content = data
+				}
+			case "metadata":
+				if valid {
+					e.Metadata = data
+				}
+			case "pagedata":
+				e.Pagedata = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+			}
+
+			if out.json {
+				continue
+			}
+			if ext == "pagedata" {
+				fmt.Print(string(data))
+			} else {
+				printJSON(data)
+			}
+		}
+		if !out.json {
+			fmt.Println()
+		}
+
+		entries = append(entries, e)
+		return nil
+	})
+
+	if len(entries) == 0 {
+		if !out.result(entries) {
+			out.progress("No matching documents for %q", match)
+		}
+		return nil
+	}
+	out.result(entries)
+
+	return nil
+}
+
+// readRaw reads the file at the given path for an item.
+func readRaw(repo rmtool.Repository, m rmtool.Meta, path string) ([]byte, error) {
+	r, err := repo.Reader(m.ID(), m.Version(), path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// printJSON pretty-prints the given JSON data.
+// Invalid JSON is printed verbatim.
+func printJSON(data []byte) {
+	var buf bytes.Buffer
+	err := json.Indent(&buf, data, "", "  ")
+	if err != nil {
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Println(buf.String())
+}
@@ -1,19 +1,35 @@
 package main
 
 import (
-	"fmt"
-	"image/color"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
-
-	"golang.org/x/sync/errgroup"
+	"sync"
+	"time"
 
 	"github.com/akeil/rmtool"
-	"github.com/akeil/rmtool/pkg/lines"
+	"github.com/akeil/rmtool/internal/fs"
 	"github.com/akeil/rmtool/pkg/render"
 )
 
-func doGet(s settings, match, outDir string, mkDirs bool) error {
+// pageFits maps the values for the --fit flag to render options.
+var pageFits = map[string]render.PageFit{
+	"tablet-aspect": render.FitAspect,
+	"fit":           render.FitWidth,
+	"fill":          render.FitPage,
+}
+
+func doGet(s settings, match, outDir string, mkDirs bool, palette, fit, pageSize, author string, jobs int, skipExisting bool, since string, skipBad bool) error {
+	filters := []rmtool.NodeFilter{rmtool.IsDocument, rmtool.MatchName(match)}
+	if since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, rmtool.ModifiedAfter(t))
+	}
+
 	repo, err := setupRepo(s)
 	if err != nil {
 		return err
@@ -25,70 +41,160 @@ func doGet(s settings, match, outDir string, mkDirs bool) error {
 	}
 
 	root := rmtool.BuildTree(items)
-	root = root.Filtered(rmtool.IsDocument, rmtool.MatchName(match))
+	root = root.Filtered(filters...)
 
 	if len(root.Children) == 0 {
-		fmt.Printf("No matching documents for %q\n", match)
+		if !out.result([]itemResult{}) {
+			out.progress("No matching documents for %q", match)
+		}
 		return nil
 	}
 
-	brushes := map[lines.BrushColor]color.Color{
-		lines.Black: color.RGBA{0, 20, 120, 255},   // dark blue
-		lines.Gray:  color.RGBA{35, 110, 160, 255}, // light/gray blue
-		lines.White: color.White,
+	p, err := parsePalette(palette)
+	if err != nil {
+		out.failure("Invalid palette %q: %v, using default", palette, err)
+		p, _ = parsePalette(defaultPalette)
 	}
-	yellow := color.RGBA{240, 240, 80, 255}
-	p := render.NewPalette(color.White, yellow, brushes)
-	rc := render.NewContext(s.dataDir, p)
+	rc := render.NewContext(s.renderDataDir, p)
+	rc.Fit = pageFits[fit]
+	rc.PageSize = pageSize
+	rc.Author = author
+	rc.SkipBadPages = skipBad
 
-	var group errgroup.Group
+	results := make([]itemResult, 0)
+	var mx sync.Mutex
+	group := newJobGroup(jobs)
 	root.Walk(func(n *rmtool.Node) error {
 		if n.Type() == rmtool.CollectionType {
 			return nil
 		}
+		path := outputPath(n, outDir, mkDirs)
+		if skipExisting && isUpToDate(path, n.LastModified()) {
+			out.progress("%v %q is up to date, skipping", checkmark, n.Name())
+			res := newItemResult(n.ID(), n.Name(), path, nil)
+			res.Skipped = true
+			mx.Lock()
+			results = append(results, res)
+			mx.Unlock()
+			return nil
+		}
 		group.Go(func() error {
-			return renderPdf(rc, repo, n, outDir, mkDirs)
+			path, err := renderPdf(rc, repo, n, outDir, mkDirs)
+			res := newItemResult(n.ID(), n.Name(), path, err)
+			if err == errDummy {
+				res = newItemResult(n.ID(), n.Name(), "", nil)
+				res.Skipped = true
+				err = nil
+			}
+			mx.Lock()
+			results = append(results, res)
+			mx.Unlock()
+			return err
 		})
 		return nil
 	})
-	return group.Wait()
+	err = group.Wait()
+	out.result(results)
+	return err
 }
 
-func renderPdf(rc *render.Context, repo rmtool.Repository, item *rmtool.Node, outDir string, mkDirs bool) error {
-	fmt.Printf("%v download %q\n", ellipsis, item.Name())
+// largeDownload is the size in bytes above which get warns
+// before downloading a document.
+var largeDownload int64 = 100 * 1024 * 1024
+
+// errDummy is returned by renderPdf for placeholder documents.
+var errDummy = errors.New("placeholder document")
+
+// renderPdf downloads the given item, renders it as a PDF
+// and returns the path of the PDF file.
+//
+// Placeholder ("dummy") documents have nothing to render,
+// they are skipped with errDummy.
+func renderPdf(rc *render.Context, repo rmtool.Repository, item *rmtool.Node, outDir string, mkDirs bool) (string, error) {
+	if size, ok := isLargeDownload(repo, item); ok {
+		out.failure("Warning: %q is large (%v), the download may take a while", item.Name(), humanSize(size))
+	}
+	out.progress("%v download %q", ellipsis, item.Name())
 	doc, err := rmtool.ReadDocument(repo, item)
 	if err != nil {
-		fmt.Printf("%v Failed to download %q: %v\n", crossmark, item.Name(), err)
-		return err
+		out.failure("%v Failed to download %q: %v", crossmark, item.Name(), err)
+		return "", err
+	}
+	if doc.IsDummy() {
+		out.progress("%v %q is a placeholder, skipping", checkmark, item.Name())
+		return "", errDummy
 	}
 
-	// Mirror the directory structure from the tablet
-	p := item.Path()
-	p = p[1:] // drop root element
-	if mkDirs && len(p) != 0 {
-		outDir = filepath.Join(outDir, filepath.Join(p...))
-		err = os.MkdirAll(outDir, 0755)
+	path := outputPath(item, outDir, mkDirs)
+	if mkDirs {
+		dir := filepath.Dir(path)
+		err = os.MkdirAll(dir, 0755)
 		if err != nil {
-			fmt.Printf("%v Failed to create directory %q: %v\n", crossmark, outDir, err)
-			return err
+			out.failure("%v Failed to create directory %q: %v", crossmark, dir, err)
+			return "", err
 		}
 	}
 
-	path := filepath.Join(outDir, doc.Name()+".pdf")
-	f, err := os.Create(path)
-	if err != nil {
+	out.progress("%v render %q", ellipsis, item.Name())
+	// Write to a temp file first, a failed render must not leave a
+	// partial PDF which looks up to date for --skip-existing.
+	var partial *render.PartialError
+	err = fs.WriteFile(path, func(w io.Writer) error {
+		err := rc.Pdf(doc, w)
+		if p, ok := err.(*render.PartialError); ok {
+			partial = p
+			return nil
+		}
 		return err
+	})
+
+	if partial != nil {
+		for _, p := range partial.Pages {
+			out.failure("%v Failed to read %q, %v", crossmark, item.Name(), p)
+		}
+	}
+	if err != nil {
+		out.failure("%v Failed to render %q: %v", crossmark, item.Name(), err)
+		return "", err
 	}
-	defer f.Close()
 
-	fmt.Printf("%v render %q\n", ellipsis, item.Name())
-	err = rc.Pdf(doc, f)
+	out.progress("%v document %q saved as %q.", checkmark, item.Name(), path)
+	return path, nil
+}
 
-	if err != nil {
-		fmt.Printf("%v Failed to render %q: %v\n", crossmark, item.Name(), err)
-		return err
+// isLargeDownload tells if the given item is larger than largeDownload
+// and returns its size. Returns false if the repository cannot tell
+// the size.
+func isLargeDownload(repo rmtool.Repository, item rmtool.Meta) (int64, bool) {
+	sizer, ok := repo.(rmtool.Sizer)
+	if !ok {
+		return rmtool.UnknownSize, false
 	}
+	size, err := sizer.Size(item.ID(), item.Version())
+	if err != nil || size == rmtool.UnknownSize {
+		return rmtool.UnknownSize, false
+	}
+	return size, size > largeDownload
+}
 
-	fmt.Printf("%v document %q saved as %q.\n", checkmark, item.Name(), path)
-	return nil
+// outputPath determines the path of the PDF file for the given item.
+// With mkDirs, the directory structure from the tablet is mirrored
+// below outDir.
+func outputPath(item *rmtool.Node, outDir string, mkDirs bool) string {
+	p := item.Path()
+	p = p[1:] // drop root element
+	if mkDirs && len(p) != 0 {
+		outDir = filepath.Join(outDir, filepath.Join(p...))
+	}
+	return filepath.Join(outDir, item.Name()+".pdf")
+}
+
+// isUpToDate tells if the file at path exists
+// and was modified after the given time.
+func isUpToDate(path string, modified time.Time) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return info.ModTime().After(modified)
 }
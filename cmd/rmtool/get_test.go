@@ -0,0 +1,139 @@
+package main
+
+import (
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/pkg/fs"
+	"github.com/akeil/rmtool/pkg/render"
+)
+
+func TestRenderPdfFailure(t *testing.T) {
+	out.quiet = true
+
+	repoDir, err := ioutil.TempDir("", "rm-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repoDir)
+	outDir, err := ioutil.TempDir("", "rm-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+
+	repo := fs.NewRepository(repoDir)
+	doc := rmtool.NewNotebook("Broken", "")
+	err = repo.Upload(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an unreadable drawing makes the render fail
+	rm := filepath.Join(repoDir, doc.ID(), doc.Pages()[0]+".rm")
+	err = ioutil.WriteFile(rm, []byte("not a drawing"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := repo.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := rmtool.BuildTree(items).FindByID(doc.ID())
+
+	rc := render.NewContext(outDir, render.NewPalette(color.White, color.White, nil))
+	_, err = renderPdf(rc, repo, n, outDir, false)
+	if err == nil {
+		t.Fatal("expected error for unreadable drawing")
+	}
+
+	// the next run with --skip-existing must not skip the document
+	path := outputPath(n, outDir, false)
+	if isUpToDate(path, n.LastModified()) {
+		t.Errorf("failed render is reported as up to date")
+	}
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no PDF after failed render, got %v", err)
+	}
+}
+
+func TestRenderPdfDummy(t *testing.T) {
+	out.quiet = true
+
+	repoDir, err := ioutil.TempDir("", "rm-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repoDir)
+	outDir, err := ioutil.TempDir("", "rm-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+
+	repo := fs.NewRepository(repoDir)
+	doc := rmtool.NewNotebook("Dummy", "")
+	err = repo.Upload(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := filepath.Join(repoDir, doc.ID()+".content")
+	err = ioutil.WriteFile(cp, []byte(`{"fileType": "notebook", "dummyDocument": true}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := repo.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := rmtool.BuildTree(items).FindByID(doc.ID())
+
+	rc := render.NewContext(outDir, render.NewPalette(color.White, color.White, nil))
+	_, err = renderPdf(rc, repo, n, outDir, false)
+	if err != errDummy {
+		t.Errorf("expected errDummy, got %v", err)
+	}
+	_, err = os.Stat(outputPath(n, outDir, false))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no PDF for placeholder document, got %v", err)
+	}
+}
+
+func TestIsLargeDownload(t *testing.T) {
+	repoDir, err := ioutil.TempDir("", "rm-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repoDir)
+
+	repo := fs.NewRepository(repoDir)
+	doc := rmtool.NewNotebook("Large", "")
+	err = repo.Upload(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := repo.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := rmtool.BuildTree(items).FindByID(doc.ID())
+
+	_, large := isLargeDownload(repo, n)
+	if large {
+		t.Errorf("small document reported as large")
+	}
+
+	limit := largeDownload
+	defer func() { largeDownload = limit }()
+	largeDownload = 10
+	size, large := isLargeDownload(repo, n)
+	if !large || size <= 10 {
+		t.Errorf("expected large download, got size %v", size)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultJobs is the default number of documents processed concurrently.
+const defaultJobs = "4"
+
+// jobGroup is an errgroup which runs at most a fixed number of
+// functions at the same time.
+type jobGroup struct {
+	group errgroup.Group
+	sem   chan struct{}
+}
+
+// newJobGroup creates a jobGroup which runs up to n functions at once.
+// Values smaller than one are treated as one.
+func newJobGroup(n int) *jobGroup {
+	if n < 1 {
+		n = 1
+	}
+	return &jobGroup{sem: make(chan struct{}, n)}
+}
+
+// Go calls the given function in a new goroutine,
+// blocking until a slot is available.
+func (g *jobGroup) Go(f func() error) {
+	g.sem <- struct{}{}
+	g.group.Go(func() error {
+		defer func() { <-g.sem }()
+		return f()
+	})
+}
+
+// Wait blocks until all functions have returned
+// and returns the first non-nil error (if any).
+func (g *jobGroup) Wait() error {
+	return g.group.Wait()
+}
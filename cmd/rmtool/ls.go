@@ -2,11 +2,22 @@ package main
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/akeil/rmtool"
 )
 
-func doLs(s settings, format, match string, pinned bool) error {
+func doLs(s settings, format, sortBy string, reverse bool, match string, pinned bool, since string, long, noDummy bool) error {
+	var modifiedAfter time.Time
+	if since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			return err
+		}
+		modifiedAfter = t
+	}
+
 	repo, err := setupRepo(s)
 	if err != nil {
 		return err
@@ -25,24 +36,54 @@ func doLs(s settings, format, match string, pinned bool) error {
 	if pinned {
 		filters = append(filters, rmtool.IsPinned)
 	}
+	if since != "" {
+		filters = append(filters, rmtool.IsDocument, rmtool.ModifiedAfter(modifiedAfter))
+	}
+	if noDummy {
+		filters = append(filters, rmtool.IsReal(repo))
+	}
 
 	root = root.Filtered(filters...)
 
 	if len(root.Children) == 0 {
-		fmt.Println("Found no matching notebooks.")
+		if !out.result([]lsEntry{}) {
+			out.progress("Found no matching notebooks.")
+		}
 		return nil
 	}
 
-	root.Sort(rmtool.DefaultSort)
+	var compare rmtool.NodeComparator
+	switch sortBy {
+	case "name":
+		compare = rmtool.DefaultSort
+	case "modified":
+		compare = rmtool.SortByModified
+	case "pages":
+		compare = rmtool.SortByPageCount(repo)
+	default:
+		return fmt.Errorf("unsupported sort order, choose one of 'name', 'modified', 'pages'")
+	}
+	if reverse {
+		compare = rmtool.Reverse(compare)
+	}
+	root.Sort(compare)
+
+	if out.result(listEntries(root)) {
+		return nil
+	}
 
-	fmt.Println("reMarkable Notebooks")
-	fmt.Println("--------------------")
+	out.progress("reMarkable Notebooks")
+	out.progress("--------------------")
 
 	switch format {
 	case "tree":
 		showTree(root, 0)
 	case "list":
-		showList(root)
+		var sizer rmtool.Sizer
+		if long {
+			sizer, _ = repo.(rmtool.Sizer)
+		}
+		showList(root, sizer)
 	default:
 		return fmt.Errorf("unsupported format, choose one of 'tree', 'list'")
 	}
@@ -50,7 +91,39 @@ func doLs(s settings, format, match string, pinned bool) error {
 	return nil
 }
 
-func showList(n *rmtool.Node) {
+// lsEntry is the machine readable representation of an item for ls.
+type lsEntry struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Folder   bool      `json:"folder"`
+	Pinned   bool      `json:"pinned"`
+	Modified time.Time `json:"modified"`
+}
+
+// listEntries creates a flat list of entries for all nodes below root.
+func listEntries(root *rmtool.Node) []lsEntry {
+	entries := make([]lsEntry, 0)
+	root.Walk(func(n *rmtool.Node) error {
+		if n.ParentNode == nil {
+			return nil // skip the root node
+		}
+		entries = append(entries, lsEntry{
+			ID:       n.ID(),
+			Name:     n.Name(),
+			Path:     strings.Join(n.Path()[1:], "/"),
+			Folder:   n.Type() == rmtool.CollectionType,
+			Pinned:   n.Pinned(),
+			Modified: n.LastModified(),
+		})
+		return nil
+	})
+	return entries
+}
+
+// showList prints the nodes as a flat list.
+// If a Sizer is given, the size of each document is shown.
+func showList(n *rmtool.Node, sizer rmtool.Sizer) {
 	dateFormat := "Jan 02 2006, 15:04"
 
 	show := func(n *rmtool.Node) error {
@@ -68,6 +141,9 @@ func showList(n *rmtool.Node) {
 
 		fmt.Print(" ")
 		fmt.Print(n.LastModified().Format(dateFormat))
+		if sizer != nil {
+			fmt.Printf(" | %8s", formatSize(sizer, n))
+		}
 		fmt.Print(" | ")
 		fmt.Print(n.Name())
 		fmt.Println()
@@ -93,6 +169,10 @@ func showTree(n *rmtool.Node, level int) {
 		if n.Pinned() {
 			fmt.Print(" *")
 		}
+		if !n.IsLeaf() {
+			docs, folders := n.Count()
+			fmt.Printf(" (%d documents, %d folders)", docs, folders)
+		}
 
 		fmt.Println()
 	}
@@ -103,3 +183,31 @@ func showTree(n *rmtool.Node, level int) {
 		}
 	}
 }
+
+// formatSize returns the size of a document in human readable form
+// or "-" for folders and unknown sizes.
+func formatSize(sizer rmtool.Sizer, n *rmtool.Node) string {
+	if !n.IsLeaf() {
+		return "-"
+	}
+	size, err := sizer.Size(n.ID(), n.Version())
+	if err != nil || size == rmtool.UnknownSize {
+		return "-"
+	}
+	return humanSize(size)
+}
+
+// humanSize formats a size in bytes with a suitable unit.
+func humanSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	f := float64(size)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %v", f, units[i])
+}
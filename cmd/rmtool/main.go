@@ -24,13 +24,21 @@ func main() {
 
 	var (
 		verbose = app.Flag("verbose", "Print debug messages").Short('v').Bool()
+		quiet   = app.Flag("quiet", "Do not print progress messages").Short('q').Bool()
+		jsonOut = app.Flag("json", "Print results as JSON (get, put, ls)").Bool()
+		jobs    = app.Flag("jobs", "Number of documents to process in parallel (get, put, validate)").Short('j').Default(defaultJobs).Int()
 	)
 
 	ls := app.Command("ls", "List notebooks").Default()
 	var (
-		pinned = ls.Flag("pinned", "Show only pinned items").Short('p').Bool()
-		format = ls.Flag("format", "Output format").Short('f').Default("tree").String()
-		match  = ls.Arg("match", "Name must match this").String()
+		pinned  = ls.Flag("pinned", "Show only pinned items").Short('p').Bool()
+		format  = ls.Flag("format", "Output format").Short('f').Default("tree").String()
+		sortBy  = ls.Flag("sort", "Sort order, one of 'name', 'modified', 'pages'").Short('s').Default("name").String()
+		reverse = ls.Flag("reverse", "Reverse the sort order").Short('r').Bool()
+		long    = ls.Flag("long", "Show the size of documents (list format)").Short('l').Bool()
+		since   = ls.Flag("since", "Only documents modified after a date (2006-01-02) or within an age (7d)").String()
+		noDummy = ls.Flag("no-dummies", "Hide placeholder documents (reads the content of each document)").Bool()
+		match   = ls.Arg("match", "Name must match this").String()
 	)
 
 	get := app.Command("get", "Download one or more notebooks in PDF format")
@@ -38,6 +46,14 @@ func main() {
 		matchGet = get.Arg("match", "Name must match this").String()
 		outDir   = get.Flag("output", "Output directory").Short('o').Default(".").String()
 		mkDirs   = get.Flag("dirs", "Create subdirectories from tablet's folders").Short('d').Bool()
+		palette  = get.Flag("palette", "Color scheme ('blue', 'bw', 'grayscale' or key=#rrggbb,...)").Default(defaultPalette).String()
+		fit      = get.Flag("fit", "Placement of drawings on PDF pages").Default("tablet-aspect").Enum("tablet-aspect", "fit", "fill")
+		pageSize = get.Flag("page-size", "PDF page size ('A4', 'Letter', ... or width,height in points)").Default("A4").String()
+		author   = get.Flag("author", "Author for the PDF metadata").String()
+		skipEx   = get.Flag("skip-existing", "Skip documents if the PDF file exists and is newer than the document").Bool()
+		force    = get.Flag("force", "Render all documents, overrides --skip-existing").Bool()
+		skipBad  = get.Flag("skip-bad-pages", "Use a placeholder for pages that cannot be read").Bool()
+		sinceGet = get.Flag("since", "Only documents modified after a date (2006-01-02) or within an age (7d)").String()
 	)
 
 	put := app.Command("put", "Upload PDF documents to reMarkable")
@@ -52,6 +68,39 @@ func main() {
 		unpin    = pin.Flag("negate", "Remove a bookmark").Short('n').Bool()
 	)
 
+	restore := app.Command("restore", "Restore documents or folders from trash")
+	var (
+		matchRestore = restore.Arg("match", "Which items to restore").String()
+		restoreTo    = restore.Flag("to", "Destination folder (default is root)").Short('t').String()
+	)
+
+	rnd := app.Command("render", "Render a single .rm file to PNG, JPEG or PDF")
+	var (
+		rmFile     = rnd.Arg("file", "The .rm file to render").Required().ExistingFile()
+		rndOut     = rnd.Flag("output", "Output path (default is the input path with the format's extension)").Short('o').String()
+		rndFormat  = rnd.Flag("format", "Output format").Short('f').Default("png").Enum("png", "jpeg", "pdf")
+		rndQuality = rnd.Flag("quality", "Quality for JPEG output (1-100)").Int()
+		rndPalette = rnd.Flag("palette", "Color scheme ('blue', 'bw', 'grayscale' or key=#rrggbb,...)").Default(defaultPalette).String()
+	)
+
+	vld := app.Command("validate", "Check documents for errors")
+	var (
+		matchValidate = vld.Arg("match", "Name must match this (default is all documents)").String()
+	)
+
+	dbg := app.Command("debug", "Print the raw content, metadata and pagedata for documents")
+	var (
+		matchDebug = dbg.Arg("match", "Name must match this").String()
+	)
+
+	gen := app.Command("gen-sprites", "Create the spritesheet from brush images").Hidden()
+	var (
+		spriteSrc = gen.Arg("src", "Directory with brush images").Required().ExistingDir()
+		spriteDst = gen.Arg("dst", "Base path for sprites.png and sprites.json").Required().String()
+	)
+
+	app.Command("version", "Show version and supported formats")
+
 	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	if *verbose {
@@ -60,27 +109,42 @@ func main() {
 		rmtool.SetLogLevel("warning")
 	}
 
+	out.quiet = *quiet
+	out.json = *jsonOut
+
 	settings, err := loadSettings()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
+		out.failure("Error: %v", err)
 		os.Exit(1)
 	}
 
 	switch command {
 	case "ls":
-		err = doLs(settings, *format, *match, *pinned)
+		err = doLs(settings, *format, *sortBy, *reverse, *match, *pinned, *since, *long, *noDummy)
 	case "get":
-		err = doGet(settings, *matchGet, *outDir, *mkDirs)
+		err = doGet(settings, *matchGet, *outDir, *mkDirs, *palette, *fit, *pageSize, *author, *jobs, *skipEx && !*force, *sinceGet, *skipBad)
 	case "put":
-		err = doPut(settings, *paths)
+		err = doPut(settings, *paths, *jobs)
 	case "pin":
 		err = doPin(settings, *matchPin, !*unpin)
+	case "restore":
+		err = doRestore(settings, *matchRestore, *restoreTo)
+	case "render":
+		err = doRender(settings, *rmFile, *rndOut, *rndFormat, *rndPalette, *rndQuality)
+	case "validate":
+		err = doValidate(settings, *matchValidate, *jobs)
+	case "debug":
+		err = doDebug(settings, *matchDebug)
+	case "gen-sprites":
+		err = doGenSprites(*spriteSrc, *spriteDst)
+	case "version":
+		err = doVersion()
 	default:
 		err = fmt.Errorf("unknown command: %q", command)
 	}
 
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
+		out.failure("Error: %v", err)
 		os.Exit(1)
 	}
 }
@@ -88,6 +152,9 @@ func main() {
 type settings struct {
 	dataDir  string
 	cacheDir string
+	// renderDataDir holds the brushes and templates for rendering,
+	// it defaults to dataDir.
+	renderDataDir string
 }
 
 func loadSettings() (settings, error) {
@@ -104,6 +171,12 @@ func loadSettings() (settings, error) {
 	}
 	s.dataDir = filepath.Join(dataHome, "rmtool")
 
+	// render assets can be installed separately, e.g. to /usr/share/rmtool
+	s.renderDataDir = os.Getenv("RMTOOL_RENDER_DATA_DIR")
+	if s.renderDataDir == "" {
+		s.renderDataDir = s.dataDir
+	}
+
 	cacheHome, err := os.UserCacheDir()
 	if err != nil {
 		return s, err
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// output controls how commands report progress and results.
+//
+// Progress messages go to stdout and are suppressed in quiet or JSON mode.
+// Errors always go to stderr.
+// In JSON mode, commands print their results as a single JSON document.
+type output struct {
+	quiet bool
+	json  bool
+}
+
+// out is configured from the global command line flags.
+var out = &output{}
+
+// progress prints a progress or informational message.
+func (o *output) progress(format string, v ...interface{}) {
+	if o.quiet || o.json {
+		return
+	}
+	fmt.Printf(format+"\n", v...)
+}
+
+// failure prints an error message to stderr.
+func (o *output) failure(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", v...)
+}
+
+// result prints the given value as JSON if JSON output is enabled.
+// Returns false if JSON output is disabled and the caller should print
+// a textual representation instead.
+func (o *output) result(v interface{}) bool {
+	if !o.json {
+		return false
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(v)
+	if err != nil {
+		o.failure("Error: %v", err)
+	}
+	return true
+}
+
+// itemResult is the machine readable result for a single item,
+// e.g. a downloaded or uploaded document.
+type itemResult struct {
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newItemResult(id, name, path string, err error) itemResult {
+	r := itemResult{ID: id, Name: name, Path: path}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
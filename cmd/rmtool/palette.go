@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/akeil/rmtool/pkg/lines"
+	"github.com/akeil/rmtool/pkg/render"
+)
+
+const defaultPalette = "blue"
+
+// Predefined color schemes,
+// the colors are listed as black, gray, white, highlighter, background.
+var palettes = map[string][]color.Color{
+	"blue": []color.Color{
+		color.RGBA{0, 20, 120, 255},   // dark blue
+		color.RGBA{35, 110, 160, 255}, // light/gray blue
+		color.White,
+		color.RGBA{240, 240, 80, 255}, // yellow
+		color.White,
+	},
+	"bw": []color.Color{
+		color.Black,
+		color.Black,
+		color.White,
+		color.RGBA{150, 150, 150, 255},
+		color.White,
+	},
+	"grayscale": []color.Color{
+		color.Black,
+		color.RGBA{150, 150, 150, 255},
+		color.White,
+		color.RGBA{200, 200, 200, 255},
+		color.White,
+	},
+}
+
+// parsePalette creates a palette from the given spec.
+//
+// The spec is either the name of a predefined palette ("blue", "bw",
+// "grayscale") or a comma separated list of key=value pairs with hex colors,
+// e.g. "black=#000080,highlighter=#ffff00".
+// Valid keys are "black", "gray", "white", "highlighter" and "background",
+// colors not given in the list are taken from the default palette.
+func parsePalette(spec string) (*render.Palette, error) {
+	if spec == "" {
+		spec = defaultPalette
+	}
+
+	preset, ok := palettes[strings.ToLower(spec)]
+	if ok {
+		return newPalette(preset), nil
+	}
+
+	colors := make([]color.Color, len(palettes[defaultPalette]))
+	copy(colors, palettes[defaultPalette])
+
+	index := map[string]int{
+		"black":       0,
+		"gray":        1,
+		"white":       2,
+		"highlighter": 3,
+		"background":  4,
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid palette entry %q, expected key=color", entry)
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		i, ok := index[key]
+		if !ok {
+			return nil, fmt.Errorf("invalid palette key %q", key)
+		}
+		c, err := parseHexColor(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		colors[i] = c
+	}
+
+	return newPalette(colors), nil
+}
+
+func newPalette(c []color.Color) *render.Palette {
+	brushes := map[lines.BrushColor]color.Color{
+		lines.Black: c[0],
+		lines.Gray:  c[1],
+		lines.White: c[2],
+	}
+	return render.NewPalette(c[4], c[3], brushes)
+}
+
+// parseHexColor parses a color in the form "#rrggbb" or "#rgb".
+// The leading "#" is optional.
+func parseHexColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
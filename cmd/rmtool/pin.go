@@ -1,8 +1,6 @@
 package main
 
 import (
-	"fmt"
-
 	"golang.org/x/sync/errgroup"
 
 	"github.com/akeil/rmtool"
@@ -29,12 +27,12 @@ func doPin(s settings, match string, pinned bool) error {
 				n.SetPinned(pinned)
 				err := repo.Update(n)
 				if err != nil {
-					fmt.Printf("%v Failed to change bookmark for %q: %v", crossmark, n.Name(), err)
+					out.failure("%v Failed to change bookmark for %q: %v", crossmark, n.Name(), err)
 				} else {
 					if pinned {
-						fmt.Printf("%v Bookmarked %q\n", checkmark, n.Name())
+						out.progress("%v Bookmarked %q", checkmark, n.Name())
 					} else {
-						fmt.Printf("%v Removed bookmark for %q\n", checkmark, n.Name())
+						out.progress("%v Removed bookmark for %q", checkmark, n.Name())
 					}
 				}
 				return err
@@ -6,8 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-
-	"golang.org/x/sync/errgroup"
+	"sync"
 
 	"github.com/akeil/rmtool"
 )
@@ -16,7 +15,7 @@ var typesByExt = map[string]rmtool.FileType{
 	rmtool.Pdf.Ext(): rmtool.Pdf,
 }
 
-func doPut(s settings, paths []string) error {
+func doPut(s settings, paths []string, jobs int) error {
 	src, dst := normalizeSrcDst(paths)
 
 	if len(src) == 0 {
@@ -77,19 +76,27 @@ func doPut(s settings, paths []string) error {
 	// currently, this will lead to duplicate names in the same folder
 	// technically OK, but not what we want
 
-	var group errgroup.Group
+	results := make([]itemResult, 0)
+	var mx sync.Mutex
+	group := newJobGroup(jobs)
 	for _, s := range src {
 		srcPath := s // scope
 		group.Go(func() error {
-			return uploadPdf(repo, srcPath, dstName, dstNode)
+			res, err := uploadPdf(repo, srcPath, dstName, dstNode)
+			mx.Lock()
+			results = append(results, res)
+			mx.Unlock()
+			return err
 		})
 	}
 
-	return group.Wait()
+	err = group.Wait()
+	out.result(results)
+	return err
 }
 
 // upload a single pdf
-func uploadPdf(repo rmtool.Repository, src string, dstName string, dstNode *rmtool.Node) error {
+func uploadPdf(repo rmtool.Repository, src string, dstName string, dstNode *rmtool.Node) (itemResult, error) {
 	if dstName == "" {
 		_, file := filepath.Split(src)
 		ext := filepath.Ext(file)
@@ -105,17 +112,19 @@ func uploadPdf(repo rmtool.Repository, src string, dstName string, dstNode *rmto
 		return f, nil
 	})
 	if err != nil {
-		return err
+		out.failure("%v Failed to upload %q: %v", crossmark, src, err)
+		return newItemResult("", dstName, src, err), err
 	}
 
-	fmt.Printf("%v upload %q\n", ellipsis, doc.Name())
+	out.progress("%v upload %q", ellipsis, doc.Name())
 	err = repo.Upload(doc)
 	if err != nil {
-		return err
+		out.failure("%v Failed to upload %q: %v", crossmark, doc.Name(), err)
+		return newItemResult(doc.ID(), doc.Name(), src, err), err
 	}
 
-	fmt.Printf("%v %q uploaded\n", checkmark, doc.Name())
-	return nil
+	out.progress("%v %q uploaded", checkmark, doc.Name())
+	return newItemResult(doc.ID(), doc.Name(), src, nil), nil
 }
 
 // determine the upload destination from a given destination path.
@@ -198,10 +207,9 @@ func normalizeSrcDst(paths []string) ([]string, string) {
 	// Glob() will also filter any non-existing files
 	// TODO: this might be redundant
 	for _, s := range temp {
-		fmt.Printf("expand %v\n", s)
 		matches, err := filepath.Glob(s)
 		if err != nil {
-			fmt.Println(err)
+			out.failure("Invalid path %q: %v", s, err)
 			continue
 		}
 		src = append(src, matches...)
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/akeil/rmtool/internal/fs"
+	"github.com/akeil/rmtool/pkg/lines"
+	"github.com/akeil/rmtool/pkg/render"
+)
+
+// doRender renders a single .rm file to PNG, JPEG or PDF.
+//
+// If no output path is given, the output is written next to the source file
+// with the extension for the format.
+func doRender(s settings, src, dst, format, palette string, quality int) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d, err := lines.ReadDrawing(f)
+	if err != nil {
+		return err
+	}
+
+	if dst == "" {
+		dst = strings.TrimSuffix(src, filepath.Ext(src)) + "." + format
+	}
+
+	p, err := parsePalette(palette)
+	if err != nil {
+		return err
+	}
+	rc := render.NewContext(s.renderDataDir, p)
+	rc.Quality = quality
+
+	if format == "jpeg" {
+		rc.ImageFormat = render.JPEG
+	}
+
+	// a failed render must not leave a partial file
+	err = fs.WriteFile(dst, func(w io.Writer) error {
+		if format == "pdf" {
+			return rc.DrawingPdf(d, w)
+		}
+		return rc.Drawing(d, w)
+	})
+	if err != nil {
+		return err
+	}
+
+	out.progress("%v Saved %q as %q", checkmark, src, dst)
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akeil/rmtool/pkg/lines"
+)
+
+func TestRenderFailure(t *testing.T) {
+	out.quiet = true
+
+	dir, err := ioutil.TempDir("", "rm-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := lines.NewDrawing()
+	d.Layers[0].Strokes = []lines.Stroke{{
+		BrushType:  lines.Ballpoint,
+		BrushColor: lines.Black,
+		BrushSize:  lines.Medium,
+		Dots:       []lines.Dot{{X: 10, Y: 10, Width: 2}, {X: 20, Y: 20, Width: 2}},
+	}}
+	src := filepath.Join(dir, "page.rm")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lines.WriteDrawing(f, d)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the data dir has no spritesheet, so rendering the stroke fails
+	s := settings{renderDataDir: filepath.Join(dir, "missing")}
+	for _, format := range []string{"png", "pdf"} {
+		dst := filepath.Join(dir, "page."+format)
+		err = doRender(s, src, dst, format, defaultPalette, 0)
+		if err == nil {
+			t.Errorf("expected error for %v without spritesheet", format)
+		}
+		_, err = os.Stat(dst)
+		if !os.IsNotExist(err) {
+			t.Errorf("expected no %v after failed render, got %v", format, err)
+		}
+	}
+}
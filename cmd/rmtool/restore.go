@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/akeil/rmtool"
+)
+
+func doRestore(s settings, match, dst string) error {
+	repo, err := setupRepo(s)
+	if err != nil {
+		return err
+	}
+
+	restorer, ok := repo.(rmtool.Restorer)
+	if !ok {
+		return fmt.Errorf("repository does not support restore")
+	}
+
+	items, err := repo.List()
+	if err != nil {
+		return err
+	}
+
+	root := rmtool.BuildTree(items)
+
+	// Determine the destination folder, default is root
+	parentID := ""
+	if dst != "" {
+		folders := make([]*rmtool.Node, 0)
+		root.WithoutTrash().Walk(func(n *rmtool.Node) error {
+			if n.ParentNode != nil && rmtool.IsFolder(n) && rmtool.MatchPath(dst)(n) {
+				folders = append(folders, n)
+			}
+			return nil
+		})
+		if len(folders) != 1 {
+			return fmt.Errorf("destination folder %q does not exist", dst)
+		}
+		parentID = folders[0].ID()
+	}
+
+	matches := rmtool.MatchName(match)
+	found := false
+
+	var group errgroup.Group
+	for _, n := range root.TrashedItems() {
+		if !matches(n) {
+			continue
+		}
+		found = true
+		item := n // scope
+		group.Go(func() error {
+			err := restorer.Restore(item.ID(), parentID)
+			if err != nil {
+				out.failure("%v Failed to restore %q: %v", crossmark, item.Name(), err)
+			} else {
+				out.progress("%v Restored %q", checkmark, item.Name())
+			}
+			return err
+		})
+	}
+
+	if !found {
+		out.progress("No matching items in trash for %q", match)
+		return nil
+	}
+
+	return group.Wait()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sinceLayouts are the accepted formats for absolute dates with --since.
+var sinceLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04",
+	time.RFC3339,
+}
+
+// parseSince parses the value for the --since flag.
+//
+// The value can be an absolute date like "2024-01-01" (local time)
+// or a duration relative to now, like "7d", "2w" or "12h".
+func parseSince(s string) (time.Time, error) {
+	for _, layout := range sinceLayouts {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	d, err := parseAge(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid value for since %q, use a date (2006-01-02) or an age (7d)", s)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// parseAge parses a duration with the additional units "d" (days)
+// and "w" (weeks). Negative durations are not allowed.
+func parseAge(s string) (time.Duration, error) {
+	d, err := parseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative age %q", s)
+	}
+	return d, nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+	for suffix, unit := range units {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	return time.ParseDuration(s)
+}
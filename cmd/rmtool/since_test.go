@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		value string
+		want  time.Time
+		valid bool
+	}{
+		{"2024-01-15", time.Date(2024, 1, 15, 0, 0, 0, 0, time.Local), true},
+		{"2024-01-15T08:30", time.Date(2024, 1, 15, 8, 30, 0, 0, time.Local), true},
+		{"2024-01-15T08:30:00Z", time.Date(2024, 1, 15, 8, 30, 0, 0, time.UTC), true},
+		{"7d", now.Add(-7 * 24 * time.Hour), true},
+		{"2w", now.Add(-14 * 24 * time.Hour), true},
+		{"12h", now.Add(-12 * time.Hour), true},
+		{"90m", now.Add(-90 * time.Minute), true},
+		{"0d", now, true},
+		{"-7d", time.Time{}, false},
+		{"-12h", time.Time{}, false},
+		{"7x", time.Time{}, false},
+		{"d", time.Time{}, false},
+		{"2024-13-01", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+
+	for _, c := range cases {
+		got, err := parseSince(c.value)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("expected error for %q, got %v", c.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", c.value, err)
+			continue
+		}
+		// relative values depend on the current time
+		diff := got.Sub(c.want)
+		if diff < -time.Minute || diff > time.Minute {
+			t.Errorf("unexpected time for %q: %v, want %v", c.value, got, c.want)
+		}
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	cases := []struct {
+		value string
+		want  time.Duration
+		valid bool
+	}{
+		{"1d", 24 * time.Hour, true},
+		{"3w", 21 * 24 * time.Hour, true},
+		{"36h", 36 * time.Hour, true},
+		{"-1d", 0, false},
+		{"-1w", 0, false},
+		{"-1h", 0, false},
+		{"1.5d", 0, false},
+		{"abc", 0, false},
+	}
+
+	for _, c := range cases {
+		got, err := parseAge(c.value)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("expected error for %q, got %v", c.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", c.value, err)
+		} else if got != c.want {
+			t.Errorf("unexpected duration for %q: %v, want %v", c.value, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"github.com/akeil/rmtool/internal/sprites"
+)
+
+// doGenSprites creates sprites.png and sprites.json from the brush images
+// in srcDir. This is a tool for development, see also scripts/mksprite.go.
+func doGenSprites(srcDir, dstBase string) error {
+	out.progress("Create spritesheet %v.png from %q", dstBase, srcDir)
+	err := sprites.Make(srcDir, dstBase)
+	if err != nil {
+		return err
+	}
+	out.progress("%v Wrote %v.png and %v.json", checkmark, dstBase, dstBase)
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/pkg/lines"
+)
+
+// validateResult is the machine readable result for a single document
+// checked by the validate command.
+type validateResult struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// doValidate reads all matching documents with their drawings and reports
+// validation errors. Returns an error if at least one document is invalid.
+func doValidate(s settings, match string, jobs int) error {
+	repo, err := setupRepo(s)
+	if err != nil {
+		return err
+	}
+
+	items, err := repo.List()
+	if err != nil {
+		return err
+	}
+
+	root := rmtool.BuildTree(items)
+	root = root.Filtered(rmtool.IsDocument, rmtool.MatchName(match))
+
+	results := make([]validateResult, 0)
+	var mx sync.Mutex
+	group := newJobGroup(jobs)
+	root.Walk(func(n *rmtool.Node) error {
+		if n.Type() == rmtool.CollectionType {
+			return nil
+		}
+		group.Go(func() error {
+			res := validateDocument(repo, n)
+			mx.Lock()
+			results = append(results, res)
+			mx.Unlock()
+			return nil
+		})
+		return nil
+	})
+	group.Wait()
+
+	invalid := 0
+	for _, res := range results {
+		if !res.Valid {
+			invalid++
+		}
+	}
+
+	if !out.result(results) && len(results) == 0 {
+		out.progress("No matching documents for %q", match)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d documents are invalid", invalid, len(results))
+	}
+	return nil
+}
+
+// validateDocument reads the given document fully and validates
+// each drawing and the document itself.
+func validateDocument(repo rmtool.Repository, n *rmtool.Node) validateResult {
+	res := validateResult{ID: n.ID(), Name: n.Name(), Errors: make([]string, 0)}
+	fail := func(format string, v ...interface{}) {
+		msg := fmt.Sprintf(format, v...)
+		res.Errors = append(res.Errors, msg)
+		out.failure("%v %q: %v", crossmark, n.Name(), msg)
+	}
+
+	doc, err := rmtool.ReadDocument(repo, n)
+	if err != nil {
+		fail("failed to read document: %v", err)
+		return res
+	}
+
+	// loads all pages and drawings, Validate checks cached drawings only
+	err = doc.EachPage(func(p *rmtool.Page, d *lines.Drawing) error {
+		if d == nil {
+			return nil
+		}
+		err := d.Validate()
+		if err != nil {
+			fail("page %v: %v", p.Number(), err)
+		}
+		return nil
+	})
+	if err != nil {
+		fail("failed to read pages: %v", err)
+		return res
+	}
+
+	err = doc.Validate()
+	if err != nil {
+		fail("%v", err)
+	}
+
+	res.Valid = len(res.Errors) == 0
+	if res.Valid {
+		out.progress("%v %q is valid", checkmark, n.Name())
+	}
+	return res
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// formats lists the versions of the .rm format that can be read and written
+// (see pkg/lines).
+var formats = []string{"v3", "v5"}
+
+// backends lists the available storage backends.
+var backends = []string{"api (reMarkable cloud)", "fs (local files)"}
+
+func doVersion() error {
+	version := "unknown"
+	info, ok := debug.ReadBuildInfo()
+	if ok {
+		version = info.Main.Version
+	}
+
+	fmt.Printf("rmtool %v\n", version)
+	fmt.Printf("Go version:   %v (%v/%v)\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("Read format:  %v\n", strings.Join(formats, ", "))
+	fmt.Printf("Write format: %v\n", strings.Join(formats, ", "))
+	fmt.Printf("Backends:     %v\n", strings.Join(backends, ", "))
+
+	return nil
+}
@@ -1,10 +1,15 @@
 package rmtool
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -17,11 +22,14 @@ import (
 // and Drawings.
 //
 // A Document is internally backed by a Repository and can load additional
-// content as it is requested.
+// content as it is requested. Its methods can be called concurrently.
 type Document struct {
 	Meta
-	content          *Content
-	pagedata         []string
+	content *Content
+	// pagedata is loaded lazily, guarded by pagedataMx.
+	pagedata   []string
+	pagedataMx sync.Mutex
+	// pages caches loaded pages; pagesMx also guards the list of pages.
 	pages            map[string]*Page
 	pagesMx          sync.Mutex
 	drawings         map[string]*lines.Drawing
@@ -39,14 +47,44 @@ func NewNotebook(name, parentID string) *Document {
 	return d
 }
 
+// NewNotebookWithOrientation creates a new notebook like NewNotebook,
+// but with the given base orientation.
+//
+// The orientation is also recorded in the pagedata for new pages.
+// Returns an error if the orientation is not valid.
+func NewNotebookWithOrientation(name, parentID string, o Orientation) (*Document, error) {
+	err := validateOrientation(o)
+	if err != nil {
+		return nil, err
+	}
+
+	d := newDocument(name, parentID, Notebook, nil)
+	d.content.Orientation = o
+	d.CreatePage()
+	return d, nil
+}
+
 // NewPdf creates a new document for a PDF file.
 //
 // The given AttachmentReader should return a Reader for the PDF file.
 // Note that this can return an error as the PDF needs to be read for this.
 func NewPdf(name, parentID string, r AttachmentReader) (*Document, error) {
+	return NewPdfWithOrientation(name, parentID, r, Portrait)
+}
+
+// NewPdfWithOrientation creates a new document for a PDF file like NewPdf,
+// but with the given base orientation, e.g. for PDFs with landscape pages.
+//
+// Returns an error if the orientation is not valid.
+func NewPdfWithOrientation(name, parentID string, r AttachmentReader, o Orientation) (*Document, error) {
+	err := validateOrientation(o)
+	if err != nil {
+		return nil, err
+	}
+
 	d := newDocument(name, parentID, Pdf, r)
-	err := d.createPdfPages()
-	// TODO: orientation of the document?
+	d.content.Orientation = o
+	err = d.createPdfPages()
 	return d, err
 }
 
@@ -80,8 +118,12 @@ func (d *Document) Validate() error {
 	}
 
 	// len pagedata must match the number of pages
-	if len(d.pagedata) != d.PageCount() {
-		return errors.NewValidationError("number of pagedata entries does not match page count: %v != %v", len(d.pagedata), d.PageCount())
+	pd, err := d.loadPagedata()
+	if err != nil {
+		return err
+	}
+	if len(pd) != d.PageCount() {
+		return errors.NewValidationError("number of pagedata entries does not match page count: %v != %v", len(pd), d.PageCount())
 	}
 
 	switch d.FileType() {
@@ -106,17 +148,25 @@ func (d *Document) validateNotebook() error {
 	if d.PageCount() < 1 {
 		return errors.NewValidationError("notbeook must have at least one page")
 	}
-	// TODO: checking only cached drawings means we can validate
-	// fully loaded or new notebooks only
 	for _, pageID := range d.Pages() {
-		dr := d.drawings[pageID]
-		if dr == nil {
-			return errors.NewValidationError("page %q has no associated drawing", pageID)
-		}
-		err := dr.Validate()
+		// Do not load drawings from the backing repository just to validate,
+		// for those we only check that they exist.
+		ok, err := d.HasDrawing(pageID)
 		if err != nil {
 			return err
 		}
+		if !ok {
+			return errors.NewValidationError("page %q has no associated drawing", pageID)
+		}
+		d.drawingsMx.Lock()
+		dr := d.drawings[pageID]
+		d.drawingsMx.Unlock()
+		if dr != nil {
+			err = dr.Validate()
+			if err != nil {
+				return err
+			}
+		}
 
 		// TODO must have PageMetadata with at least one layer
 	}
@@ -126,7 +176,8 @@ func (d *Document) validateNotebook() error {
 
 // for PDF or EPUB
 func (d *Document) validateAttachment() error {
-	if d.attachmentReader == nil {
+	// documents from a repository read the attachment from there
+	if d.attachmentReader == nil && d.repo == nil {
 		return errors.NewValidationError("missing attachment reader")
 	}
 	// TODO - do we need more validation?
@@ -178,7 +229,11 @@ func (d *Document) writeContent(w WriterFunc) error {
 	if err != nil {
 		return err
 	}
-	err = WritePagedata(d.pagedata, pw)
+	pd, err := d.loadPagedata()
+	if err != nil {
+		return err
+	}
+	err = WritePagedata(pd, pw)
 	if err != nil {
 		return err
 	}
@@ -190,25 +245,22 @@ func (d *Document) writeContent(w WriterFunc) error {
 // writes the drawings (.rm) and the metadata for each page that has a drawing.
 // writes nothing for pages w/o drawing
 func (d *Document) writePages(repo Repository, w WriterFunc) error {
-	d.pagesMx.Lock()
-	d.drawingsMx.Lock()
-	defer d.pagesMx.Unlock()
-	defer d.drawingsMx.Unlock()
-
 	for i, pageID := range d.Pages() {
-		// we do not have a backing repository and can only write cached drawing
-		// TODO: this does not feel like the "right" way to do it
-		dr := d.drawings[pageID]
-		if dr == nil {
+		// Drawings which are not cached are read from the backing repository
+		// as we go, we do not need to load the complete document up front.
+		dr, err := d.Drawing(pageID)
+		if errors.IsNotFound(err) {
+			// e.g. PDF pages without annotations
 			logging.Debug("Page %q has no drawing", pageID)
 			continue
+		} else if err != nil {
+			return err
 		}
 
-		// TODO relies on all pages being cached
 		logging.Debug("Write page metadata for %v", pageID)
-		p := d.pages[pageID]
-		if p == nil {
-			return fmt.Errorf("missing page metadata for page %q", pageID)
+		p, err := d.Page(pageID)
+		if err != nil {
+			return err
 		}
 		prefix := repo.PagePrefix(pageID, i)
 		pmw, err := w(d.ID(), prefix+"-metadata.json")
@@ -263,7 +315,8 @@ func (d *Document) writeAttachment(w WriterFunc) error {
 }
 
 // CreatePage creates a new page with a drawing and append it to the document.
-// TODO: Orientation? Template?
+// The page uses the document orientation.
+// TODO: Template?
 func (d *Document) CreatePage() string {
 	pgMeta := &PageMetadata{
 		Layers: []LayerMetadata{
@@ -291,13 +344,19 @@ func (d *Document) createPdfPages() error {
 		return err
 	}
 
-	numPages, err := countPdfPages(rc)
+	dims, err := readPdfPageDims(rc)
 	if err != nil {
 		return err
 	}
 
-	for i := 0; i < numPages; i++ {
-		d.addPage(nil)
+	// Take the orientation for each page from its media box,
+	// so that landscape pages are displayed and exported correctly.
+	for _, dim := range dims {
+		o := Portrait
+		if dim.Width > dim.Height {
+			o = Landscape
+		}
+		d.addPageWithOrientation(nil, o)
 	}
 
 	return nil
@@ -305,6 +364,12 @@ func (d *Document) createPdfPages() error {
 
 // adds an empty page WITHOUT drawing
 func (d *Document) addPage(pgMeta *PageMetadata) string {
+	return d.addPageWithOrientation(pgMeta, d.content.Orientation)
+}
+
+// adds an empty page WITHOUT drawing and with the given orientation,
+// which may differ from the document orientation.
+func (d *Document) addPageWithOrientation(pgMeta *PageMetadata, o Orientation) string {
 	d.pagesMx.Lock()
 	defer d.pagesMx.Unlock()
 
@@ -313,14 +378,17 @@ func (d *Document) addPage(pgMeta *PageMetadata) string {
 	d.content.Pages = append(d.content.Pages, pageID)
 	d.content.PageCount++
 
-	index := len(d.pagedata) // we'll append later, so index == size
-
-	d.pagedata = append(d.pagedata, blankTemplate)
+	tpl := blankPagedata(o, d.content.Orientation)
+	d.pagedataMx.Lock()
+	index := len(d.pagedata)
+	d.pagedata = append(d.pagedata, tpl)
+	d.pagedataMx.Unlock()
 
 	p := &Page{
+		id:       pageID,
 		index:    index,
 		meta:     pgMeta,
-		pagedata: blankTemplate,
+		pagedata: tpl,
 	}
 
 	// page cache
@@ -332,17 +400,36 @@ func (d *Document) addPage(pgMeta *PageMetadata) string {
 	return pageID
 }
 
+// blankPagedata is the pagedata line for an empty page.
+// Portrait pages in a portrait document use the plain "Blank" template like
+// the tablet does. Otherwise, the page orientation is given as a prefix.
+func blankPagedata(page, doc Orientation) string {
+	if page == Portrait && doc == Portrait {
+		return blankTemplate
+	}
+	pd := Pagedata{
+		Orientation:    page,
+		HasOrientation: true,
+		Template:       blankTemplate,
+	}
+	return pd.String()
+}
+
 // PageCount returns the number of pages in this document.
 //
 // Note that for PDF and EPUB files, the number of drawings can be less than
 // the number of pages.
 func (d *Document) PageCount() int {
+	d.pagesMx.Lock()
+	defer d.pagesMx.Unlock()
 	return d.content.PageCount
 }
 
 // Pages returns a list of page IDs on the correct order.
 func (d *Document) Pages() []string {
-	return d.content.Pages
+	d.pagesMx.Lock()
+	defer d.pagesMx.Unlock()
+	return append([]string(nil), d.content.Pages...)
 }
 
 // FileType is one of the supported types of content (Notebook, PDF, EPUB).
@@ -355,10 +442,122 @@ func (d *Document) Orientation() Orientation {
 	return d.content.Orientation
 }
 
-// CoverPage is the number of the page that should be used as a cover.
+// EffectiveOrientation is the orientation of the page with the given ID.
+//
+// The orientation is taken from the page's template if it has an
+// orientation prefix ("P" or "LS"). Otherwise, or if the page cannot be
+// loaded, the document's base Orientation is returned.
+func (d *Document) EffectiveOrientation(pageID string) Orientation {
+	pg, err := d.Page(pageID)
+	if err != nil {
+		logging.Debug("Could not load page %q, use document orientation: %v", pageID, err)
+		return d.Orientation()
+	}
+
+	pd, err := ParsePagedataLine(pg.Template())
+	if err != nil || !pd.HasOrientation {
+		return d.Orientation()
+	}
+
+	return pd.Orientation
+}
+
+// CoverPage is the 1-based number of the page that should be used as a cover.
+//
+// This is the page set with SetCoverPage. If no cover page is set
+// (or it does not refer to an existing page), the last opened page is used
+// and if that is not known either, the first page.
 func (d *Document) CoverPage() int {
-	// fallback on lastOpenedPage ?
-	return d.content.CoverPageNumber
+	n := d.content.CoverPageNumber
+	if n >= 1 && n <= d.PageCount() {
+		return n
+	}
+
+	last := d.LastOpenedPage()
+	if last >= 0 && last < d.PageCount() {
+		return last + 1
+	}
+
+	return 1
+}
+
+// Transform is the transform from drawing coordinates to page coordinates.
+// This is the identity transform for most documents.
+func (d *Document) Transform() Transform {
+	return d.content.Transform
+}
+
+// IsDummy tells if this is a placeholder document created by the tablet.
+// Dummy documents have no content that would be useful to render.
+func (d *Document) IsDummy() bool {
+	return d.content.DummyDocument
+}
+
+// SetEpubFont sets the name of the font that is used to display an EPUB.
+// Set to the empty string to use the default font.
+func (d *Document) SetEpubFont(name string) {
+	d.content.FontName = name
+}
+
+// SetMargins sets the page margins for EPUB and PDF documents.
+func (d *Document) SetMargins(m int) {
+	d.content.Margins = m
+}
+
+// SetLineHeight sets the line height for EPUB documents.
+func (d *Document) SetLineHeight(lh LineHeight) {
+	d.content.LineHeight = lh
+}
+
+// SetTextAlignment sets the text alignment for EPUB documents.
+func (d *Document) SetTextAlignment(ta TextAlign) {
+	d.content.TextAlignment = ta
+}
+
+// SetTextScale sets the scale factor for the text size of EPUB documents.
+// The default is 1.0.
+func (d *Document) SetTextScale(s float32) {
+	d.content.TextScale = s
+}
+
+// SetCoverPage sets the number of the page that should be used as a cover.
+//
+// The number must refer to an existing page (1..PageCount).
+// Use -1 to remove the cover page setting.
+// Returns a validation error if the page number is out of range.
+func (d *Document) SetCoverPage(n int) error {
+	if n != defaultCoverPage && (n < 1 || n > d.PageCount()) {
+		return errors.NewValidationError("cover page %v is not an existing page", n)
+	}
+
+	d.content.CoverPageNumber = n
+	return nil
+}
+
+// Labels returns the key/value labels for this document.
+//
+// Labels are stored with the document but are not used by the tablet.
+func (d *Document) Labels() map[string]string {
+	labels := make(map[string]string)
+	for k, v := range d.content.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// SetLabel adds a key/value label to this document or changes the value
+// of an existing label.
+// Setting the value to the empty string removes the label.
+func (d *Document) SetLabel(key, value string) {
+	if value == "" {
+		delete(d.content.Labels, key)
+		return
+	}
+
+	if d.content.Labels == nil {
+		d.content.Labels = make(map[string]string)
+	}
+	d.content.Labels[key] = value
 }
 
 // Page loads meta data associated with the given pageID.
@@ -373,35 +572,31 @@ func (d *Document) Page(pageID string) (*Page, error) {
 		}
 	}
 
-	idx, err := d.pageIndex(pageID)
+	// pagesMx is held, use the unguarded list of pages
+	idx, err := indexOf(d.content.Pages, pageID)
 	if err != nil {
 		return nil, err
 	}
 
-	// lazy load pagedata, guarded by pagesMx
-	if d.pagedata == nil {
-		pdp := d.ID() + ".pagedata"
-		logging.Debug("Read pagedata from %q", pdp)
-		pdr, err := d.reader(pdp)
-		if err != nil {
-			return nil, err
-		}
-		defer pdr.Close()
-		pd, err := ReadPagedata(pdr)
-		if err != nil {
-			return nil, err
-		}
-		d.pagedata = pd
+	pd, err := d.loadPagedata()
+	if err != nil {
+		return nil, err
 	}
 
 	// check if we have pagedata for this page
-	if len(d.pagedata) <= idx {
+	if len(pd) <= idx {
 		return nil, fmt.Errorf("no pagedata for page with id %q", pageID)
 	}
 
+	// New documents are not backed by a repository,
+	// all their pages are cached.
+	if d.repo == nil {
+		return nil, errors.NewNotFound("no page metadata for page %q", pageID)
+	}
+
 	// Load page metadata
 	pm := &PageMetadata{}
-	pmp := d.repo.PagePrefix(d.ID(), idx) + "-metadata.json"
+	pmp := d.repo.PagePrefix(pageID, idx) + "-metadata.json"
 	logging.Debug("Read page metadata from %q", pmp)
 	pmr, err := d.reader(d.ID(), pmp)
 	if err != nil {
@@ -427,9 +622,10 @@ func (d *Document) Page(pageID string) (*Page, error) {
 
 	// construct the Page item
 	p := &Page{
+		id:       pageID,
 		index:    idx,
 		meta:     pm,
-		pagedata: d.pagedata[idx],
+		pagedata: pd[idx],
 	}
 
 	// cache
@@ -441,12 +637,70 @@ func (d *Document) Page(pageID string) (*Page, error) {
 	return p, nil
 }
 
+// loadPagedata returns a copy of the pagedata for this document,
+// loading it from the repository on first use.
+func (d *Document) loadPagedata() ([]string, error) {
+	d.pagedataMx.Lock()
+	defer d.pagedataMx.Unlock()
+
+	if d.pagedata == nil && d.repo != nil {
+		pdp := d.ID() + ".pagedata"
+		logging.Debug("Read pagedata from %q", pdp)
+		pdr, err := d.reader(pdp)
+		if err != nil {
+			return nil, err
+		}
+		defer pdr.Close()
+		pd, err := ReadPagedata(pdr)
+		if err != nil {
+			return nil, err
+		}
+		d.pagedata = pd
+	}
+
+	return append([]string(nil), d.pagedata...), nil
+}
+
+// UsedTemplates returns the distinct names of the background templates
+// used by the pages of this document, in order of their first use.
+//
+// Pages with the "Blank" template are skipped.
+// The names are the same as Page.Template(), e.g. "P Lines medium".
+// This can be used to check that all templates are available before
+// rendering a document.
+func (d *Document) UsedTemplates() ([]string, error) {
+	pd, err := d.loadPagedata()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, tpl := range pd {
+		p := Page{pagedata: tpl}
+		if !p.HasTemplate() || seen[tpl] {
+			continue
+		}
+		seen[tpl] = true
+		names = append(names, tpl)
+	}
+
+	return names, nil
+}
+
 // Drawing loads the handwritten drawing for the given pageID.
 //
 // Note that not all pages have associated drawings.
 // If a page has no drawing, an error of type "Not Found" is returned
 // (use IsNotFound(err) to check for this).
 func (d *Document) Drawing(pageID string) (*lines.Drawing, error) {
+	// look up the index first, pagesMx must not be locked
+	// while holding drawingsMx
+	idx, err := d.pageIndex(pageID)
+	if err != nil {
+		return nil, err
+	}
+
 	d.drawingsMx.Lock()
 	defer d.drawingsMx.Unlock()
 
@@ -458,12 +712,13 @@ func (d *Document) Drawing(pageID string) (*lines.Drawing, error) {
 		return cached, nil
 	}
 
-	idx, err := d.pageIndex(pageID)
-	if err != nil {
-		return nil, err
+	// New documents are not backed by a repository,
+	// all their drawings are cached.
+	if d.repo == nil {
+		return nil, errors.NewNotFound("no drawing for page %q", pageID)
 	}
 
-	dp := d.repo.PagePrefix(d.ID(), idx) + ".rm"
+	dp := d.repo.PagePrefix(pageID, idx) + ".rm"
 	logging.Debug("Read drawing from %q", dp)
 	dr, err := d.reader(d.ID(), dp)
 	if err != nil {
@@ -481,6 +736,182 @@ func (d *Document) Drawing(pageID string) (*lines.Drawing, error) {
 	return drawing, nil
 }
 
+// HasDrawing tells if the given page has an associated drawing.
+//
+// Unlike Drawing(), this does not return an error for pages without drawing.
+// An error is returned if the pageID is invalid or if the presence of the
+// drawing cannot be determined.
+func (d *Document) HasDrawing(pageID string) (bool, error) {
+	idx, err := d.pageIndex(pageID)
+	if err != nil {
+		return false, err
+	}
+
+	d.drawingsMx.Lock()
+	defer d.drawingsMx.Unlock()
+
+	if d.drawings != nil && d.drawings[pageID] != nil {
+		return true, nil
+	}
+
+	// New documents are not backed by a repository,
+	// all their drawings are cached.
+	if d.repo == nil {
+		return false, nil
+	}
+
+	dp := d.repo.PagePrefix(pageID, idx) + ".rm"
+	logging.Debug("Check for drawing at %q", dp)
+	dr, err := d.reader(d.ID(), dp)
+	if errors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	dr.Close()
+
+	return true, nil
+}
+
+// PageInfo summarizes a single page, e.g. to display a list of pages.
+type PageInfo struct {
+	// ID is the unique identifier for the page.
+	ID string
+	// Index is the 0-based position of the page in the document.
+	Index int
+	// Number is the 1-based page number.
+	Number uint
+	// HasDrawing tells if the page has a handwritten drawing.
+	HasDrawing bool
+	// Template is the name of the background template.
+	Template string
+	// Orientation is the effective orientation of the page.
+	Orientation Orientation
+}
+
+// PageInfos returns a PageInfo for each page in the document, in page order.
+//
+// Pages are loaded from the repository if needed.
+// It is safe to call this concurrently with other methods of the Document.
+func (d *Document) PageInfos() ([]PageInfo, error) {
+	pageIDs := d.Pages()
+	infos := make([]PageInfo, len(pageIDs))
+	for i, pageID := range pageIDs {
+		p, err := d.Page(pageID)
+		if err != nil {
+			return nil, err
+		}
+
+		has, err := d.HasDrawing(pageID)
+		if err != nil {
+			return nil, err
+		}
+
+		infos[i] = PageInfo{
+			ID:          pageID,
+			Index:       i,
+			Number:      p.Number(),
+			HasDrawing:  has,
+			Template:    p.Template(),
+			Orientation: d.EffectiveOrientation(pageID),
+		}
+	}
+
+	return infos, nil
+}
+
+// EachPage calls the given function for every page in the document,
+// in page order, together with the page's drawing.
+//
+// Pages without a drawing are passed with a nil drawing.
+// Iteration stops at the first error, either from loading a page or drawing
+// or returned by the function.
+func (d *Document) EachPage(f func(p *Page, drawing *lines.Drawing) error) error {
+	for _, pageID := range d.Pages() {
+		p, err := d.Page(pageID)
+		if err != nil {
+			return err
+		}
+
+		has, err := d.HasDrawing(pageID)
+		if err != nil {
+			return err
+		}
+		var drawing *lines.Drawing
+		if has {
+			drawing, err = d.Drawing(pageID)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = f(p, drawing)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ContentHash computes a hash over the content, page templates and drawings
+// of this document.
+//
+// The hash does not depend on the version or modification time.
+// It can be stored alongside exported files to detect whether the document
+// has actually changed.
+// Note that any change to the content, e.g. selecting a different pen,
+// changes the hash even if the drawings are the same.
+func (d *Document) ContentHash() (string, error) {
+	h := sha256.New()
+
+	err := json.NewEncoder(h).Encode(d.content)
+	if err != nil {
+		return "", err
+	}
+	pd, err := d.loadPagedata()
+	if err != nil {
+		return "", err
+	}
+	err = WritePagedata(pd, h)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = d.EachPage(func(p *Page, drawing *lines.Drawing) error {
+		buf.Reset()
+		if drawing != nil {
+			err := lines.WriteDrawing(&buf, drawing)
+			if err != nil {
+				return err
+			}
+		}
+		// length prefix so that page boundaries are unambiguous
+		fmt.Fprintf(h, "%v %d\n", p.ID(), buf.Len())
+		_, err := h.Write(buf.Bytes())
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// EstimatedSize returns the approximate size of this document in bytes,
+// including drawings and attachments.
+//
+// Returns UnknownSize if the size cannot be determined,
+// e.g. because the repository does not support it or for new documents.
+func (d *Document) EstimatedSize() (int64, error) {
+	s, ok := d.repo.(Sizer)
+	if !ok {
+		return UnknownSize, nil
+	}
+	return s.Size(d.ID(), d.Version())
+}
+
 // AttachmentReader returns a reader for an associated PDF or EPUB files
 // according to FileType().
 //
@@ -496,14 +927,164 @@ func (d *Document) AttachmentReader() (io.ReadCloser, error) {
 		return nil, fmt.Errorf("document of type %v has no attachment", d.FileType())
 	}
 
+	// newly created documents are not yet backed by a repository
+	if d.attachmentReader != nil {
+		return d.attachmentReader()
+	}
+
 	logging.Debug("Read attachment from %q", p)
 	return d.reader(p)
 }
 
+// documentJSON is the JSON representation of a fully loaded Document.
+type documentJSON struct {
+	ID             string                   `json:"id"`
+	Version        uint                     `json:"version"`
+	Name           string                   `json:"name"`
+	Type           NotebookType             `json:"type"`
+	Pinned         bool                     `json:"pinned"`
+	LastModified   time.Time                `json:"lastModified"`
+	Parent         string                   `json:"parent"`
+	LastOpenedPage int                      `json:"lastOpenedPage"`
+	Content        *Content                 `json:"content"`
+	Pagedata       []string                 `json:"pagedata"`
+	Pages          map[string]*PageMetadata `json:"pages,omitempty"`
+	Drawings       map[string][]byte        `json:"drawings,omitempty"`
+	Attachment     []byte                   `json:"attachment,omitempty"`
+}
+
+// MarshalJSON writes the complete document to JSON,
+// e.g. to store a document without the repository it was read from.
+//
+// The result includes metadata, content, pagedata, page metadata and the
+// drawings for all pages, in the .rm format and base64 encoded.
+// For PDF and EPUB documents, the attachment is included as well.
+//
+// All pages and drawings are loaded from the repository if needed.
+func (d *Document) MarshalJSON() ([]byte, error) {
+	x := documentJSON{
+		ID:             d.ID(),
+		Version:        d.Version(),
+		Name:           d.Name(),
+		Type:           d.Type(),
+		Pinned:         d.Pinned(),
+		LastModified:   d.LastModified(),
+		Parent:         d.Parent(),
+		LastOpenedPage: d.LastOpenedPage(),
+		Content:        d.content,
+		Pages:          make(map[string]*PageMetadata),
+		Drawings:       make(map[string][]byte),
+	}
+
+	err := d.EachPage(func(p *Page, drawing *lines.Drawing) error {
+		if p.meta != nil {
+			x.Pages[p.ID()] = p.meta
+		}
+		if drawing == nil {
+			return nil
+		}
+		var buf bytes.Buffer
+		err := lines.WriteDrawingVersion(&buf, drawing, drawing.Version)
+		if err != nil {
+			return err
+		}
+		x.Drawings[p.ID()] = buf.Bytes()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	x.Pagedata, err = d.loadPagedata()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.FileType() == Pdf || d.FileType() == Epub {
+		rc, err := d.AttachmentReader()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		x.Attachment, err = ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(x)
+}
+
+// UnmarshalJSON reads a document that was written with MarshalJSON.
+//
+// The document is not backed by a repository, all of its content is held
+// in memory. Returns a validation error if the document is not valid.
+func (d *Document) UnmarshalJSON(b []byte) error {
+	var x documentJSON
+	err := json.Unmarshal(b, &x)
+	if err != nil {
+		return err
+	}
+	if x.Content == nil {
+		return errors.NewValidationError("missing content")
+	}
+
+	drawings := make(map[string]*lines.Drawing)
+	for pageID, data := range x.Drawings {
+		drawing, err := lines.ReadDrawing(bytes.NewReader(data))
+		if err != nil {
+			return errors.Wrap(err, "failed to read drawing for page %q", pageID)
+		}
+		drawings[pageID] = drawing
+	}
+
+	pages := make(map[string]*Page)
+	for i, pageID := range x.Content.Pages {
+		if i >= len(x.Pagedata) {
+			break
+		}
+		pages[pageID] = &Page{
+			id:       pageID,
+			index:    i,
+			meta:     x.Pages[pageID],
+			pagedata: x.Pagedata[i],
+		}
+	}
+
+	var ar AttachmentReader
+	if x.Attachment != nil {
+		ar = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(x.Attachment)), nil
+		}
+	}
+
+	d.Meta = &docMeta{
+		id:           x.ID,
+		version:      x.Version,
+		nbType:       x.Type,
+		name:         x.Name,
+		pinned:       x.Pinned,
+		lastModified: x.LastModified,
+		parent:       x.Parent,
+		lastOpened:   x.LastOpenedPage,
+	}
+	d.content = x.Content
+	d.pagedata = x.Pagedata
+	d.pages = pages
+	d.drawings = drawings
+	d.attachmentReader = ar
+	d.repo = nil
+
+	return d.Validate()
+}
+
 func (d *Document) pageIndex(pageID string) (int, error) {
-	// Check if that page id exists
-	// AND determine the page index
-	for i, id := range d.Pages() {
+	return indexOf(d.Pages(), pageID)
+}
+
+// indexOf checks if the given page ID exists in the list of pages
+// and returns its index.
+func indexOf(pageIDs []string, pageID string) (int, error) {
+	for i, id := range pageIDs {
 		if id == pageID {
 			return i, nil
 		}
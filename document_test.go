@@ -1,7 +1,18 @@
 package rmtool
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+
+	"github.com/akeil/rmtool/pkg/lines"
 )
 
 func TestNewDocument(t *testing.T) {
@@ -12,3 +23,529 @@ func TestNewDocument(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestHasDrawing(t *testing.T) {
+	d := NewNotebook("My Document", "")
+	pageID := d.Pages()[0]
+
+	has, err := d.HasDrawing(pageID)
+	if err != nil {
+		t.Error(err)
+	}
+	if !has {
+		t.Errorf("drawing for new page not found")
+	}
+
+	// page without drawing
+	pageID = d.addPage(nil)
+	has, err = d.HasDrawing(pageID)
+	if err != nil {
+		t.Error(err)
+	}
+	if has {
+		t.Errorf("unexpected drawing for page %q", pageID)
+	}
+
+	_, err = d.HasDrawing("does-not-exist")
+	if err == nil {
+		t.Errorf("invalid page id not detected")
+	}
+}
+
+func TestEpubOptions(t *testing.T) {
+	d := NewEpub("My Book", "", nil)
+	d.SetEpubFont("Noto Serif")
+	d.SetMargins(50)
+	d.SetLineHeight(LineHeightLarge)
+	d.SetTextAlignment(AlignJustify)
+	d.SetTextScale(1.5)
+
+	err := d.content.Validate()
+	if err != nil {
+		t.Error(err)
+	}
+
+	d.SetLineHeight(LineHeight(42))
+	if d.content.Validate() == nil {
+		t.Errorf("Invalid line height not detected")
+	}
+	d.SetLineHeight(LineHeightDefault)
+
+	d.SetTextScale(0)
+	if d.content.Validate() == nil {
+		t.Errorf("Invalid text scale not detected")
+	}
+}
+
+func TestSetCoverPage(t *testing.T) {
+	d := NewNotebook("My Document", "")
+	d.CreatePage()
+
+	err := d.SetCoverPage(2)
+	if err != nil {
+		t.Error(err)
+	}
+	if d.CoverPage() != 2 {
+		t.Errorf("unexpected cover page %v", d.CoverPage())
+	}
+
+	for _, n := range []int{0, 3, -2} {
+		err = d.SetCoverPage(n)
+		if err == nil {
+			t.Errorf("invalid cover page %v not detected", n)
+		}
+	}
+	if d.CoverPage() != 2 {
+		t.Errorf("cover page changed by invalid value")
+	}
+
+	err = d.SetCoverPage(-1)
+	if err != nil {
+		t.Error(err)
+	}
+	if d.CoverPage() != 1 {
+		t.Errorf("expected first page without cover, got %v", d.CoverPage())
+	}
+
+	d.SetLastOpenedPage(1)
+	if d.CoverPage() != 2 {
+		t.Errorf("expected last opened page without cover, got %v", d.CoverPage())
+	}
+	d.SetLastOpenedPage(5)
+	if d.CoverPage() != 1 {
+		t.Errorf("expected first page for invalid last opened page, got %v", d.CoverPage())
+	}
+}
+
+func TestLabels(t *testing.T) {
+	d := NewNotebook("My Document", "")
+	if len(d.Labels()) != 0 {
+		t.Errorf("new document should not have labels")
+	}
+
+	d.SetLabel("project", "X")
+	d.SetLabel("status", "draft")
+	d.SetLabel("status", "done")
+	if d.Labels()["project"] != "X" || d.Labels()["status"] != "done" {
+		t.Errorf("unexpected labels %v", d.Labels())
+	}
+
+	d.SetLabel("project", "")
+	if _, ok := d.Labels()["project"]; ok {
+		t.Errorf("label was not removed")
+	}
+}
+
+func TestEffectiveOrientation(t *testing.T) {
+	d := NewNotebook("Mixed", "")
+	d.content.Orientation = Landscape
+	blank := d.Pages()[0]
+	portrait := d.addPage(nil)
+	landscape := d.addPage(nil)
+
+	setTemplate := func(pageID, tpl string) {
+		p, err := d.Page(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.pagedata = tpl
+		d.pagedata[p.index] = tpl
+	}
+	setTemplate(portrait, "P Lines medium")
+	setTemplate(landscape, "LS Dots top")
+
+	cases := map[string]Orientation{
+		blank:     Landscape, // no prefix, falls back to document
+		portrait:  Portrait,
+		landscape: Landscape,
+	}
+	for pageID, expected := range cases {
+		actual := d.EffectiveOrientation(pageID)
+		if actual != expected {
+			t.Errorf("unexpected orientation for page %q: %v, want %v", pageID, actual, expected)
+		}
+	}
+
+	d.content.Orientation = Portrait
+	if d.EffectiveOrientation(landscape) != Landscape {
+		t.Errorf("page orientation should override document orientation")
+	}
+	if d.EffectiveOrientation(blank) != Portrait {
+		t.Errorf("expected document orientation for page without prefix")
+	}
+	if d.EffectiveOrientation("does-not-exist") != Portrait {
+		t.Errorf("expected document orientation for unknown page")
+	}
+}
+
+func TestUsedTemplates(t *testing.T) {
+	d := NewNotebook("Templates", "")
+	d.addPage(nil)
+	d.addPage(nil)
+	d.addPage(nil)
+	d.pagedata[0] = "P Lines medium"
+	d.pagedata[1] = "LS Blank"
+	d.pagedata[2] = "P Dots top"
+	d.pagedata[3] = "P Lines medium"
+
+	names, err := d.UsedTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"P Lines medium", "P Dots top"}
+	if len(names) != len(expected) {
+		t.Fatalf("unexpected templates %v, want %v", names, expected)
+	}
+	for i, name := range expected {
+		if names[i] != name {
+			t.Errorf("unexpected template at %d: %q, want %q", i, names[i], name)
+		}
+	}
+}
+
+func TestNewNotebookWithOrientation(t *testing.T) {
+	d, err := NewNotebookWithOrientation("Landscape", "", Landscape)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Orientation() != Landscape {
+		t.Errorf("unexpected orientation %v", d.Orientation())
+	}
+	err = d.Validate()
+	if err != nil {
+		t.Error(err)
+	}
+
+	pageID := d.CreatePage()
+	for _, id := range d.Pages() {
+		p, err := d.Page(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Template() != "LS Blank" {
+			t.Errorf("unexpected pagedata %q for page %q", p.Template(), id)
+		}
+		if p.HasTemplate() {
+			t.Errorf("landscape blank page %q should not have a template", id)
+		}
+	}
+	if d.EffectiveOrientation(pageID) != Landscape {
+		t.Errorf("new page should be landscape")
+	}
+
+	_, err = NewNotebookWithOrientation("Invalid", "", Orientation(42))
+	if err == nil {
+		t.Errorf("invalid orientation not detected")
+	}
+}
+
+func TestNewPdfPageOrientation(t *testing.T) {
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.AddPage()
+	pdf.AddPageFormat("L", pdf.GetPageSizeStr("A4"))
+	pdf.AddPage()
+	var buf bytes.Buffer
+	err := pdf.Output(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewPdf("Mixed", "", func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.PageCount() != 3 {
+		t.Fatalf("unexpected page count %v", d.PageCount())
+	}
+
+	expected := []Orientation{Portrait, Landscape, Portrait}
+	for i, pageID := range d.Pages() {
+		actual := d.EffectiveOrientation(pageID)
+		if actual != expected[i] {
+			t.Errorf("unexpected orientation for page %v: %v, want %v", i+1, actual, expected[i])
+		}
+	}
+
+	// portrait pages need an explicit prefix in a landscape document
+	d, err = NewPdfWithOrientation("Mixed", "", func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	}, Landscape)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, pageID := range d.Pages() {
+		actual := d.EffectiveOrientation(pageID)
+		if actual != expected[i] {
+			t.Errorf("unexpected orientation for page %v: %v, want %v", i+1, actual, expected[i])
+		}
+	}
+}
+
+func TestEachPage(t *testing.T) {
+	d := NewNotebook("My Document", "")
+	withoutDrawing := d.addPage(nil)
+	d.CreatePage()
+
+	visited := make([]string, 0)
+	err := d.EachPage(func(p *Page, drawing *lines.Drawing) error {
+		visited = append(visited, p.ID())
+		if p.ID() == withoutDrawing && drawing != nil {
+			t.Errorf("unexpected drawing for page %q", p.ID())
+		}
+		if p.ID() != withoutDrawing && drawing == nil {
+			t.Errorf("missing drawing for page %q", p.ID())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if strings.Join(visited, ",") != strings.Join(d.Pages(), ",") {
+		t.Errorf("unexpected pages %v, want %v", visited, d.Pages())
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = d.EachPage(func(p *Page, drawing *lines.Drawing) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("expected iteration to stop at first error")
+	}
+}
+
+func TestPageInfos(t *testing.T) {
+	d := NewNotebook("My Document", "")
+	withoutDrawing := d.addPageWithOrientation(nil, Landscape)
+
+	infos, err := d.PageInfos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("unexpected number of pages %v", len(infos))
+	}
+
+	first := infos[0]
+	if first.ID != d.Pages()[0] || first.Index != 0 || first.Number != 1 {
+		t.Errorf("unexpected info for first page: %+v", first)
+	}
+	if !first.HasDrawing || first.Orientation != Portrait {
+		t.Errorf("unexpected info for first page: %+v", first)
+	}
+
+	second := infos[1]
+	if second.ID != withoutDrawing || second.Index != 1 || second.Number != 2 {
+		t.Errorf("unexpected info for second page: %+v", second)
+	}
+	if second.HasDrawing || second.Orientation != Landscape || second.Template != "LS Blank" {
+		t.Errorf("unexpected info for second page: %+v", second)
+	}
+}
+
+func TestConcurrentPageAccess(t *testing.T) {
+	d := NewNotebook("Concurrent", "")
+	for i := 0; i < 5; i++ {
+		d.CreatePage()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, pageID := range d.Pages() {
+				_, err := d.Page(pageID)
+				if err != nil {
+					t.Error(err)
+				}
+				_, err = d.Drawing(pageID)
+				if err != nil {
+					t.Error(err)
+				}
+			}
+			_, err := d.UsedTemplates()
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.CreatePage()
+	}()
+	wg.Wait()
+
+	if d.PageCount() != 7 {
+		t.Errorf("unexpected page count %v", d.PageCount())
+	}
+}
+
+func TestEstimatedSizeUnknown(t *testing.T) {
+	d := NewNotebook("New", "")
+	size, err := d.EstimatedSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != UnknownSize {
+		t.Errorf("expected unknown size for new document, got %d", size)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	d := NewNotebook("My Document", "")
+	d.CreatePage()
+
+	first, err := d.ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := d.ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != again {
+		t.Errorf("hash not stable: %q != %q", first, again)
+	}
+
+	// the version is not part of the hash
+	d.Meta.(*docMeta).version++
+	again, _ = d.ContentHash()
+	if first != again {
+		t.Errorf("hash changed with version")
+	}
+
+	drawing, err := d.Drawing(d.Pages()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	drawing.Layers[0].Strokes = append(drawing.Layers[0].Strokes, lines.Stroke{
+		BrushType:  lines.Ballpoint,
+		BrushColor: lines.Black,
+		BrushSize:  lines.Medium,
+		Dots:       []lines.Dot{{X: 10, Y: 20}},
+	})
+	changed, err := d.ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == first {
+		t.Errorf("hash did not change after adding a stroke")
+	}
+}
+
+func TestDocumentJSON(t *testing.T) {
+	d := NewNotebook("Round Trip", "parent-id")
+	d.SetPinned(true)
+	d.SetLastOpenedPage(1)
+	pageID := d.Pages()[0]
+	d.CreatePage()
+	drawing, err := d.Drawing(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drawing.Layers[0].Strokes = append(drawing.Layers[0].Strokes, lines.Stroke{
+		BrushType:  lines.FinelinerV5,
+		BrushColor: lines.Black,
+		BrushSize:  lines.Medium,
+		Dots:       []lines.Dot{{X: 10, Y: 20, Width: 2, Pressure: 1}},
+	})
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r Document
+	err = json.Unmarshal(data, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.ID() != d.ID() || r.Name() != d.Name() || r.Parent() != d.Parent() {
+		t.Errorf("metadata not restored: %q %q %q", r.ID(), r.Name(), r.Parent())
+	}
+	if !r.Pinned() || r.LastOpenedPage() != 1 {
+		t.Errorf("pinned or last opened page not restored")
+	}
+	if r.PageCount() != 2 || r.Pages()[0] != pageID {
+		t.Errorf("unexpected pages %v", r.Pages())
+	}
+	hash, err := d.ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredHash, err := r.ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != restoredHash {
+		t.Errorf("content changed in round trip")
+	}
+
+	// invalid documents are rejected
+	var x map[string]interface{}
+	json.Unmarshal(data, &x)
+	delete(x, "drawings")
+	data, _ = json.Marshal(x)
+	err = json.Unmarshal(data, &r)
+	if err == nil {
+		t.Errorf("expected validation error for notebook without drawings")
+	}
+}
+
+func TestDocumentJSONPdf(t *testing.T) {
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.AddPage()
+	var buf bytes.Buffer
+	err := pdf.Output(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewPdf("Attachment", "", func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Document
+	err = json.Unmarshal(data, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := r.AttachmentReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	attachment, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(attachment, buf.Bytes()) {
+		t.Errorf("attachment not restored")
+	}
+}
+
+func TestValidateAttachment(t *testing.T) {
+	d := newDocument("Attachment", "", Pdf, nil)
+	if d.validateAttachment() == nil {
+		t.Errorf("expected error for new document without attachment")
+	}
+
+	d.repo = &testRepo{}
+	if err := d.validateAttachment(); err != nil {
+		t.Errorf("unexpected error for document from a repository: %v", err)
+	}
+}
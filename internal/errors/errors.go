@@ -2,7 +2,10 @@ package errors
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
 // Wrap wraps an error by prepending additional text.
@@ -30,9 +33,16 @@ func asNotFound(e error) error {
 }
 
 // IsNotFound checks if the given error is a "not found" error.
+// This includes an HTTPError with status "404 - Not Found".
 func IsNotFound(err error) bool {
-	_, ok := err.(notFound)
-	return ok
+	switch e := err.(type) {
+	case notFound:
+		return true
+	case HTTPError:
+		return e.StatusCode == http.StatusNotFound
+	default:
+		return false
+	}
 }
 
 type validationError struct {
@@ -48,14 +58,57 @@ func NewValidationError(msg string, v ...interface{}) error {
 	return validationError{fmt.Sprintf(msg, v...)}
 }
 
+// VersionConflictError is returned when an item is updated based on
+// a version that is different from the stored version.
+type VersionConflictError struct {
+	// Local is the version the update was based on.
+	Local uint
+	// Remote is the version found in the repository.
+	Remote uint
+}
+
+func (v VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict: local version %d, stored version %d", v.Local, v.Remote)
+}
+
+// IsVersionConflict checks if the given error is a VersionConflictError.
+func IsVersionConflict(err error) bool {
+	_, ok := err.(VersionConflictError)
+	return ok
+}
+
 // ExpectOK checks if the given http response has status "200 - OK"
 // and returns an error with the given message if not.
 func ExpectOK(res *http.Response, msg string) error {
 	return ExpectStatus(res, http.StatusOK, msg)
 }
 
+// maxErrorBody is the maximum number of bytes that is read from the
+// response body for an HTTPError.
+const maxErrorBody = 4096
+
+// HTTPError is returned when an http response has an unexpected status.
+type HTTPError struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+	// Body holds the response body, e.g. an error message from the server.
+	// Long responses are truncated.
+	Body    string
+	message string
+}
+
+func (h HTTPError) Error() string {
+	if h.Body == "" {
+		return h.message
+	}
+	return fmt.Sprintf("%v: %v", h.message, h.Body)
+}
+
 // ExpectStatus checks if the given http response has the expected status
 // and returns an error with the given message if not.
+//
+// The returned error is an HTTPError which includes the response body.
+// The body is consumed in this case.
 func ExpectStatus(res *http.Response, expected int, msg string) error {
 	code := res.StatusCode
 	if code == expected {
@@ -66,12 +119,17 @@ func ExpectStatus(res *http.Response, expected int, msg string) error {
 		msg = msg + ": "
 	}
 
-	// specific types for selected error codes
-	switch code {
-	case http.StatusNotFound:
-		return NewNotFound("%vgot HTTP status %v", msg, code)
+	var body string
+	if res.Body != nil {
+		data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+		if err == nil {
+			body = strings.TrimSpace(string(data))
+		}
 	}
 
-	// unspecified errors
-	return fmt.Errorf("%vgot HTTP status code %v", msg, code)
+	return HTTPError{
+		StatusCode: code,
+		Body:       body,
+		message:    fmt.Sprintf("%vgot HTTP status code %v", msg, code),
+	}
 }
@@ -2,6 +2,9 @@ package errors
 
 import (
 	e "errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -18,3 +21,52 @@ func TestIsNotFound(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestIsVersionConflict(t *testing.T) {
+	if IsVersionConflict(e.New("some error")) {
+		t.Errorf("generic error wrongly recognized as version conflict")
+	}
+	if !IsVersionConflict(VersionConflictError{Local: 1, Remote: 2}) {
+		t.Errorf("version conflict not recognized")
+	}
+}
+
+func TestExpectStatus(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}
+	if ExpectOK(res, "request failed") != nil {
+		t.Errorf("unexpected error for status OK")
+	}
+
+	res = &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Body:       ioutil.NopCloser(strings.NewReader("invalid parameter\n")),
+	}
+	err := ExpectOK(res, "request failed")
+	h, ok := err.(HTTPError)
+	if !ok {
+		t.Fatalf("expected HTTPError, got %T", err)
+	}
+	if h.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status code %v", h.StatusCode)
+	}
+	if h.Body != "invalid parameter" {
+		t.Errorf("unexpected body %q", h.Body)
+	}
+	if !strings.Contains(err.Error(), "invalid parameter") {
+		t.Errorf("body missing from error message %q", err.Error())
+	}
+	if IsNotFound(err) {
+		t.Errorf("status 400 wrongly recognized as not found")
+	}
+
+	res = &http.Response{
+		StatusCode: http.StatusNotFound,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}
+	if !IsNotFound(ExpectOK(res, "")) {
+		t.Errorf("status 404 not recognized as not found")
+	}
+}
@@ -0,0 +1,51 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/akeil/rmtool/internal/logging"
+)
+
+// lockPollInterval is the time between attempts to acquire a lock.
+const lockPollInterval = 50 * time.Millisecond
+
+// Lock is an advisory lock based on a lock file.
+//
+// The lock only works between processes which use the same lock file;
+// other programs writing to the same directory are not affected.
+type Lock struct {
+	path string
+}
+
+// AcquireLock creates the lock file at the given path.
+//
+// If the lock file exists, this waits until it is removed or the timeout
+// has expired. An error is returned if the lock cannot be acquired in time.
+func AcquireLock(path string, timeout time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			// The PID helps to identify the owner of a stale lock.
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			logging.Debug("Acquired lock %q", path)
+			return &Lock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("could not acquire lock %q within %v (remove the file if it is stale)", path, timeout)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Release removes the lock file.
+func (l *Lock) Release() error {
+	logging.Debug("Release lock %q", l.path)
+	return os.Remove(l.path)
+}
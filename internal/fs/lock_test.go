@@ -0,0 +1,40 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmtool-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "test.lock")
+
+	l, err := AcquireLock(p, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = AcquireLock(p, 100*time.Millisecond)
+	if err == nil {
+		t.Errorf("acquired lock that is already held")
+	}
+
+	err = l.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = AcquireLock(p, 100*time.Millisecond)
+	if err != nil {
+		t.Errorf("could not acquire released lock: %v", err)
+	} else {
+		l.Release()
+	}
+}
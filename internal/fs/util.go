@@ -1,8 +1,11 @@
 package fs
 
 import (
+	"bufio"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/akeil/rmtool/internal/logging"
 )
@@ -42,3 +45,90 @@ func Move(src, dst string) error {
 
 	return err
 }
+
+// SameContent tells if the files a and b have identical content.
+//
+// Returns false without an error if one of the files does not exist.
+func SameContent(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	ra := bufio.NewReader(fa)
+	rb := bufio.NewReader(fb)
+	for {
+		ba, errA := ra.ReadByte()
+		bb, errB := rb.ReadByte()
+		if errA == io.EOF && errB == io.EOF {
+			return true, nil
+		} else if errA != nil {
+			return false, errA
+		} else if errB != nil {
+			return false, errB
+		}
+		if ba != bb {
+			return false, nil
+		}
+	}
+}
+
+// WriteFile writes a file at path with the content from the given write
+// function.
+//
+// The content is written to a temporary file in the same directory,
+// which is renamed to path only if write succeeds. On error, the temporary
+// file is removed and an existing file at path is left as it was.
+func WriteFile(path string, write func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	err = write(f)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// TempFile creates files which are readable for the owner only
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		ignoredErr := os.Remove(tmp)
+		if ignoredErr != nil && !os.IsNotExist(ignoredErr) {
+			logging.Error("Failed to remove temp file %v", tmp)
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSameContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmtool-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		err := ioutil.WriteFile(p, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	a := write("a", "some content")
+	b := write("b", "some content")
+	c := write("c", "some CONTENT")
+	d := write("d", "other")
+	missing := filepath.Join(dir, "missing")
+
+	cases := []struct {
+		a, b string
+		same bool
+	}{
+		{a, b, true},
+		{a, c, false},
+		{a, d, false},
+		{a, missing, false},
+		{missing, a, false},
+	}
+
+	for _, x := range cases {
+		same, err := SameContent(x.a, x.b)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if same != x.same {
+			t.Errorf("SameContent(%q, %q) = %v, want %v", x.a, x.b, same, x.same)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmtool-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "out.pdf")
+	err = WriteFile(p, func(w io.Writer) error {
+		_, err := w.Write([]byte("complete"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed write leaves the existing file alone
+	err = WriteFile(p, func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return fmt.Errorf("write failed")
+	})
+	if err == nil {
+		t.Errorf("expected error from write")
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "complete" {
+		t.Errorf("unexpected content %q", string(data))
+	}
+
+	// no file is created if the first write fails
+	missing := filepath.Join(dir, "missing.pdf")
+	WriteFile(missing, func(w io.Writer) error {
+		return fmt.Errorf("write failed")
+	})
+	_, err = os.Stat(missing)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no file after failed write, got %v", err)
+	}
+
+	// no temp files are left behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("unexpected number of files %d", len(files))
+	}
+}
@@ -8,10 +8,13 @@ import (
 	"golang.org/x/image/draw"
 )
 
-// Resize creates a copy of the given image, scaled to the given rectangle.
+// Resize creates a copy of the given image, scaled so that its height
+// matches the given (stroke) width. The aspect ratio is preserved.
 func Resize(i image.Image, width float64) image.Image {
-	scaledSize := int(math.Round(width))
-	size := image.Rect(0, 0, scaledSize, scaledSize)
+	b := i.Bounds()
+	scaledH := int(math.Round(width))
+	scaledW := int(math.Round(width * float64(b.Dx()) / float64(b.Dy())))
+	size := image.Rect(0, 0, scaledW, scaledH)
 
 	dst := image.NewRGBA(size)
 	// nearst neighbour preserves pixel-struture of masks (i.e. for Pencil)
@@ -102,10 +105,56 @@ func Rotate(angle float64, i image.Image) image.Image {
 func ToGray(i image.Image) image.Image {
 	b := i.Bounds()
 	g := image.NewGray(b)
-	for x := 0; x < b.Max.X; x++ {
-		for y := 0; y < b.Max.Y; y++ {
-			g.Set(x, y, i.At(x, y))
+	for x := b.Min.X; x < b.Max.X; x++ {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			g.SetGray(x, y, color.GrayModel.Convert(i.At(x, y)).(color.Gray))
 		}
 	}
 	return g
 }
+
+// Desaturate creates a grayscale version of the given image.
+// Unlike ToGray, this preserves the alpha channel.
+func Desaturate(i image.Image) image.Image {
+	b := i.Bounds()
+	dst := image.NewNRGBA(b)
+	for x := b.Min.X; x < b.Max.X; x++ {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			c := color.NRGBAModel.Convert(i.At(x, y)).(color.NRGBA)
+			g := luminance(c)
+			dst.SetNRGBA(x, y, color.NRGBA{g, g, g, c.A})
+		}
+	}
+	return dst
+}
+
+// ToMono creates a black and white version of the given image.
+//
+// Pixels with a luminance below the threshold become black, all others white.
+// Pixels which are more than half transparent become fully transparent.
+func ToMono(i image.Image, threshold uint8) image.Image {
+	b := i.Bounds()
+	p := color.Palette{color.Transparent, color.Black, color.White}
+	dst := image.NewPaletted(b, p)
+	for x := b.Min.X; x < b.Max.X; x++ {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			c := color.NRGBAModel.Convert(i.At(x, y)).(color.NRGBA)
+			var idx uint8
+			if c.A < 128 {
+				idx = 0
+			} else if luminance(c) < threshold {
+				idx = 1
+			} else {
+				idx = 2
+			}
+			dst.SetColorIndex(x, y, idx)
+		}
+	}
+	return dst
+}
+
+// luminance calculates the gray value for the given color, ignoring alpha.
+func luminance(c color.NRGBA) uint8 {
+	opaque := color.RGBA{c.R, c.G, c.B, 255}
+	return color.GrayModel.Convert(opaque).(color.Gray).Y
+}
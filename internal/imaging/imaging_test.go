@@ -0,0 +1,72 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestToMono(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{200, 200, 200, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{20, 20, 20, 255})
+	src.SetNRGBA(2, 0, color.NRGBA{20, 20, 20, 0})
+
+	mono := ToMono(src, 128).(*image.Paletted)
+	expected := []uint8{2, 1, 0}
+	for x, want := range expected {
+		got := mono.ColorIndexAt(x, 0)
+		if got != want {
+			t.Errorf("pixel %d: got index %d, want %d", x, got, want)
+		}
+	}
+}
+
+func TestDesaturate(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 100})
+
+	c := Desaturate(src).At(0, 0).(color.NRGBA)
+	if c.R != c.G || c.G != c.B {
+		t.Errorf("expected gray, got %v", c)
+	}
+	if c.A != 100 {
+		t.Errorf("expected alpha to be preserved, got %d", c.A)
+	}
+}
+
+func TestToGraySubImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			src.Set(x, y, color.Black)
+		}
+	}
+	src.Set(3, 3, color.White)
+
+	sub := src.SubImage(image.Rect(2, 2, 4, 4))
+	gray := ToGray(sub)
+
+	if gray.Bounds() != sub.Bounds() {
+		t.Errorf("unexpected bounds %v, want %v", gray.Bounds(), sub.Bounds())
+	}
+	if c := gray.At(2, 2).(color.Gray); c.Y != 0 {
+		t.Errorf("expected black at (2, 2), got %v", c)
+	}
+	if c := gray.At(3, 3).(color.Gray); c.Y != 255 {
+		t.Errorf("expected white at (3, 3), got %v", c)
+	}
+}
+
+func TestResize(t *testing.T) {
+	square := Resize(image.NewRGBA(image.Rect(0, 0, 16, 16)), 4)
+	if square.Bounds() != image.Rect(0, 0, 4, 4) {
+		t.Errorf("unexpected bounds %v", square.Bounds())
+	}
+
+	// the aspect ratio is preserved
+	wide := Resize(image.NewRGBA(image.Rect(0, 0, 32, 8)), 4)
+	if wide.Bounds() != image.Rect(0, 0, 16, 4) {
+		t.Errorf("unexpected bounds %v", wide.Bounds())
+	}
+}
@@ -0,0 +1,25 @@
+// Package pdfinfo reads information about the pages of a PDF file.
+package pdfinfo
+
+import (
+	"io"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// PageDims reads the media box dimensions for each page of a PDF file.
+// The number of pages is the length of the returned slice.
+func PageDims(rs io.ReadSeeker) ([]pdfcpu.Dim, error) {
+	ctx, err := pdfcpu.Read(rs, pdfcpu.NewDefaultConfiguration())
+	if err != nil {
+		return nil, err
+	}
+
+	// This *must* be called before accessing page count
+	err = ctx.EnsurePageCount()
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.PageDims()
+}
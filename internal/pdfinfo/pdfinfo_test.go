@@ -0,0 +1,40 @@
+package pdfinfo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+func TestPageDims(t *testing.T) {
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.AddPage()
+	pdf.AddPageFormat("L", gofpdf.SizeType{Wd: 595.28, Ht: 841.89})
+	var buf bytes.Buffer
+	err := pdf.Output(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dims, err := PageDims(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dims) != 2 {
+		t.Fatalf("got %d pages, want 2", len(dims))
+	}
+	if dims[0].Width > dims[0].Height {
+		t.Errorf("page 1 should be portrait, got %v", dims[0])
+	}
+	if dims[1].Width < dims[1].Height {
+		t.Errorf("page 2 should be landscape, got %v", dims[1])
+	}
+}
+
+func TestPageDimsInvalid(t *testing.T) {
+	_, err := PageDims(bytes.NewReader([]byte("not a pdf")))
+	if err == nil {
+		t.Error("expected error for invalid PDF")
+	}
+}
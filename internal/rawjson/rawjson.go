@@ -0,0 +1,114 @@
+// Package rawjson helps to preserve unknown fields when a JSON object
+// is decoded into a struct and encoded again.
+package rawjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Unknown returns the fields from the JSON object in data that do not map
+// to a field of the struct v.
+//
+// Like encoding/json, field names are matched case-insensitively.
+// Returns nil if there are no unknown fields.
+func Unknown(data []byte, v interface{}) (map[string]json.RawMessage, error) {
+	var all map[string]json.RawMessage
+	err := json.Unmarshal(data, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	known := fieldNames(reflect.TypeOf(v))
+
+	var extra map[string]json.RawMessage
+	for k, raw := range all {
+		if known[strings.ToLower(k)] {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]json.RawMessage)
+		}
+		extra[k] = raw
+	}
+
+	return extra, nil
+}
+
+// Marshal encodes the struct v to a JSON object and adds the given extra
+// fields. Extra fields are appended after the regular fields, sorted by name.
+func Marshal(v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(extra) == 0 {
+		return data, nil
+	}
+
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// data is a JSON object, strip the closing brace and append our fields.
+	buf := bytes.NewBuffer(bytes.TrimSuffix(bytes.TrimSpace(data), []byte("}")))
+	empty := buf.Len() == 1 // only "{"
+	for _, k := range keys {
+		if !empty {
+			buf.WriteString(",")
+		}
+		empty = false
+
+		name, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteString(":")
+		buf.Write(extra[k])
+	}
+	buf.WriteString("}")
+
+	return buf.Bytes(), nil
+}
+
+// fieldNames returns the (lowercase) JSON names for the fields of the given
+// struct type.
+func fieldNames(t reflect.Type) map[string]bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	names := make(map[string]bool)
+	if t.Kind() != reflect.Struct {
+		return names
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+
+		name := f.Name
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if tag != "" {
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+		}
+		names[strings.ToLower(name)] = true
+	}
+
+	return names
+}
@@ -0,0 +1,64 @@
+package rawjson
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type sample struct {
+	Name    string `json:"name"`
+	Count   int
+	Ignored string `json:"-"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte(`{"name":"foo","count":3,"unknown":{"a":1},"Ignored":"x"}`)
+
+	var s sample
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extra, err := Unknown(data, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extra) != 2 {
+		t.Errorf("unexpected number of unknown fields: %v", len(extra))
+	}
+	if string(extra["unknown"]) != `{"a":1}` {
+		t.Errorf("unexpected value for unknown field: %s", extra["unknown"])
+	}
+
+	out, err := Marshal(s, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(out, &m)
+	if err != nil {
+		t.Fatalf("invalid JSON %s: %v", out, err)
+	}
+	if string(m["unknown"]) != `{"a":1}` {
+		t.Errorf("unknown field lost: %s", out)
+	}
+	if string(m["name"]) != `"foo"` {
+		t.Errorf("known field lost: %s", out)
+	}
+	if len(m) != 4 {
+		t.Errorf("unexpected number of fields: %s", out)
+	}
+}
+
+func TestMarshalEmpty(t *testing.T) {
+	extra := map[string]json.RawMessage{"a": json.RawMessage(`1`)}
+	out, err := Marshal(struct{}{}, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"a":1}` {
+		t.Errorf("unexpected result %s", out)
+	}
+}
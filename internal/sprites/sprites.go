@@ -0,0 +1,169 @@
+// Package sprites creates the spritesheet with brush textures
+// that is used for rendering.
+package sprites
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/akeil/rmtool/internal/logging"
+)
+
+// Sprite is the position and size of a single brush image
+// within the spritesheet.
+type Sprite struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// FromRect creates a Sprite from a rectangle given as [x0, y0, x1, y1].
+func FromRect(r []int) (Sprite, error) {
+	if len(r) != 4 {
+		return Sprite{}, fmt.Errorf("invalid sprite rectangle %v", r)
+	}
+	return Sprite{X: r[0], Y: r[1], Width: r[2] - r[0], Height: r[3] - r[1]}, nil
+}
+
+// Rect is the rectangle for this sprite within the spritesheet.
+func (s Sprite) Rect() image.Rectangle {
+	return image.Rect(s.X, s.Y, s.X+s.Width, s.Y+s.Height)
+}
+
+// UnmarshalJSON reads a sprite entry from the index.
+//
+// Entries are objects with position, width and height. For older
+// index files, an array [x0, y0, x1, y1] is accepted as well.
+func (s *Sprite) UnmarshalJSON(data []byte) error {
+	var r []int
+	if json.Unmarshal(data, &r) == nil {
+		sp, err := FromRect(r)
+		if err != nil {
+			return err
+		}
+		*s = sp
+		return nil
+	}
+
+	// avoid recursion into UnmarshalJSON
+	type entry Sprite
+	var e entry
+	err := json.Unmarshal(data, &e)
+	if err != nil {
+		return err
+	}
+	*s = Sprite(e)
+	return nil
+}
+
+// Make combines the brush images (PNG) from srcDir into a single spritesheet.
+//
+// It writes the spritesheet to dstBase + ".png" and an index with the
+// position and size of each brush to dstBase + ".json".
+// Brush images can have different sizes and need not be square.
+func Make(srcDir, dstBase string) error {
+	dstPath := dstBase + ".png"
+	indexPath := dstBase + ".json"
+
+	files, err := ioutil.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+
+	// read all brushes first, the grid cells must fit the largest one
+	names := make([]string, len(files))
+	images := make([]image.Image, len(files))
+	cellW, cellH := 0, 0
+	for i, f := range files {
+		path := filepath.Join(srcDir, f.Name())
+		img, err := readPNG(path)
+		if err != nil {
+			return err
+		}
+
+		r := img.Bounds()
+		if r.Empty() {
+			return fmt.Errorf("empty brush image %q", path)
+		}
+		if r.Dx() > cellW {
+			cellW = r.Dx()
+		}
+		if r.Dy() > cellH {
+			cellH = r.Dy()
+		}
+
+		names[i] = strings.TrimSuffix(f.Name(), ".png")
+		images[i] = img
+	}
+
+	size := 1
+	for {
+		capacity := size * size
+		if capacity >= len(files) {
+			break
+		}
+		size++
+	}
+	logging.Info("Spritesheet will have size %v for %v sprites", size, len(files))
+
+	sheet := image.NewRGBA(image.Rect(0, 0, size*cellW, size*cellH))
+	lookup := make(map[string]Sprite)
+	for i, img := range images {
+		r := img.Bounds()
+		xOffset := i % size
+		yOffset := int(math.Floor(float64(i / size)))
+		s := Sprite{
+			X:      xOffset * cellW,
+			Y:      yOffset * cellH,
+			Width:  r.Dx(),
+			Height: r.Dy(),
+		}
+
+		draw.Draw(sheet, s.Rect(), img, r.Min, draw.Src)
+
+		lookup[names[i]] = s
+
+		logging.Info("%v => %v,%v (%vx%v)", names[i], s.X, s.Y, s.Width, s.Height)
+	}
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	err = png.Encode(dst, sheet)
+	if err != nil {
+		return err
+	}
+
+	index, err := os.Create(indexPath)
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+	err = json.NewEncoder(index).Encode(lookup)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return png.Decode(f)
+}
@@ -0,0 +1,109 @@
+package sprites
+
+import (
+	"encoding/json"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeBrush(t *testing.T, dir, name string, w, h int) {
+	f, err := os.Create(filepath.Join(dir, name+".png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = png.Encode(f, image.NewAlpha(image.Rect(0, 0, w, h)))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMake(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "rm-brushes-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcDir)
+	dstDir, err := ioutil.TempDir("", "rm-sprites-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dstDir)
+
+	writeBrush(t, srcDir, "ballpoint", 16, 16)
+	writeBrush(t, srcDir, "calligraphy", 24, 8)
+	writeBrush(t, srcDir, "pencil", 16, 16)
+
+	dstBase := filepath.Join(dstDir, "sprites")
+	err = Make(srcDir, dstBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(dstBase + ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cfg, err := png.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// three sprites fit into a 2x2 sheet, cells fit the largest sprite
+	if cfg.Width != 2*24 || cfg.Height != 2*16 {
+		t.Errorf("unexpected spritesheet size %vx%v", cfg.Width, cfg.Height)
+	}
+
+	data, err := ioutil.ReadFile(dstBase + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index map[string]Sprite
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 3 {
+		t.Errorf("unexpected number of index entries %d", len(index))
+	}
+	expected := map[string]Sprite{
+		"ballpoint":   {X: 0, Y: 0, Width: 16, Height: 16},
+		"calligraphy": {X: 24, Y: 0, Width: 24, Height: 8},
+		"pencil":      {X: 0, Y: 16, Width: 16, Height: 16},
+	}
+	for name, want := range expected {
+		if index[name] != want {
+			t.Errorf("unexpected sprite for %v: %+v, want %+v", name, index[name], want)
+		}
+	}
+}
+
+func TestUnmarshalSprite(t *testing.T) {
+	var index map[string]Sprite
+	data := `{"legacy": [16, 0, 32, 16], "sized": {"x": 32, "y": 0, "width": 24, "height": 8}}`
+	err := json.Unmarshal([]byte(data), &index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := Sprite{X: 16, Y: 0, Width: 16, Height: 16}
+	if index["legacy"] != legacy {
+		t.Errorf("unexpected legacy sprite %+v", index["legacy"])
+	}
+	if index["legacy"].Rect() != image.Rect(16, 0, 32, 16) {
+		t.Errorf("unexpected rectangle %v", index["legacy"].Rect())
+	}
+	sized := Sprite{X: 32, Y: 0, Width: 24, Height: 8}
+	if index["sized"] != sized {
+		t.Errorf("unexpected sprite %+v", index["sized"])
+	}
+
+	err = json.Unmarshal([]byte(`{"invalid": [1, 2, 3]}`), &index)
+	if err == nil {
+		t.Errorf("expected error for invalid entry")
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 	"strings"
 
 	"github.com/akeil/rmtool/internal/errors"
+	"github.com/akeil/rmtool/internal/rawjson"
 )
 
 // TrashFolder is the ID whoch is used for the reMArkable trash folder.
@@ -62,6 +64,28 @@ const blankTemplate = "Blank"
 const maxLayers = 5
 const defaultCoverPage = -1
 
+// Bounds for the TextScale used for EPUB documents.
+const (
+	minTextScale = 0.5
+	maxTextScale = 3.0
+)
+
+// Bounds for the page margins.
+const (
+	minMargins = 0
+	maxMargins = 400
+)
+
+// knownFonts is the list of fonts available for EPUB documents on the tablet.
+var knownFonts = []string{
+	"EB Garamond",
+	"Maison Neue",
+	"Noto Mono",
+	"Noto Sans",
+	"Noto Sans UI",
+	"Noto Serif",
+}
+
 // Content holds the data from the remarkable `.content` file.
 // It describes the content for a notebook, specifically the sequence of pages.
 // Collections have an empty content object.
@@ -94,6 +118,14 @@ type Content struct {
 	// TextScale for EPUB, default is 1.0,
 	TextScale float32   `json:"textScale"`
 	Transform Transform `json:"transform"`
+
+	// Labels are arbitrary key/value pairs which can be used by tools.
+	// They are not used by the tablet.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// extra holds fields from the JSON document that are not mapped to
+	// this struct, so that they are preserved when the document is written.
+	extra map[string]json.RawMessage
 }
 
 func NewContent(f FileType) *Content {
@@ -115,6 +147,43 @@ func NewContent(f FileType) *Content {
 	}
 }
 
+// UnmarshalJSON reads the content from JSON
+// and remembers any unknown fields.
+func (c *Content) UnmarshalJSON(b []byte) error {
+	// use a type without the custom unmarshaler to avoid recursion
+	type content Content
+	var x content
+	err := json.Unmarshal(b, &x)
+	if err != nil {
+		return err
+	}
+
+	extra, err := rawjson.Unknown(b, x)
+	if err != nil {
+		return err
+	}
+
+	*c = Content(x)
+	c.extra = extra
+	return nil
+}
+
+// MarshalJSON writes the content to JSON, including any unknown fields
+// that were present when the content was read.
+func (c Content) MarshalJSON() ([]byte, error) {
+	type content Content
+	return rawjson.Marshal(content(c), c.extra)
+}
+
+func validateOrientation(o Orientation) error {
+	switch o {
+	case Portrait, Landscape:
+		return nil
+	default:
+		return errors.NewValidationError("invalid orientation %v", o)
+	}
+}
+
 func (c *Content) Validate() error {
 	switch c.FileType {
 	case Notebook, Pdf, Epub:
@@ -123,10 +192,9 @@ func (c *Content) Validate() error {
 		return errors.NewValidationError("invalid file type %v", c.FileType)
 	}
 
-	switch c.Orientation {
-	case Portrait, Landscape: // ok
-	default:
-		return errors.NewValidationError("invalid orientation %v", c.Orientation)
+	err := validateOrientation(c.Orientation)
+	if err != nil {
+		return err
 	}
 
 	if c.PageCount != len(c.Pages) {
@@ -141,10 +209,26 @@ func (c *Content) Validate() error {
 		}
 	}
 
-	// TODO validate font names
-	// TODO validate LineHeight
-	// TODO validate Margins
-	// TODO: validate TextScale
+	// Empty font name means "default font"
+	if c.FontName != "" && !isKnownFont(c.FontName) {
+		return errors.NewValidationError("unknown font %q", c.FontName)
+	}
+
+	switch c.LineHeight {
+	case LineHeightDefault, LineHeightSmall, LineHeightMedium, LineHeightLarge:
+		// ok
+	default:
+		return errors.NewValidationError("invalid line height %v", c.LineHeight)
+	}
+
+	if c.Margins < minMargins || c.Margins > maxMargins {
+		return errors.NewValidationError("margins %v are not within %v..%v", c.Margins, minMargins, maxMargins)
+	}
+
+	if c.TextScale < minTextScale || c.TextScale > maxTextScale {
+		return errors.NewValidationError("text scale %v is not within %v..%v", c.TextScale, minTextScale, maxTextScale)
+	}
+
 	switch c.TextAlignment {
 	case AlignLeft, AlignJustify:
 		// ok
@@ -155,20 +239,36 @@ func (c *Content) Validate() error {
 	return nil
 }
 
+func isKnownFont(name string) bool {
+	for _, f := range knownFonts {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Transform is a 3x3 matrix that maps drawing coordinates
+// to page coordinates, with the same layout as a Qt QTransform:
+// M11, M12, M21, M22 are the linear part, M31, M32 the translation
+// and M13, M23, M33 the projection.
+//
+// The tablet usually writes the identity transform with integer values.
+// Fields are floats so that scaled or translated transforms can be read;
+// whole numbers are still written without a decimal point.
 type Transform struct {
-	// TODO: these might also be floats
-	// never seen anything other than identity transform with values set to 1 or 0
-	M11 int `json:"m11"`
-	M12 int `json:"m12"`
-	M13 int `json:"m13"`
-	M21 int `json:"m21"`
-	M22 int `json:"m22"`
-	M23 int `json:"m23"`
-	M31 int `json:"m31"`
-	M32 int `json:"m32"`
-	M33 int `json:"m33"`
+	M11 float64 `json:"m11"`
+	M12 float64 `json:"m12"`
+	M13 float64 `json:"m13"`
+	M21 float64 `json:"m21"`
+	M22 float64 `json:"m22"`
+	M23 float64 `json:"m23"`
+	M31 float64 `json:"m31"`
+	M32 float64 `json:"m32"`
+	M33 float64 `json:"m33"`
 }
 
+// NewTransform creates the identity transform.
 func NewTransform() Transform {
 	return Transform{
 		M11: 1,
@@ -177,6 +277,37 @@ func NewTransform() Transform {
 	}
 }
 
+// IsIdentity tells if this transform leaves coordinates unchanged.
+// The zero value (no transform given) is treated as identity, too.
+func (t Transform) IsIdentity() bool {
+	return t == NewTransform() || t == Transform{}
+}
+
+// Map applies the transform to the given point.
+func (t Transform) Map(x, y float64) (float64, float64) {
+	if t.IsIdentity() {
+		return x, y
+	}
+
+	mx := t.M11*x + t.M21*y + t.M31
+	my := t.M12*x + t.M22*y + t.M32
+	w := t.M13*x + t.M23*y + t.M33
+	if w != 0 && w != 1 {
+		mx /= w
+		my /= w
+	}
+	return mx, my
+}
+
+// Scale is the factor by which the transform scales lengths,
+// e.g. stroke widths. For non-uniform scaling, this is the average.
+func (t Transform) Scale() float64 {
+	if t.IsIdentity() {
+		return 1
+	}
+	return math.Sqrt(math.Abs(t.M11*t.M22 - t.M12*t.M21))
+}
+
 // PageMetadata holds the layer information for a single page.
 type PageMetadata struct {
 	// Layers is the list of layers for a page.
@@ -241,14 +372,9 @@ func (n *NotebookType) UnmarshalJSON(b []byte) error {
 }
 
 func (n NotebookType) MarshalJSON() ([]byte, error) {
-	var s string
-	switch n {
-	case DocumentType:
-		s = "DocumentType"
-	case CollectionType:
-		s = "CollectionType"
-	default:
-		return nil, fmt.Errorf("invalid notebook type %v", n)
+	s := n.String()
+	if s == "UNKNOWN" {
+		return nil, fmt.Errorf("invalid notebook type %d", n)
 	}
 
 	buf := bytes.NewBufferString(`"`)
@@ -258,6 +384,17 @@ func (n NotebookType) MarshalJSON() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+func (n NotebookType) String() string {
+	switch n {
+	case DocumentType:
+		return "DocumentType"
+	case CollectionType:
+		return "CollectionType"
+	default:
+		return "UNKNOWN"
+	}
+}
+
 func (o *Orientation) UnmarshalJSON(b []byte) error {
 	var s string
 	err := json.Unmarshal(b, &s)
@@ -353,6 +490,9 @@ func (f FileType) String() string {
 	}
 }
 
+// Ext is the file extension for attachments of this type,
+// including the leading dot. Notebooks have no attachment
+// and return an empty string.
 func (f FileType) Ext() string {
 	switch f {
 	case Epub:
@@ -409,6 +549,83 @@ func (t TextAlign) String() string {
 	}
 }
 
+// Pagedata describes the background template for a single page,
+// as found in one line of the .pagedata file.
+//
+// A line typically looks like "P Lines medium", where the first field
+// is the orientation ("P" or "LS"), followed by the template name and size.
+// Some templates, e.g. "Blank", have no orientation prefix.
+type Pagedata struct {
+	// Orientation is the page layout, only valid if HasOrientation is set.
+	Orientation Orientation
+	// HasOrientation tells if the line starts with an orientation prefix.
+	HasOrientation bool
+	// Template is the name of the template, e.g. "Lines".
+	Template string
+	// Size is the size variant of the template, e.g. "medium".
+	Size string
+	// Text holds any additional fields, separated by spaces.
+	Text string
+}
+
+// ParsePagedataLine parses a single line from a .pagedata file.
+//
+// Fields after orientation, template and size are preserved in Text.
+// Returns an error if the line is empty.
+func ParsePagedataLine(s string) (Pagedata, error) {
+	var pd Pagedata
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return pd, errors.NewValidationError("empty pagedata line")
+	}
+
+	switch fields[0] {
+	case "P":
+		pd.Orientation = Portrait
+		pd.HasOrientation = true
+		fields = fields[1:]
+	case "LS":
+		pd.Orientation = Landscape
+		pd.HasOrientation = true
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 {
+		return pd, errors.NewValidationError("missing template name in pagedata line %q", s)
+	}
+	pd.Template = fields[0]
+	if len(fields) > 1 {
+		pd.Size = fields[1]
+	}
+	if len(fields) > 2 {
+		pd.Text = strings.Join(fields[2:], " ")
+	}
+
+	return pd, nil
+}
+
+// String formats the Pagedata as a line for the .pagedata file.
+func (p Pagedata) String() string {
+	fields := make([]string, 0, 4)
+	if p.HasOrientation {
+		if p.Orientation == Landscape {
+			fields = append(fields, "LS")
+		} else {
+			fields = append(fields, "P")
+		}
+	}
+	for _, f := range []string{p.Template, p.Size, p.Text} {
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// ReadPagedata reads the template names for all pages from a .pagedata file.
+//
+// Each non-empty line is checked with ParsePagedataLine,
+// but returned verbatim.
 func ReadPagedata(r io.Reader) ([]string, error) {
 	pd := make([]string, 0)
 	s := bufio.NewScanner(r)
@@ -416,6 +633,12 @@ func ReadPagedata(r io.Reader) ([]string, error) {
 	for s.Scan() {
 		text := s.Text()
 		text = strings.TrimSpace(text)
+		if text != "" {
+			_, err := ParsePagedataLine(text)
+			if err != nil {
+				return nil, err
+			}
+		}
 		pd = append(pd, text)
 	}
 
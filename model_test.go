@@ -79,6 +79,93 @@ func TestValidateContent(t *testing.T) {
 	}
 	c.TextAlignment = AlignJustify
 
+	c.FontName = "Comic Sans"
+	if c.Validate() == nil {
+		t.Errorf("Unknown font name not detected")
+	}
+	c.FontName = "Noto Sans"
+
+	c.LineHeight = LineHeight(120)
+	if c.Validate() == nil {
+		t.Errorf("Invalid line height not detected")
+	}
+	c.LineHeight = LineHeightMedium
+
+	c.Margins = -1
+	if c.Validate() == nil {
+		t.Errorf("Negative margins not detected")
+	}
+	c.Margins = 500
+	if c.Validate() == nil {
+		t.Errorf("Excessive margins not detected")
+	}
+	c.Margins = 180
+
+	c.TextScale = 0
+	if c.Validate() == nil {
+		t.Errorf("Zero text scale not detected")
+	}
+	c.TextScale = -1.0
+	if c.Validate() == nil {
+		t.Errorf("Negative text scale not detected")
+	}
+	c.TextScale = 10.0
+	if c.Validate() == nil {
+		t.Errorf("Excessive text scale not detected")
+	}
+	c.TextScale = 1.2
+
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("Valid content not accepted: %v", err)
+	}
+}
+
+func TestContentUnknownFields(t *testing.T) {
+	c := NewContent(Notebook)
+	c.Labels = map[string]string{"project": "X"}
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// add an unknown field
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m["someNewField"] = json.RawMessage(`{"x":42}`)
+	data, err = json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read and write again
+	var x Content
+	err = json.Unmarshal(data, &x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Labels["project"] != "X" {
+		t.Errorf("label lost in serialization")
+	}
+	data, err = json.Marshal(&x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m = make(map[string]json.RawMessage)
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m["someNewField"]) != `{"x":42}` {
+		t.Errorf("unknown field was not preserved: %s", data)
+	}
+	if string(m["fileType"]) != `"notebook"` {
+		t.Errorf("known field was not preserved: %s", data)
+	}
 }
 
 func TestReadPageMetadata(t *testing.T) {
@@ -120,3 +207,158 @@ func TestReadPagedata(t *testing.T) {
 		t.Errorf("unexpected template: %q", pd[1])
 	}
 }
+
+func TestReadPagedataExtraFields(t *testing.T) {
+	lines := []string{
+		"Blank",
+		"P Lines medium",
+		"LS Dots top",
+		"P Grid margin med",
+		"P Lines medium 1 extra",
+		"LS Grid large tag:foo tag:bar",
+	}
+	r := strings.NewReader(strings.Join(lines, "\n") + "\n")
+
+	pd, err := ReadPagedata(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pd) != len(lines) {
+		t.Fatalf("unexpected number of pagedata entries: %d", len(pd))
+	}
+	for i, expected := range lines {
+		if pd[i] != expected {
+			t.Errorf("unexpected pagedata entry %d: %q, want %q", i, pd[i], expected)
+		}
+	}
+}
+
+func TestParsePagedataLine(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected Pagedata
+	}{
+		{"Blank", Pagedata{Template: "Blank"}},
+		{"P Lines medium", Pagedata{Orientation: Portrait, HasOrientation: true, Template: "Lines", Size: "medium"}},
+		{"LS Dots top", Pagedata{Orientation: Landscape, HasOrientation: true, Template: "Dots", Size: "top"}},
+		{"P Checklist", Pagedata{Orientation: Portrait, HasOrientation: true, Template: "Checklist"}},
+		{"LS Grid margin  med", Pagedata{Orientation: Landscape, HasOrientation: true, Template: "Grid", Size: "margin", Text: "med"}},
+	}
+
+	for _, c := range cases {
+		pd, err := ParsePagedataLine(c.line)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", c.line, err)
+			continue
+		}
+		if pd != c.expected {
+			t.Errorf("unexpected result for %q: %+v", c.line, pd)
+		}
+		if pd.String() != strings.Join(strings.Fields(c.line), " ") {
+			t.Errorf("unexpected string for %q: %q", c.line, pd.String())
+		}
+	}
+
+	for _, invalid := range []string{"", "   ", "P", "LS"} {
+		_, err := ParsePagedataLine(invalid)
+		if err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestNotebookTypeString(t *testing.T) {
+	for _, nt := range []NotebookType{DocumentType, CollectionType} {
+		data, err := json.Marshal(nt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != `"`+nt.String()+`"` {
+			t.Errorf("JSON %s does not match String() %q", data, nt.String())
+		}
+
+		var x NotebookType
+		err = json.Unmarshal(data, &x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x != nt {
+			t.Errorf("round trip failed: %v != %v", x, nt)
+		}
+	}
+
+	if NotebookType(42).String() != "UNKNOWN" {
+		t.Errorf("unexpected string for invalid type %q", NotebookType(42).String())
+	}
+	_, err := json.Marshal(NotebookType(42))
+	if err == nil {
+		t.Errorf("expected error for invalid notebook type")
+	}
+}
+
+func TestFileTypeString(t *testing.T) {
+	for _, ft := range []FileType{Notebook, Epub, Pdf} {
+		data, err := json.Marshal(ft)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var x FileType
+		err = json.Unmarshal(data, &x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x != ft {
+			t.Errorf("round trip failed: %v != %v", x, ft)
+		}
+	}
+}
+
+func TestFileTypeExt(t *testing.T) {
+	cases := map[FileType]string{
+		Notebook:    "",
+		Epub:        ".epub",
+		Pdf:         ".pdf",
+		FileType(9): "",
+	}
+	for ft, expected := range cases {
+		if ft.Ext() != expected {
+			t.Errorf("unexpected extension %q for %v, want %q", ft.Ext(), ft, expected)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	var id Transform
+	err := json.Unmarshal([]byte(`{"m11":1,"m12":0,"m13":0,"m21":0,"m22":1,"m23":0,"m31":0,"m32":0,"m33":1}`), &id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.IsIdentity() || !(Transform{}).IsIdentity() {
+		t.Errorf("expected identity transform")
+	}
+	data, err := json.Marshal(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"m11":1,`) {
+		t.Errorf("expected integer values for identity transform, got %s", data)
+	}
+
+	var tr Transform
+	err = json.Unmarshal([]byte(`{"m11":0.5,"m12":0,"m13":0,"m21":0,"m22":0.5,"m23":0,"m31":10,"m32":20.5,"m33":1}`), &tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.IsIdentity() {
+		t.Errorf("unexpected identity transform")
+	}
+	x, y := tr.Map(100, 200)
+	if x != 60 || y != 120.5 {
+		t.Errorf("unexpected result %v, %v", x, y)
+	}
+	if tr.Scale() != 0.5 {
+		t.Errorf("unexpected scale %v", tr.Scale())
+	}
+}
@@ -25,6 +25,9 @@ const (
 	NotificationsDiscoveryURL = "https://service-manager-production-dot-remarkable-production.appspot.com/service/json/1/notifications?environment=production&group=auth0%7C5a68dc51cb30df3877a1d7c4&apiVer=1"
 )
 
+// defaultUserAgent is sent with requests unless WithUserAgent is used.
+const defaultUserAgent = "rmtools"
+
 // API endpoints
 const (
 	// auth
@@ -49,8 +52,41 @@ type Client struct {
 	userToken          string
 	tokenExpires       time.Time
 	client             *http.Client
+	userAgent          string
+	headers            http.Header
+}
+
+// A ClientOption configures optional settings when creating a Client.
+type ClientOption func(c *Client)
+
+// WithUserAgent sets the User-Agent header that is sent with all requests.
+func WithUserAgent(ua string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// WithHTTPClient sets the http.Client that is used for all requests,
+// e.g. to configure timeouts, a proxy or a custom Transport.
+func WithHTTPClient(hc *http.Client) ClientOption {
+	return func(c *Client) {
+		c.client = hc
+	}
+}
+
+// WithHeader adds a header that is sent with all requests.
+// It can be used multiple times, also with the same key.
+func WithHeader(key, value string) ClientOption {
+	return func(c *Client) {
+		c.headers.Add(key, value)
+	}
 }
 
+// HTTPError is returned by the Client if the service responds with an
+// unexpected status. The Body holds the response from the server,
+// which usually contains an error message.
+type HTTPError = errors.HTTPError
+
 // NewClient sets up an API client with the given base URLs.
 //
 // The *three* URLs are the base URLs for the various services.
@@ -60,21 +96,50 @@ type Client struct {
 // been completed and a token can be loaded from storage.
 // If set to the empty string, Register can be used to obtain a token.
 //
+// Additional settings can be passed as ClientOptions.
+//
 // Refer to DefaultClient for a more simple constructor.
-func NewClient(discoveryStorage, discoverNotif, authBase, deviceToken string) *Client {
-	return &Client{
+func NewClient(discoveryStorage, discoverNotif, authBase, deviceToken string, opts ...ClientOption) *Client {
+	c := &Client{
 		discoverStorageURL: discoveryStorage,
 		discoverNotifURL:   discoverNotif,
 		authBase:           authBase,
 		deviceToken:        deviceToken,
 		client:             &http.Client{},
+		userAgent:          defaultUserAgent,
+		headers:            http.Header{},
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 // DefaultClient sets up an API client with default URLs.
 // See NewClient for details.
-func DefaultClient(deviceToken string) *Client {
-	return NewClient(StorageDiscoveryURL, NotificationsDiscoveryURL, AuthURL, deviceToken)
+func DefaultClient(deviceToken string, opts ...ClientOption) *Client {
+	return NewClient(StorageDiscoveryURL, NotificationsDiscoveryURL, AuthURL, deviceToken, opts...)
+}
+
+// header creates the headers that are common to all requests,
+// i.e. the User-Agent and any extra headers.
+func (c *Client) header() http.Header {
+	h := http.Header{}
+	for k, v := range c.headers {
+		h[k] = append([]string(nil), v...)
+	}
+	if c.userAgent != "" {
+		h.Set("User-Agent", c.userAgent)
+	}
+	return h
+}
+
+// do adds the common headers to the given request and sends it.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	for k, v := range c.header() {
+		req.Header[k] = v
+	}
+	return c.client.Do(req)
 }
 
 // NewNotifications sets up a client for the notifications service.
@@ -98,7 +163,9 @@ func (c *Client) NewNotifications() (*Notifications, error) {
 		}
 	}
 
-	return newNotifications(url, c.userToken), nil
+	n := newNotifications(url, c.userToken)
+	n.header = c.header()
+	return n, nil
 }
 
 // Storage --------------------------------------------------------------------
@@ -112,6 +179,10 @@ func (c *Client) List() ([]Item, error) {
 // Fetch retrieves a single item from the service
 // and writes the item's blob data to the given writer.
 //
+// Interrupted downloads are retried and resumed if the server supports
+// Range requests. Otherwise they start over, which works only if the writer
+// is an io.Seeker (e.g. a file).
+//
 // The caller is responsible for closing the writer.
 func (c *Client) Fetch(id string, w io.Writer) (Item, error) {
 	item, err := c.fetchItem(id)
@@ -184,32 +255,130 @@ func (c *Client) fetchItem(id string) (Item, error) {
 	return item, nil
 }
 
+// BlobSize returns the size of the zipped content for the given item,
+// without downloading it.
+//
+// Returns rmtool.UnknownSize if the server does not report the size.
+func (c *Client) BlobSize(id string) (int64, error) {
+	item, err := c.fetchItem(id)
+	if err != nil {
+		return rmtool.UnknownSize, err
+	}
+
+	if item.Type == rmtool.CollectionType {
+		return rmtool.UnknownSize, fmt.Errorf("can only fetch document type items")
+	}
+
+	req, err := http.NewRequest("HEAD", item.BlobURLGet, nil)
+	if err != nil {
+		return rmtool.UnknownSize, err
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return rmtool.UnknownSize, err
+	}
+	defer res.Body.Close()
+
+	err = errors.ExpectOK(res, "blob request failed")
+	if err != nil {
+		return rmtool.UnknownSize, err
+	}
+
+	// ContentLength is -1 if unknown, same as UnknownSize
+	return res.ContentLength, nil
+}
+
+// maxFetchAttempts is the number of attempts to download a blob.
+const maxFetchAttempts = 3
+
+// fetchRetryDelay is the base delay between download attempts,
+// it grows with each attempt.
+var fetchRetryDelay = 500 * time.Millisecond
+
 // FetchBlob downloads the zipped content from the BlobURL
 // and writes it to the given writer.
+//
+// If the download fails because of a network error or a server error,
+// it is retried. A retry resumes the download with a Range request.
+// If the server does not support Range requests, the download starts over,
+// which requires that the writer is an io.Seeker. Writers that also have
+// a Truncate method (like *os.File) are truncated in this case.
 func (c *Client) fetchBlob(url string, w io.Writer) error {
 	// fetches the "Blob" from a blob URL
 	// this is a Zip archive with the same files that are present on the tablets file system.
+	var offset int64
+	var err error
+	for attempt := 1; attempt <= maxFetchAttempts; attempt++ {
+		if attempt > 1 {
+			logging.Warning("Download failed after %d bytes (attempt %d of %d): %v", offset, attempt-1, maxFetchAttempts, err)
+			time.Sleep(time.Duration(attempt-1) * fetchRetryDelay)
+		}
+
+		var retry bool
+		offset, retry, err = c.fetchFrom(url, w, offset)
+		if err == nil || !retry {
+			return err
+		}
+	}
+
+	return err
+}
+
+// fetchFrom downloads a blob, starting at the given offset,
+// and writes it to w.
+//
+// Returns the new offset and tells if the download can be retried.
+func (c *Client) fetchFrom(url string, w io.Writer, offset int64) (int64, bool, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return err
+		return offset, false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
 
-	res, err := c.client.Do(req)
+	res, err := c.do(req)
 	if err != nil {
-		return err
+		return offset, true, err
 	}
+	defer res.Body.Close()
 
-	err = errors.ExpectOK(res, "blob request failed")
-	if err != nil {
-		return err
+	switch {
+	case offset > 0 && res.StatusCode == http.StatusPartialContent:
+		logging.Debug("Resume download at %d bytes", offset)
+	case res.StatusCode == http.StatusOK:
+		if offset > 0 {
+			logging.Debug("Range request not supported, restart download")
+			err = rewind(w)
+			if err != nil {
+				return offset, false, err
+			}
+			offset = 0
+		}
+	default:
+		err = errors.ExpectOK(res, "blob request failed")
+		return offset, res.StatusCode >= http.StatusInternalServerError, err
 	}
 
-	defer res.Body.Close()
-	_, err = io.Copy(w, res.Body)
+	n, err := io.Copy(w, res.Body)
+	return offset + n, err != nil, err
+}
+
+// rewind moves a writer back to the start so that a download can start over.
+func rewind(w io.Writer) error {
+	s, ok := w.(io.Seeker)
+	if !ok {
+		return fmt.Errorf("cannot restart download, writer does not support seek")
+	}
+	_, err := s.Seek(0, io.SeekStart)
 	if err != nil {
 		return err
 	}
 
+	t, ok := w.(interface{ Truncate(size int64) error })
+	if ok {
+		return t.Truncate(0)
+	}
 	return nil
 }
 
@@ -250,7 +419,10 @@ func (c *Client) Delete(id string) error {
 	wrap := make([]uploadItem, 1)
 	wrap[0] = item.toUpload()
 	result := make([]Item, 0)
-	c.storageRequest("PUT", epDelete, wrap, result)
+	err = c.storageRequest("PUT", epDelete, wrap, &result)
+	if err != nil {
+		return err
+	}
 
 	if len(result) != 1 {
 		return fmt.Errorf("got unexpected number of items (%v)", len(result))
@@ -280,6 +452,13 @@ func (c *Client) Move(id, parentID string) error {
 		return err
 	}
 
+	if item.Type == rmtool.CollectionType {
+		err = c.checkNotDescendant(id, parentID)
+		if err != nil {
+			return err
+		}
+	}
+
 	item.Parent = parentID
 	return c.update(item)
 }
@@ -319,11 +498,23 @@ func (c *Client) Rename(id, name string) error {
 // Upload adds a document to the given parent folder.
 // The parentID can be empty (root folder) or refer to another folder.
 func (c *Client) Upload(name, id, parentID string, src io.Reader) error {
+	meta := Item{
+		ID:          id,
+		Type:        rmtool.DocumentType,
+		Parent:      parentID,
+		VisibleName: name,
+	}
+	return c.upload(meta, src)
+}
+
+// upload adds a document with the metadata from the given item.
+func (c *Client) upload(meta Item, src io.Reader) error {
+	id := meta.ID
 	if id == "" {
 		return fmt.Errorf("id must not be empty")
 	}
 	// We need to check the parent folder, server will not check
-	err := c.checkParent(parentID)
+	err := c.checkParent(meta.Parent)
 	if err != nil {
 		return err
 	}
@@ -362,13 +553,7 @@ func (c *Client) Upload(name, id, parentID string, src io.Reader) error {
 	}
 
 	// Set the metadata for the new item
-	meta := Item{
-		ID:          u.ID,
-		Version:     0, // update() will increment te version; we need version 1, not 2
-		Type:        rmtool.DocumentType,
-		Parent:      parentID,
-		VisibleName: name,
-	}
+	meta.Version = 0 // update() will increment te version; we need version 1, not 2
 	return c.update(meta)
 }
 
@@ -391,6 +576,25 @@ func (c *Client) checkParent(parentID string) error {
 	return nil
 }
 
+// checkNotDescendant returns an error if the parent is the item with the
+// given id or one of its descendants.
+func (c *Client) checkNotDescendant(id, parentID string) error {
+	items, err := c.List()
+	if err != nil {
+		return err
+	}
+
+	metas := make([]rmtool.Meta, len(items))
+	for i := range items {
+		metas[i] = metaWrapper{i: &items[i]}
+	}
+	if rmtool.IsDescendant(metas, parentID, id) {
+		return fmt.Errorf("cannot move %q into itself or one of its subfolders", id)
+	}
+
+	return nil
+}
+
 // checkEmpty is used for a collection type to determine whether it has any
 // content. Returns an error if the collection is non-empty
 func (c *Client) checkEmpty(id string) error {
@@ -419,10 +623,11 @@ func (c *Client) putBlob(url string, src io.Reader) error {
 	}
 
 	logging.Debug("Upload blob...")
-	res, err := c.client.Do(req)
+	res, err := c.do(req)
 	if err != nil {
 		return fmt.Errorf("blob upload failed with %v", err)
 	}
+	defer res.Body.Close()
 
 	return errors.ExpectOK(res, "blob upload failed")
 }
@@ -486,7 +691,7 @@ func (c *Client) storageRequest(method, endpoint string, payload, dst interface{
 		}
 	}
 
-	res, err := c.client.Do(req)
+	res, err := c.do(req)
 	if err != nil {
 		return fmt.Errorf("upload request failed: %v", err)
 	}
@@ -501,6 +706,8 @@ func (c *Client) storageRequest(method, endpoint string, payload, dst interface{
 	logging.Debug("API request %v %v returned status %v\n", req.Method, req.URL, res.StatusCode)
 	logging.Debug("Response body: %v", string(resData))
 
+	// ExpectOK reads the body to include it in the error
+	res.Body = ioutil.NopCloser(bytes.NewBuffer(resData))
 	err = errors.ExpectOK(res, "storage request failed")
 	if err != nil {
 		return err
@@ -573,7 +780,7 @@ func (c *Client) refreshToken() error {
 		c.tokenExpires = t
 		logging.Debug("Token will expire at %v\n", t)
 	} else {
-		logging.Debug("Error parsing expiration time from JWT: %v\n", parseErr)
+		logging.Info("Error parsing expiration time from JWT: %v\n", parseErr)
 		// we still consider the token as "valid" and carry on
 	}
 
@@ -581,6 +788,17 @@ func (c *Client) refreshToken() error {
 	return nil
 }
 
+// TokenExpiry returns the time when the current user token expires.
+//
+// The second return value is false if there is no user token
+// or if the expiration time could not be determined from the token.
+func (c *Client) TokenExpiry() (time.Time, bool) {
+	if c.userToken == "" || c.tokenExpires.IsZero() {
+		return time.Time{}, false
+	}
+	return c.tokenExpires, true
+}
+
 func (c *Client) requestToken(endpoint, token string, payload interface{}) (string, error) {
 	logging.Debug("Request new token from %q\n", endpoint)
 
@@ -589,7 +807,7 @@ func (c *Client) requestToken(endpoint, token string, payload interface{}) (stri
 		return "", err
 	}
 
-	res, err := c.client.Do(req)
+	res, err := c.do(req)
 	if err != nil {
 		return "", err
 	}
@@ -597,13 +815,7 @@ func (c *Client) requestToken(endpoint, token string, payload interface{}) (stri
 
 	err = errors.ExpectOK(res, "token request failed")
 	if err != nil {
-		var msg string
-		d, xerr := ioutil.ReadAll(res.Body)
-		if xerr == nil {
-			msg = string(d)
-			msg = strings.TrimSpace(msg)
-		}
-		return "", errors.Wrap(err, msg)
+		return "", err
 	}
 
 	// The token is returned as a plain string
@@ -636,16 +848,17 @@ func (c *Client) discoverHost(url string) (string, error) {
 		return "", err
 	}
 
-	res, err := c.client.Do(req)
+	res, err := c.do(req)
 	if err != nil {
 		return "", err
 	}
 
+	defer res.Body.Close()
+
 	err = errors.ExpectOK(res, "service discovery failed")
 	if err != nil {
 		return "", err
 	}
-	defer res.Body.Close()
 
 	dis := &discovery{}
 	dec := json.NewDecoder(res.Body)
@@ -696,7 +909,6 @@ func newRequest(method, base, endpoint, token string, payload interface{}) (*htt
 	}
 	// Not sure if this is necessary, won't hurt either
 	req.Header.Set("Accept", "application/json")
-	req.Header.Set("User-Agent", "rmtools")
 
 	return req, nil
 }
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("down for maintenance"))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, srv.URL, srv.URL, "")
+
+	check := func(op string, err error) {
+		h, ok := err.(HTTPError)
+		if !ok {
+			t.Errorf("%v: expected HTTPError, got %T (%v)", op, err, err)
+			return
+		}
+		if h.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%v: unexpected status %v", op, h.StatusCode)
+		}
+		if h.Body != "down for maintenance" {
+			t.Errorf("%v: unexpected body %q", op, h.Body)
+		}
+	}
+
+	_, err := c.discoverHost(srv.URL)
+	check("discover", err)
+
+	var buf bytes.Buffer
+	check("fetch", c.fetchBlob(srv.URL, &buf))
+	check("put", c.putBlob(srv.URL, &buf))
+
+	c.deviceToken = "device-token"
+	check("token", c.refreshToken())
+
+	c.storageBase = srv.URL
+	c.userToken = "user-token"
+	check("storage", c.storageRequest("GET", epList, nil, nil))
+}
+
+func TestClientHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Write([]byte(`{"Status": "OK", "Host": "localhost"}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, srv.URL, srv.URL, "")
+	_, err := c.discoverHost(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("User-Agent") != "rmtools" {
+		t.Errorf("unexpected default User-Agent %q", got.Get("User-Agent"))
+	}
+
+	c = NewClient(srv.URL, srv.URL, srv.URL, "",
+		WithUserAgent("my-tool/1.0"),
+		WithHeader("X-Debug", "1"))
+	_, err = c.discoverHost(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("User-Agent") != "my-tool/1.0" {
+		t.Errorf("unexpected User-Agent %q", got.Get("User-Agent"))
+	}
+	if got.Get("X-Debug") != "1" {
+		t.Errorf("extra header not sent")
+	}
+}
+
+func TestFetchResume(t *testing.T) {
+	delay := fetchRetryDelay
+	defer func() { fetchRetryDelay = delay }()
+	fetchRetryDelay = time.Millisecond
+	content := []byte(strings.Repeat("0123456789", 100))
+
+	newServer := func(supportRange bool) (*httptest.Server, *int) {
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			rng := r.Header.Get("Range")
+			if supportRange && rng != "" {
+				var start int
+				fmt.Sscanf(rng, "bytes=%d-", &start)
+				w.Header().Set("Content-Length", strconv.Itoa(len(content)-start))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content[start:])
+				return
+			}
+
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			if calls == 1 {
+				// drop the connection halfway
+				w.Write(content[:len(content)/2])
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+			w.Write(content)
+		}))
+		return srv, &calls
+	}
+
+	// resume with Range request
+	srv, calls := newServer(true)
+	defer srv.Close()
+	c := NewClient(srv.URL, srv.URL, srv.URL, "")
+	var buf bytes.Buffer
+	err := c.fetchBlob(srv.URL, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("resumed download has unexpected content (%d bytes)", buf.Len())
+	}
+	if *calls != 2 {
+		t.Errorf("unexpected number of requests %v", *calls)
+	}
+
+	// no Range support, start over with a file
+	srv2, _ := newServer(false)
+	defer srv2.Close()
+	f, err := ioutil.TempFile("", "rmtool-fetch-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	err = c.fetchBlob(srv2.URL, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("restarted download has unexpected content (%d bytes)", len(data))
+	}
+
+	// no Range support and a writer that cannot seek
+	srv3, _ := newServer(false)
+	defer srv3.Close()
+	buf.Reset()
+	err = c.fetchBlob(srv3.URL, &buf)
+	if err == nil {
+		t.Errorf("expected error for writer without seek")
+	}
+}
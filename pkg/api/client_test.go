@@ -0,0 +1,264 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/internal/errors"
+)
+
+const (
+	testDocID    = "7e4c5a1e-93b5-4c43-b2a4-4c3c3a0e8d02"
+	testFolderID = "1b2f4f5c-0b0e-4e1d-9e0c-6a6f7f5c1a01"
+	testUploadID = "c0ffee00-1234-4bcd-8ef0-0123456789ab"
+)
+
+// fixtureTransport is an http.RoundTripper that answers requests with
+// recorded responses from the testdata directory.
+//
+// Routes are given as "METHOD /path?query"; requests without a route
+// get a "404 - Not Found" response.
+type fixtureTransport struct {
+	routes   map[string]string
+	mx       sync.Mutex
+	requests []string
+}
+
+func (f *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route := req.Method + " " + req.URL.RequestURI()
+	f.mx.Lock()
+	f.requests = append(f.requests, route)
+	f.mx.Unlock()
+
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Request:    req,
+	}
+
+	name, ok := f.routes[route]
+	if !ok {
+		res.StatusCode = http.StatusNotFound
+		res.Body = ioutil.NopCloser(strings.NewReader("no fixture for " + route))
+		return res, nil
+	}
+	if name == "" {
+		res.Body = ioutil.NopCloser(strings.NewReader(""))
+		return res, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(data))
+	res.ContentLength = int64(len(data))
+	return res, nil
+}
+
+func (f *fixtureTransport) called(route string) bool {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	for _, r := range f.requests {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+func fixtureClient(routes map[string]string) (*Client, *fixtureTransport) {
+	ft := &fixtureTransport{routes: map[string]string{
+		"GET /discovery":    "discovery.json",
+		"POST " + epRefresh: "token.txt",
+	}}
+	for k, v := range routes {
+		ft.routes[k] = v
+	}
+	hc := &http.Client{Transport: ft}
+	c := NewClient("https://discovery.example.com/discovery", "", "https://auth.example.com", "device-token", WithHTTPClient(hc))
+	return c, ft
+}
+
+func TestClientList(t *testing.T) {
+	c, ft := fixtureClient(map[string]string{
+		"GET " + epList: "list.json",
+	})
+
+	items, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("unexpected number of items %v", len(items))
+	}
+	doc := items[1]
+	if doc.ID != testDocID || doc.VisibleName != "Meeting Notes" || doc.Type != rmtool.DocumentType {
+		t.Errorf("unexpected item %v", doc)
+	}
+	if !doc.Bookmarked || doc.Version != 12 || doc.CurrentPage != 4 {
+		t.Errorf("unexpected item details %v", doc)
+	}
+
+	if c.storageBase != "https://storage.example.com" {
+		t.Errorf("unexpected storage base %q", c.storageBase)
+	}
+	if c.userToken != "user-token" {
+		t.Errorf("unexpected user token %q", c.userToken)
+	}
+	if !ft.called("POST " + epRefresh) {
+		t.Errorf("token was not requested")
+	}
+}
+
+func TestClientUpload(t *testing.T) {
+	c, ft := fixtureClient(map[string]string{
+		"PUT " + epUpload:   "upload_request.json",
+		"PUT /put/c0ffee00": "",
+		"PUT " + epUpdate:   "update_status.json",
+	})
+
+	err := c.Upload("New Document", testUploadID, "", strings.NewReader("zip data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range []string{"PUT " + epUpload, "PUT /put/c0ffee00", "PUT " + epUpdate} {
+		if !ft.called(route) {
+			t.Errorf("expected request %q", route)
+		}
+	}
+
+	c, ft = fixtureClient(map[string]string{
+		"PUT " + epUpload: "upload_request_failed.json",
+	})
+	err = c.Upload("New Document", testUploadID, "", strings.NewReader("zip data"))
+	if err == nil || err.Error() != "Item already exists" {
+		t.Errorf("expected error from response, got %v", err)
+	}
+	if ft.called("PUT /put/c0ffee00") {
+		t.Errorf("blob uploaded after failed upload request")
+	}
+}
+
+func TestClientDelete(t *testing.T) {
+	fetch := "GET " + epList + "?doc=" + testDocID + "&withBlob=true"
+	c, _ := fixtureClient(map[string]string{
+		fetch:             "item.json",
+		"PUT " + epDelete: "delete.json",
+	})
+	err := c.Delete(testDocID)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Success: false with HTTP status OK
+	c, _ = fixtureClient(map[string]string{
+		fetch:             "item.json",
+		"PUT " + epDelete: "delete_failed.json",
+	})
+	err = c.Delete(testDocID)
+	if err == nil || err.Error() != "Version on server is newer" {
+		t.Errorf("expected error from response, got %v", err)
+	}
+
+	// item does not exist, the server responds with 404
+	c, ft := fixtureClient(map[string]string{})
+	err = c.Delete(testDocID)
+	if !errors.IsNotFound(err) {
+		t.Errorf("expected not found error for unknown item, got %v", err)
+	}
+	if ft.called("PUT " + epDelete) {
+		t.Errorf("delete request sent for unknown item")
+	}
+
+	// item does not exist, the server responds with an empty list
+	c, ft = fixtureClient(map[string]string{
+		fetch: "empty_list.json",
+	})
+	err = c.Delete(testDocID)
+	if !errors.IsNotFound(err) {
+		t.Errorf("expected not found error for unknown item, got %v", err)
+	}
+	if ft.called("PUT " + epDelete) {
+		t.Errorf("delete request sent for unknown item")
+	}
+}
+
+func TestClientMove(t *testing.T) {
+	fetchDoc := "GET " + epList + "?doc=" + testDocID + "&withBlob=true"
+	fetchFolder := "GET " + epList + "?doc=" + testFolderID + "&withBlob=true"
+	c, _ := fixtureClient(map[string]string{
+		"GET " + epList: "list.json",
+		fetchDoc:        "item.json",
+		fetchFolder:     "folder.json",
+	})
+
+	// already in that folder
+	err := c.Move(testDocID, testFolderID)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = c.Move(testFolderID, testDocID)
+	if err == nil {
+		t.Errorf("expected error for move into a document")
+	}
+
+	err = c.Move(testFolderID, testFolderID)
+	if err == nil {
+		t.Errorf("expected error for move into itself")
+	}
+}
+
+func TestClientBlobSize(t *testing.T) {
+	fetch := "GET " + epList + "?doc=" + testDocID + "&withBlob=true"
+	c, _ := fixtureClient(map[string]string{
+		fetch:                "item.json",
+		"HEAD /get/7e4c5a1e": "list.json",
+	})
+
+	size, err := c.BlobSize(testDocID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join("testdata", "list.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != info.Size() {
+		t.Errorf("unexpected size %d, want %d", size, info.Size())
+	}
+
+	// blob does not exist
+	c, _ = fixtureClient(map[string]string{
+		fetch: "item.json",
+	})
+	size, err = c.BlobSize(testDocID)
+	if err == nil {
+		t.Errorf("expected error for missing blob")
+	}
+	if size != rmtool.UnknownSize {
+		t.Errorf("expected unknown size, got %d", size)
+	}
+}
+
+func TestClientTokenFailure(t *testing.T) {
+	c, _ := fixtureClient(nil)
+	delete(c.client.Transport.(*fixtureTransport).routes, "POST "+epRefresh)
+
+	_, err := c.List()
+	if err == nil {
+		t.Fatal("expected error for failed token request")
+	}
+	h, ok := err.(HTTPError)
+	if !ok || h.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -32,6 +32,43 @@ type Message struct {
 	Bookmarked  bool
 	Version     int
 	VisibleName string
+	// Attributes holds the raw attributes from the notification,
+	// including those that are not mapped to a field of the Message.
+	Attributes map[string]string
+}
+
+// Field identifies an attribute of an item which can change.
+type Field string
+
+const (
+	FieldName   Field = "name"
+	FieldParent Field = "parent"
+	FieldPinned Field = "pinned"
+)
+
+// Changes compares the item state from this message with the given previous
+// state of the same item and returns the fields which differ.
+//
+// The notification service reports updates to existing items as DocAdded,
+// so a sync client should keep the last known state of each item and use
+// this method to find out what has changed.
+func (m Message) Changes(prev rmtool.Meta) []Field {
+	changes := make([]Field, 0)
+	if prev == nil {
+		return changes
+	}
+
+	if m.VisibleName != prev.Name() {
+		changes = append(changes, FieldName)
+	}
+	if m.Parent != prev.Parent() {
+		changes = append(changes, FieldParent)
+	}
+	if m.Bookmarked != prev.Pinned() {
+		changes = append(changes, FieldPinned)
+	}
+
+	return changes
 }
 
 // msgWrapper used to unmarshal a notification mapper from JSON.
@@ -54,13 +91,47 @@ func (w msgWrapper) toMessage() Message {
 		Type:        w.Msg.Attr.Type,
 		Version:     int(w.Msg.Attr.Version),
 		VisibleName: w.Msg.Attr.VisibleName,
+		Attributes:  w.Msg.Raw,
 	}
 }
 
 type msg struct {
-	Attr        msgAttr  `json:"attributes"`
-	ID          string   `json:"messageId"`
-	PublishTime DateTime `json:"publishTime"`
+	Attr        msgAttr           `json:"attributes"`
+	ID          string            `json:"messageId"`
+	PublishTime DateTime          `json:"publishTime"`
+	Raw         map[string]string `json:"-"`
+}
+
+// UnmarshalJSON decodes a message and additionally captures the raw
+// attributes as strings.
+func (m *msg) UnmarshalJSON(data []byte) error {
+	type alias msg
+	var a alias
+	err := json.Unmarshal(data, &a)
+	if err != nil {
+		return err
+	}
+
+	var raw struct {
+		Attr map[string]json.RawMessage `json:"attributes"`
+	}
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	a.Raw = make(map[string]string)
+	for k, v := range raw.Attr {
+		var s string
+		if json.Unmarshal(v, &s) == nil {
+			a.Raw[k] = s
+		} else {
+			a.Raw[k] = string(v)
+		}
+	}
+
+	*m = msg(a)
+	return nil
 }
 
 type msgAttr struct {
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/akeil/rmtool"
+)
+
+const testNotification = `{
+    "message": {
+        "attributes": {
+            "auth0UserID": "auth0|123",
+            "bookmarked": "true",
+            "event": "DocAdded",
+            "id": "doc-id",
+            "parent": "folder-id",
+            "sourceDeviceDesc": "remarkable",
+            "sourceDeviceID": "device-id",
+            "type": "DocumentType",
+            "version": "7",
+            "vissibleName": "New Name"
+        },
+        "messageId": "msg-id",
+        "publishTime": "2020-12-17T18:34:34.814Z"
+    },
+    "subscription": "sub"
+}`
+
+func TestMessageAttributes(t *testing.T) {
+	var w msgWrapper
+	err := json.Unmarshal([]byte(testNotification), &w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := w.toMessage()
+	if m.ItemID != "doc-id" || m.Version != 7 || !m.Bookmarked {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if m.Attributes["sourceDeviceDesc"] != "remarkable" {
+		t.Errorf("unexpected raw attributes %v", m.Attributes)
+	}
+	if m.Attributes["auth0UserID"] != "auth0|123" {
+		t.Errorf("unmapped attribute not preserved: %v", m.Attributes)
+	}
+}
+
+func TestMessageChanges(t *testing.T) {
+	var w msgWrapper
+	err := json.Unmarshal([]byte(testNotification), &w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := w.toMessage()
+
+	prev := metaWrapper{i: &Item{
+		ID:          "doc-id",
+		VisibleName: "Old Name",
+		Parent:      "folder-id",
+		Bookmarked:  false,
+		Type:        rmtool.DocumentType,
+	}}
+
+	changes := m.Changes(prev)
+	if len(changes) != 2 || changes[0] != FieldName || changes[1] != FieldPinned {
+		t.Errorf("unexpected changes %v", changes)
+	}
+
+	prev.i.VisibleName = "New Name"
+	prev.i.Bookmarked = true
+	if len(m.Changes(prev)) != 0 {
+		t.Errorf("expected no changes, got %v", m.Changes(prev))
+	}
+}
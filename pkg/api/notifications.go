@@ -17,6 +17,22 @@ import (
 // incoming messages.
 type MessageHandler func(Message)
 
+// A MessageFilter decides if a message should be passed to a handler.
+type MessageFilter func(Message) bool
+
+// IsEvent creates a MessageFilter that accepts messages with the given event.
+func IsEvent(e Event) MessageFilter {
+	return func(m Message) bool {
+		return m.Event == e
+	}
+}
+
+// subscription is a handler that receives only messages accepted by a filter.
+type subscription struct {
+	filter  MessageFilter
+	handler MessageHandler
+}
+
 // Notifications is the client for the notification service.
 //
 // It connects to the websocket service, parses messages from JSON
@@ -24,11 +40,14 @@ type MessageHandler func(Message)
 type Notifications struct {
 	url    string
 	token  string
+	header http.Header
 	conn   *websocket.Conn
 	connMx sync.Mutex
 	done   chan struct{}
 	exit   chan struct{}
 	hdl    MessageHandler
+	subs   []subscription
+	onDisc func()
 	hdlMx  sync.Mutex
 }
 
@@ -59,12 +78,19 @@ func (n *Notifications) Connect() error {
 	}
 	n.conn = nil
 
-	logging.Info("Connect to notification service at %q (using token: %v)\n", n.url, n.token != "")
+	logging.Info("Connect to notification service at %q (using token: %v)", n.url, n.token != "")
 
 	h := http.Header{}
+	for k, v := range n.header {
+		h[k] = v
+	}
 	h.Set("Authorization", "Bearer "+n.token)
 	conn, res, err := websocket.DefaultDialer.Dial(n.url, h)
 	if err != nil {
+		// res is nil if the connection could not be established at all
+		if res == nil {
+			return fmt.Errorf("websocket connection failed: %v", err)
+		}
 		return fmt.Errorf("websocket connection failed with status %v, error %v", res.StatusCode, err)
 	}
 
@@ -100,7 +126,20 @@ func (n *Notifications) onDisconnected() {
 	}
 	n.connMx.Unlock()
 
-	// TODO: client code should be able to register a handler for this.
+	n.hdlMx.Lock()
+	f := n.onDisc
+	n.hdlMx.Unlock()
+	if f != nil {
+		go f()
+	}
+}
+
+// OnDisconnect registers a function that is called when the connection to
+// the notification service is closed, either by the server or by Disconnect.
+func (n *Notifications) OnDisconnect(f func()) {
+	n.hdlMx.Lock()
+	n.onDisc = f
+	n.hdlMx.Unlock()
 }
 
 // loop is the "empty" write loop.
@@ -150,10 +189,11 @@ func (n *Notifications) read() {
 func (n *Notifications) handleMessage(data []byte) {
 	n.hdlMx.Lock()
 	handler := n.hdl
+	subs := n.subs
 	n.hdlMx.Unlock()
 
 	// early exit if there is nobody to receive the message
-	if handler == nil {
+	if handler == nil && len(subs) == 0 {
 		return
 	}
 
@@ -164,10 +204,19 @@ func (n *Notifications) handleMessage(data []byte) {
 	if err != nil {
 		logging.Warning("Error decoding notification message: %v", err)
 		logging.Debug(string(data))
+		return
 	}
 
 	// ...and dispatch
-	go handler(w.toMessage())
+	m := w.toMessage()
+	if handler != nil {
+		go handler(m)
+	}
+	for _, sub := range subs {
+		if sub.filter(m) {
+			go sub.handler(m)
+		}
+	}
 }
 
 // OnMessage registers a handler function for received messages.
@@ -181,3 +230,38 @@ func (n *Notifications) OnMessage(f MessageHandler) {
 	n.hdl = f
 	n.hdlMx.Unlock()
 }
+
+// OnMessageFiltered registers a handler function which is called only for
+// messages accepted by the given filter.
+//
+// Unlike OnMessage, multiple filtered handlers can be registered.
+// They are called in addition to the handler set with OnMessage.
+// Use ClearHandlers to remove them.
+func (n *Notifications) OnMessageFiltered(f MessageFilter, h MessageHandler) {
+	n.hdlMx.Lock()
+	defer n.hdlMx.Unlock()
+
+	// copy, handleMessage may still use the old slice
+	subs := make([]subscription, len(n.subs), len(n.subs)+1)
+	copy(subs, n.subs)
+	n.subs = append(subs, subscription{filter: f, handler: h})
+}
+
+// OnDocAdded registers a handler function for DocAdded messages.
+func (n *Notifications) OnDocAdded(h MessageHandler) {
+	n.OnMessageFiltered(IsEvent(DocAdded), h)
+}
+
+// OnDocDeleted registers a handler function for DocDeleted messages.
+func (n *Notifications) OnDocDeleted(h MessageHandler) {
+	n.OnMessageFiltered(IsEvent(DocDeleted), h)
+}
+
+// ClearHandlers removes all registered handlers,
+// including the one set with OnMessage.
+func (n *Notifications) ClearHandlers() {
+	n.hdlMx.Lock()
+	n.hdl = nil
+	n.subs = nil
+	n.hdlMx.Unlock()
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFilteredHandlers(t *testing.T) {
+	n := newNotifications("", "")
+
+	var mx sync.Mutex
+	var wg sync.WaitGroup
+	received := make(map[string]int)
+	record := func(name string) MessageHandler {
+		return func(m Message) {
+			mx.Lock()
+			received[name]++
+			mx.Unlock()
+			wg.Done()
+		}
+	}
+
+	n.OnMessage(record("all"))
+	n.OnDocAdded(record("added"))
+	n.OnDocDeleted(record("deleted"))
+
+	// all + added
+	wg.Add(2)
+	n.handleMessage([]byte(testNotification))
+	// all + deleted
+	wg.Add(2)
+	n.handleMessage([]byte(strings.Replace(testNotification, "DocAdded", "DocDeleted", 1)))
+
+	waitTimeout(t, &wg)
+	if received["all"] != 2 || received["added"] != 1 || received["deleted"] != 1 {
+		t.Errorf("unexpected dispatch: %v", received)
+	}
+
+	// invalid messages are not dispatched
+	n.handleMessage([]byte("invalid"))
+
+	n.ClearHandlers()
+	n.handleMessage([]byte(testNotification))
+	time.Sleep(10 * time.Millisecond)
+	mx.Lock()
+	defer mx.Unlock()
+	if received["all"] != 2 || received["added"] != 1 {
+		t.Errorf("handlers called after ClearHandlers: %v", received)
+	}
+}
+
+func waitTimeout(t *testing.T, wg *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for handlers")
+	}
+}
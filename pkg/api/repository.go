@@ -20,10 +20,18 @@ import (
 	"github.com/akeil/rmtool/internal/logging"
 )
 
+// maxDownloadAttempts is the number of times a blob download is attempted
+// if the downloaded archive is corrupt.
+const maxDownloadAttempts = 3
+
 type repo struct {
 	client  *Client
 	dataDir string
 	mx      sync.RWMutex
+	notif   *Notifications
+	notifMx sync.Mutex
+	zips    map[string]*zip.ReadCloser
+	zipMx   sync.Mutex
 }
 
 // NewRepository creates a Repository with the reMarkable cloud service as
@@ -45,8 +53,8 @@ func (r *repo) List() ([]rmtool.Meta, error) {
 	}
 
 	rv := make([]rmtool.Meta, len(items))
-	for i, item := range items {
-		rv[i] = metaWrapper{i: item, r: r}
+	for i := range items {
+		rv[i] = metaWrapper{i: &items[i], r: r}
 	}
 
 	return rv, nil
@@ -60,39 +68,77 @@ func (r *repo) Update(m rmtool.Meta) error {
 		VisibleName: m.Name(),
 		Bookmarked:  m.Pinned(),
 		Parent:      m.Parent(),
+		CurrentPage: m.LastOpenedPage(),
 	}
 	return r.client.update(item)
 }
 
-func (r *repo) PagePrefix(id string, index int) string {
-	return fmt.Sprintf("%d", index)
+func (r *repo) Delete(m rmtool.Meta) error {
+	return r.client.Delete(m.ID())
 }
 
-func (r *repo) Reader(id string, version uint, path ...string) (io.ReadCloser, error) {
-
-	// Attempt to read from cache, download if not exists or corrupt
-	p := r.cachePath(id, version)
+func (r *repo) CreateFolder(parentID, name string) error {
+	return r.client.CreateFolder(parentID, name)
+}
 
-	r.mx.RLock()
-	defer r.mx.RUnlock()
-	zr, err := zip.OpenReader(p)
+func (r *repo) Move(id, parentID string) error {
+	return r.client.Move(id, parentID)
+}
 
-	// If the file does not exist or is otherwise unusable,
-	// download new and try again.
+func (r *repo) Restore(id, parentID string) error {
+	item, err := r.client.fetchItem(id)
 	if err != nil {
-		// CAREFUL: we need a write lock when we downloading,
-		// so we release our read lock for a moment.
-		//
-		// This relies on the RLock being acquired before
-		// AND relies on the (defer) RUnlock being called before.
-		r.mx.RUnlock()
-		r.downloadToCache(id, version)
-		r.mx.RLock()
+		return err
+	}
 
-		zr, err = zip.OpenReader(p)
+	if item.Parent != rmtool.TrashFolder {
+		return fmt.Errorf("item with id %q is not in trash", id)
+	}
+
+	// Move() checks if the new parent exists
+	return r.client.Move(id, parentID)
+}
+
+func (r *repo) Watch(h rmtool.ChangeHandler) error {
+	r.notifMx.Lock()
+	defer r.notifMx.Unlock()
+
+	if r.notif == nil {
+		n, err := r.client.NewNotifications()
 		if err != nil {
-			return nil, err
+			return err
 		}
+		r.notif = n
+	}
+
+	r.notif.OnMessage(func(msg Message) {
+		h(msg.ItemID, msg.Event == DocDeleted)
+	})
+
+	return r.notif.Connect()
+}
+
+func (r *repo) Unwatch() {
+	r.notifMx.Lock()
+	defer r.notifMx.Unlock()
+
+	if r.notif == nil {
+		return
+	}
+
+	r.notif.OnMessage(nil)
+	r.notif.Disconnect()
+	r.notif = nil
+}
+
+func (r *repo) PagePrefix(id string, index int) string {
+	return fmt.Sprintf("%d", index)
+}
+
+func (r *repo) Reader(id string, version uint, path ...string) (io.ReadCloser, error) {
+	zr, err := r.openZip(id, version)
+	if err != nil {
+		return nil, err
 	}
 
 	// Read the desired entry from the zip file
@@ -108,12 +154,101 @@ func (r *repo) Reader(id string, version uint, path ...string) (io.ReadCloser, e
 		return nil, errors.NewNotFound("no zip entry found with name %q", match)
 	}
 
-	// return a reader for the file entry
-	// closing the reader should close the zip reader
+	// return a reader for the file entry,
+	// the zip reader itself remains open for subsequent calls.
 	return entry.Open()
 }
 
-// TODO implement
+func (r *repo) ListFiles(id string, version uint) ([]string, error) {
+	zr, err := r.openZip(id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(zr.File))
+	for _, zf := range zr.File {
+		// skip directory entries
+		if strings.HasSuffix(zf.Name, "/") {
+			continue
+		}
+		names = append(names, zf.Name)
+	}
+
+	return names, nil
+}
+
+// openZip returns a zip reader for the cached blob of the given item.
+//
+// The blob is downloaded if it is not cached or unusable.
+// Zip readers are opened once per id and version and retained,
+// so that reading multiple entries does not decode the archive every time.
+func (r *repo) openZip(id string, version uint) (*zip.Reader, error) {
+	p := r.cachePath(id, version)
+
+	r.zipMx.Lock()
+	zr := r.zips[p]
+	r.zipMx.Unlock()
+	if zr != nil {
+		return &zr.Reader, nil
+	}
+
+	// Attempt to read from cache, download if not exists or corrupt
+	r.mx.RLock()
+	zr, err := zip.OpenReader(p)
+	r.mx.RUnlock()
+
+	// If the file does not exist or is otherwise unusable,
+	// download new and try again.
+	if err != nil {
+		err = r.downloadToCache(id, version)
+		if err != nil {
+			return nil, err
+		}
+
+		r.mx.RLock()
+		zr, err = zip.OpenReader(p)
+		r.mx.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.zipMx.Lock()
+	defer r.zipMx.Unlock()
+	if r.zips == nil {
+		r.zips = make(map[string]*zip.ReadCloser)
+	}
+
+	// Another goroutine may have opened the same archive in the meantime.
+	if existing := r.zips[p]; existing != nil {
+		zr.Close()
+		return &existing.Reader, nil
+	}
+	r.zips[p] = zr
+
+	return &zr.Reader, nil
+}
+
+// closeZip closes and forgets the retained zip reader for the given path.
+func (r *repo) closeZip(p string) {
+	r.zipMx.Lock()
+	defer r.zipMx.Unlock()
+
+	zr := r.zips[p]
+	if zr == nil {
+		return
+	}
+	delete(r.zips, p)
+	err := zr.Close()
+	if err != nil {
+		logging.Warning("Failed to close zip archive %q: %v", p, err)
+	}
+}
+
+// Upload creates the given document in the cloud storage.
+//
+// The API does not support partial uploads, so the complete document
+// is always sent, even if only some of its pages have changed.
 func (r *repo) Upload(d *rmtool.Document) error {
 	err := d.Validate()
 	if err != nil {
@@ -151,7 +286,15 @@ func (r *repo) Upload(d *rmtool.Document) error {
 
 	logging.Debug("Upload the zip archive")
 
-	err = r.client.Upload(d.Name(), d.ID(), d.Parent(), buf)
+	meta := Item{
+		ID:          d.ID(),
+		Type:        rmtool.DocumentType,
+		Parent:      d.Parent(),
+		VisibleName: d.Name(),
+		Bookmarked:  d.Pinned(),
+		CurrentPage: d.LastOpenedPage(),
+	}
+	err = r.client.upload(meta, buf)
 	if err != nil {
 		return err
 	}
@@ -188,10 +331,23 @@ func (r *repo) downloadToCache(id string, version uint) error {
 		}
 	}()
 
-	logging.Debug("Download blob to %q\n", f.Name())
-	err = r.client.fetchBlob(i.BlobURLGet, f)
-	if err != nil {
-		return err
+	// A broken download must not end up in the cache,
+	// so we verify the archive and retry if it is corrupt.
+	// Transport errors are retried by the client, not here.
+	for attempt := 1; ; attempt++ {
+		logging.Debug("Download blob to %q\n", f.Name())
+		err = r.downloadBlob(i.BlobURLGet, f)
+		if err != nil {
+			return err
+		}
+		err = verifyZip(f.Name())
+		if err == nil {
+			break
+		}
+		if attempt >= maxDownloadAttempts {
+			return err
+		}
+		logging.Warning("Corrupt download for %q (attempt %d of %d): %v", id, attempt, maxDownloadAttempts, err)
 	}
 
 	// Lock for writing.
@@ -218,6 +374,55 @@ func (r *repo) downloadToCache(id string, version uint) error {
 	return nil
 }
 
+// downloadBlob downloads the blob from the given URL into the given file.
+// Existing content of the file is replaced.
+func (r *repo) downloadBlob(url string, f *os.File) error {
+	err := f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return r.client.fetchBlob(url, f)
+}
+
+// verifyZip checks that the file at the given path is a readable zip archive.
+// All entries are read completely to verify their checksums.
+func verifyZip(path string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return errors.Wrap(err, "invalid zip archive")
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		if err != nil {
+			return errors.Wrap(err, "invalid zip entry %q", zf.Name)
+		}
+		_, err = io.Copy(ioutil.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return errors.Wrap(err, "invalid zip entry %q", zf.Name)
+		}
+	}
+
+	return nil
+}
+
+// Size uses the size of the cached archive, if present.
+// Otherwise, the size of the archive is requested from the server.
+func (r *repo) Size(id string, version uint) (int64, error) {
+	info, err := os.Stat(r.cachePath(id, version))
+	if err == nil {
+		return info.Size(), nil
+	}
+	return r.client.BlobSize(id)
+}
+
 func (r *repo) cachePath(id string, version uint) string {
 	return filepath.Join(r.dataDir, fmt.Sprintf("%v_%v.zip", id, version))
 }
@@ -273,6 +478,7 @@ func (r *repo) cleanCache() {
 		for i := 0; i < len(v)-1; i++ {
 			p := r.cachePath(id, uint(v[i]))
 			logging.Info("Remove outdated version from cache: %q", p)
+			r.closeZip(p)
 			err = os.Remove(p)
 			if err != nil {
 				logging.Warning("Unexpected error removing old cache entry: %v", err)
@@ -284,7 +490,7 @@ func (r *repo) cleanCache() {
 
 // implement the Meta interface for an Item
 type metaWrapper struct {
-	i Item
+	i *Item
 	r *repo
 }
 
@@ -324,6 +530,14 @@ func (m metaWrapper) Parent() string {
 	return m.i.Parent
 }
 
+func (m metaWrapper) LastOpenedPage() int {
+	return m.i.CurrentPage
+}
+
+func (m metaWrapper) SetLastOpenedPage(p int) {
+	m.i.CurrentPage = p
+}
+
 func (m metaWrapper) Validate() error {
 	return m.i.Validate()
 }
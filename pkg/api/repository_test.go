@@ -0,0 +1,157 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akeil/rmtool/internal/errors"
+)
+
+func TestVerifyZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rm-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("doc.content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(bytes.Repeat([]byte("content "), 100))
+	zw.Close()
+	data := buf.Bytes()
+
+	valid := filepath.Join(dir, "valid.zip")
+	ioutil.WriteFile(valid, data, 0644)
+	err = verifyZip(valid)
+	if err != nil {
+		t.Errorf("valid zip archive not accepted: %v", err)
+	}
+
+	truncated := filepath.Join(dir, "truncated.zip")
+	ioutil.WriteFile(truncated, data[:len(data)/2], 0644)
+	err = verifyZip(truncated)
+	if err == nil {
+		t.Errorf("truncated zip archive not detected")
+	}
+
+	garbage := filepath.Join(dir, "garbage.zip")
+	ioutil.WriteFile(garbage, []byte("not a zip file"), 0644)
+	err = verifyZip(garbage)
+	if err == nil {
+		t.Errorf("invalid zip archive not detected")
+	}
+}
+
+func TestReaderRetainsZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rm-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &repo{dataDir: dir}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"doc.content", "doc/0.rm"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(name))
+	}
+	zw.Close()
+	ioutil.WriteFile(r.cachePath("doc", 3), buf.Bytes(), 0644)
+
+	for _, path := range [][]string{{"doc.content"}, {"doc", "0.rm"}} {
+		rc, err := r.Reader("doc", 3, path...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != filepath.Join(path...) {
+			t.Errorf("unexpected content %q", data)
+		}
+	}
+
+	if len(r.zips) != 1 {
+		t.Errorf("expected one retained zip reader, got %d", len(r.zips))
+	}
+
+	names, err := r.ListFiles("doc", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "doc.content" || names[1] != "doc/0.rm" {
+		t.Errorf("unexpected file list %v", names)
+	}
+
+	_, err = r.Reader("doc", 3, "missing")
+	if !errors.IsNotFound(err) {
+		t.Errorf("expected not found error for missing entry, got %v", err)
+	}
+
+	r.closeZip(r.cachePath("doc", 3))
+	if len(r.zips) != 0 {
+		t.Errorf("zip reader not removed after close")
+	}
+}
+
+func TestDownloadRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rm-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fetch := "GET " + epList + "?doc=" + testDocID + "&withBlob=true"
+	blob := "GET /get/7e4c5a1e"
+	count := func(ft *fixtureTransport, route string) int {
+		n := 0
+		for _, r := range ft.requests {
+			if r == route {
+				n++
+			}
+		}
+		return n
+	}
+
+	// a corrupt archive is downloaded again
+	c, ft := fixtureClient(map[string]string{
+		fetch: "item.json",
+		blob:  "token.txt",
+	})
+	r := NewRepository(c, dir).(*repo)
+	err = r.downloadToCache(testDocID, 12)
+	if err == nil {
+		t.Errorf("expected error for corrupt archive")
+	}
+	if n := count(ft, blob); n != maxDownloadAttempts {
+		t.Errorf("unexpected number of downloads for corrupt archive: %d", n)
+	}
+
+	// errors which cannot be fixed by retrying are not retried
+	c, ft = fixtureClient(map[string]string{
+		fetch: "item.json",
+	})
+	r = NewRepository(c, dir).(*repo)
+	err = r.downloadToCache(testDocID, 12)
+	if err == nil {
+		t.Errorf("expected error for missing blob")
+	}
+	if n := count(ft, blob); n != 1 {
+		t.Errorf("unexpected number of downloads for missing blob: %d", n)
+	}
+}
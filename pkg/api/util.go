@@ -25,25 +25,30 @@ func resolve(base, endpoint string) (string, error) {
 }
 
 // parseTokenExpiration retrieves the expiration time from the user token.
+//
+// Returns an error if the token cannot be decoded
+// or if it does not contain an `exp` claim.
 func parseTokenExpiration(token string) (time.Time, error) {
 	var t time.Time
 
 	// split the JWT into its parts (header.payload.signature),
 	// we are only interested in the `payload`.
-	parts := strings.Split(token, ".")
+	parts := strings.Split(strings.TrimSpace(token), ".")
 	if len(parts) < 2 {
 		return t, fmt.Errorf("unexpected number of token segments")
 	}
 
-	// decode from base64
-	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	// decode from base64,
+	// the segments *should* be unpadded, but we accept padding as well.
+	payload := strings.TrimRight(parts[1], "=")
+	data, err := base64.RawURLEncoding.DecodeString(payload)
 	if err != nil {
-		return t, err
+		return t, fmt.Errorf("failed to decode token payload: %v", err)
 	}
 
 	// parse the one field we are interested in
 	jwt := struct {
-		Exp int64 `json:"exp"`
+		Exp *json.Number `json:"exp"`
 	}{}
 	dec := json.NewDecoder(bytes.NewReader(data))
 	err = dec.Decode(&jwt)
@@ -51,5 +56,15 @@ func parseTokenExpiration(token string) (time.Time, error) {
 		return t, err
 	}
 
-	return time.Unix(jwt.Exp, 0), nil
+	if jwt.Exp == nil {
+		return t, fmt.Errorf("token has no exp claim")
+	}
+
+	// exp is "NumericDate", which allows fractional seconds
+	secs, err := jwt.Exp.Float64()
+	if err != nil {
+		return t, fmt.Errorf("invalid exp claim %q", jwt.Exp.String())
+	}
+
+	return time.Unix(int64(secs), 0), nil
 }
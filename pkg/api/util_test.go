@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseTokenExpiration(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`))
+	mkToken := func(payload string, enc *base64.Encoding) string {
+		return header + "." + enc.EncodeToString([]byte(payload)) + ".signature"
+	}
+
+	token := mkToken(`{"exp":1608230074}`, base64.RawURLEncoding)
+	exp, err := parseTokenExpiration(token)
+	if err != nil {
+		t.Error(err)
+	}
+	if exp.Unix() != 1608230074 {
+		t.Errorf("unexpected expiration time: %v", exp.Unix())
+	}
+
+	// padded base64
+	token = mkToken(`{"exp":1608230074}`, base64.URLEncoding)
+	exp, err = parseTokenExpiration(token)
+	if err != nil {
+		t.Errorf("padded token not accepted: %v", err)
+	}
+	if exp.Unix() != 1608230074 {
+		t.Errorf("unexpected expiration time: %v", exp.Unix())
+	}
+
+	// fractional seconds
+	token = mkToken(`{"exp":1608230074.5}`, base64.RawURLEncoding)
+	exp, err = parseTokenExpiration(token)
+	if err != nil {
+		t.Errorf("fractional exp not accepted: %v", err)
+	}
+	if exp.Unix() != 1608230074 {
+		t.Errorf("unexpected expiration time: %v", exp.Unix())
+	}
+
+	token = mkToken(`{"sub":"someone"}`, base64.RawURLEncoding)
+	_, err = parseTokenExpiration(token)
+	if err == nil {
+		t.Errorf("missing exp claim not detected")
+	}
+
+	_, err = parseTokenExpiration("not-a-token")
+	if err == nil {
+		t.Errorf("invalid token not detected")
+	}
+}
@@ -0,0 +1,210 @@
+// Package download saves documents from the reMarkable cloud as PDF files
+// as soon as they are changed.
+//
+// This is kept separate from the api package so that users of the API
+// client do not depend on the rendering packages.
+package download
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/internal/errors"
+	"github.com/akeil/rmtool/internal/fs"
+	"github.com/akeil/rmtool/internal/logging"
+	"github.com/akeil/rmtool/pkg/api"
+	"github.com/akeil/rmtool/pkg/render"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// Downloader saves documents as PDF files as soon as they are announced
+// by the notification service.
+//
+// Repeated messages for the same document version are ignored.
+// If the connection to the notification service is lost,
+// the Downloader reconnects automatically until it is stopped.
+type Downloader struct {
+	client  *api.Client
+	repo    rmtool.Repository
+	rc      *render.Context
+	dstDir  string
+	notif   *api.Notifications
+	seen    map[string]int
+	running bool
+	mx      sync.Mutex
+}
+
+// WatchAndDownload creates a Downloader which saves new or changed documents
+// from the given repository to dstDir and starts it.
+//
+// Documents are rendered with the given rendering context.
+func WatchAndDownload(c *api.Client, repo rmtool.Repository, rc *render.Context, dstDir string) (*Downloader, error) {
+	d := &Downloader{
+		client: c,
+		repo:   repo,
+		rc:     rc,
+		dstDir: dstDir,
+		seen:   make(map[string]int),
+	}
+
+	err := d.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Start connects to the notification service and starts downloading.
+// Calling Start on a running Downloader has no effect.
+func (d *Downloader) Start() error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if d.running {
+		return nil
+	}
+
+	n, err := d.client.NewNotifications()
+	if err != nil {
+		return err
+	}
+	n.OnDocAdded(d.handle)
+	n.OnDisconnect(d.reconnect)
+
+	err = n.Connect()
+	if err != nil {
+		return err
+	}
+
+	d.notif = n
+	d.running = true
+	return nil
+}
+
+// Stop disconnects from the notification service.
+// Downloads which are in progress are not cancelled.
+func (d *Downloader) Stop() {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if !d.running {
+		return
+	}
+
+	d.running = false
+	d.notif.ClearHandlers()
+	d.notif.OnDisconnect(nil)
+	d.notif.Disconnect()
+	d.notif = nil
+}
+
+// reconnect tries to restore the connection to the notification service,
+// with increasing delays between attempts.
+func (d *Downloader) reconnect() {
+	delay := minReconnectDelay
+	for {
+		d.mx.Lock()
+		if !d.running {
+			d.mx.Unlock()
+			return
+		}
+		n := d.notif
+		d.mx.Unlock()
+
+		logging.Info("Reconnect to notification service")
+		err := n.Connect()
+		if err == nil {
+			return
+		}
+		logging.Warning("Reconnect failed, retry in %v: %v", delay, err)
+
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// shouldHandle tells if a message refers to a document version that has not
+// been handled before and records it as seen.
+func (d *Downloader) shouldHandle(m api.Message) bool {
+	if m.Type != rmtool.DocumentType || m.Parent == rmtool.TrashFolder {
+		return false
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	v, ok := d.seen[m.ItemID]
+	if ok && v >= m.Version {
+		return false
+	}
+	d.seen[m.ItemID] = m.Version
+	return true
+}
+
+func (d *Downloader) handle(m api.Message) {
+	if !d.shouldHandle(m) {
+		logging.Debug("Skip message for %q, version %v", m.ItemID, m.Version)
+		return
+	}
+
+	err := d.download(m.ItemID)
+	if err != nil {
+		logging.Error("Failed to download %q: %v", m.VisibleName, err)
+		// allow another attempt on the next message
+		d.mx.Lock()
+		delete(d.seen, m.ItemID)
+		d.mx.Unlock()
+	}
+}
+
+// download renders the document with the given ID to a PDF file.
+func (d *Downloader) download(id string) error {
+	items, err := d.repo.List()
+	if err != nil {
+		return err
+	}
+
+	var meta rmtool.Meta
+	for _, item := range items {
+		if item.ID() == id {
+			meta = item
+			break
+		}
+	}
+	if meta == nil {
+		return errors.NewNotFound("no item with id %q", id)
+	}
+
+	doc, err := rmtool.ReadDocument(d.repo, meta)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(d.dstDir, filename(doc))
+	logging.Info("Download %q to %q", doc.Name(), path)
+
+	// a failed render must not replace an earlier download
+	return fs.WriteFile(path, func(w io.Writer) error {
+		return d.rc.Pdf(doc, w)
+	})
+}
+
+// filename returns the name of the PDF file for a document.
+//
+// Names are not unique, the ID is included so that documents with the
+// same name do not overwrite each other.
+func filename(m rmtool.Meta) string {
+	// document names may contain slashes
+	name := strings.ReplaceAll(m.Name(), string(filepath.Separator), "_")
+	return fmt.Sprintf("%v_%v.pdf", name, m.ID())
+}
@@ -0,0 +1,54 @@
+package download
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/pkg/api"
+)
+
+func TestDownloaderDedupe(t *testing.T) {
+	d := &Downloader{seen: make(map[string]int)}
+	m := api.Message{ItemID: "doc", Version: 2, Type: rmtool.DocumentType}
+
+	if !d.shouldHandle(m) {
+		t.Errorf("first message should be handled")
+	}
+	if d.shouldHandle(m) {
+		t.Errorf("repeated message should be skipped")
+	}
+
+	m.Version = 1
+	if d.shouldHandle(m) {
+		t.Errorf("message for older version should be skipped")
+	}
+
+	m.Version = 3
+	if !d.shouldHandle(m) {
+		t.Errorf("message for newer version should be handled")
+	}
+
+	folder := api.Message{ItemID: "folder", Version: 1, Type: rmtool.CollectionType}
+	if d.shouldHandle(folder) {
+		t.Errorf("folders should be skipped")
+	}
+
+	trashed := api.Message{ItemID: "other", Version: 1, Type: rmtool.DocumentType, Parent: rmtool.TrashFolder}
+	if d.shouldHandle(trashed) {
+		t.Errorf("trashed documents should be skipped")
+	}
+}
+
+func TestFilename(t *testing.T) {
+	a := rmtool.NewNotebook("Notes", "")
+	b := rmtool.NewNotebook("Notes", "")
+	if filename(a) == filename(b) {
+		t.Errorf("documents with the same name have the same filename %q", filename(a))
+	}
+
+	c := rmtool.NewNotebook("a/b", "")
+	if strings.Contains(filename(c), "/") {
+		t.Errorf("unexpected separator in filename %q", filename(c))
+	}
+}
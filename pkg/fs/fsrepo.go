@@ -10,14 +10,23 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/akeil/rmtool"
-	fsx "github.com/akeil/rmtool/internal/errors"
+	"github.com/akeil/rmtool/internal/errors"
 	fsx "github.com/akeil/rmtool/internal/fs"
 	"github.com/akeil/rmtool/internal/logging"
 )
 
+// lockFile is the name of the lock file in the repository directory.
+const lockFile = ".rmtool.lock"
+
+// lockTimeout is the maximum time to wait for the lock.
+var lockTimeout = 10 * time.Second
+
 type repo struct {
-	base string
+	base    string
+	locking bool
 }
 
 // NewRepository creates a repository backed by the local file system.
@@ -30,6 +39,43 @@ func NewRepository(path string) rmtool.Repository {
 	}
 }
 
+// NewRepositoryWithLock creates a repository backed by the local file system
+// which uses a lock file to serialize write operations.
+//
+// Write operations (update, upload, delete, ...) take an advisory lock on
+// a file ".rmtool.lock" in the repository directory, so that multiple
+// rmtool processes do not write the same files at the same time.
+// Read operations do not use the lock.
+//
+// The lock does NOT coordinate with the software on the tablet (xochitl),
+// which does not know about the lock file.
+func NewRepositoryWithLock(path string) rmtool.Repository {
+	return &repo{
+		base:    path,
+		locking: true,
+	}
+}
+
+// lock acquires the repository lock, if locking is enabled.
+// Returns a function that releases the lock.
+func (r *repo) lock() (func(), error) {
+	if !r.locking {
+		return func() {}, nil
+	}
+
+	l, err := fsx.AcquireLock(filepath.Join(r.base, lockFile), lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		err := l.Release()
+		if err != nil {
+			logging.Warning("Failed to release lock: %v", err)
+		}
+	}, nil
+}
+
 func (r *repo) List() ([]rmtool.Meta, error) {
 	logging.Debug("List files from %q", r.base)
 
@@ -63,6 +109,16 @@ func (r *repo) readItem(id string) (rmtool.Meta, error) {
 }
 
 func (r *repo) Update(m rmtool.Meta) error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return r.update(m)
+}
+
+func (r *repo) update(m rmtool.Meta) error {
 	logging.Debug("Update entry with id %q, version %v", m.ID(), m.Version())
 	err := m.Validate()
 	if err != nil {
@@ -81,7 +137,7 @@ func (r *repo) Update(m rmtool.Meta) error {
 
 	// check the version
 	if m.Version() != o.Version {
-		return fmt.Errorf("version mismatch %d != %d", m.Version(), o.Version)
+		return errors.VersionConflictError{Local: m.Version(), Remote: o.Version}
 	}
 
 	o.Version++
@@ -96,25 +152,15 @@ func (r *repo) Update(m rmtool.Meta) error {
 	o.Pinned = m.Pinned()
 	o.Parent = m.Parent()
 	o.Type = m.Type()
+	o.LastOpenedPage = uint(m.LastOpenedPage())
 
-	// to tempfile
-	f, err := ioutil.TempFile("", "rm-*.json")
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	logging.Debug("Write JSON to tempfile at %q", f.Name())
-	err = json.NewEncoder(f).Encode(&o)
-	if err != nil {
-		return err
-	}
-
-	logging.Debug("Move updated JSON document to %q\n", p)
-
-	return fsx.Move(f.Name(), p)
+	return writeJSON(p, &o)
 }
 
+// Upload writes the given document to the repository.
+//
+// Files which already exist with identical content are not rewritten,
+// so uploading a changed document only replaces the modified parts.
 func (r *repo) Upload(d *rmtool.Document) error {
 	err := d.Validate()
 	if err != nil {
@@ -125,6 +171,12 @@ func (r *repo) Upload(d *rmtool.Document) error {
 		return err
 	}
 
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// We will write everything to a temporary directory,
 	// then move to the target dir
 	tmp, err := ioutil.TempDir("", "rm-upload-*")
@@ -192,7 +244,7 @@ func (r *repo) Upload(d *rmtool.Document) error {
 		Pinned:           d.Pinned(),
 		Type:             d.Type(),
 		VisibleName:      d.Name(),
-		LastOpenedPage:   0,
+		LastOpenedPage:   uint(d.LastOpenedPage()),
 		Deleted:          false,
 		MetadataModified: false,
 		Modified:         false,
@@ -217,38 +269,151 @@ func (r *repo) Upload(d *rmtool.Document) error {
 		return err
 	}
 
-	// TODO: if we have an error during one of the moves,
-	// the partially transferred content in dst needs cleanup
+	// Move everything to the target directory.
+	logging.Debug("Move files to %q...", r.base)
+	return r.commitFiles(d.ID(), files, filepath.Join(tmp, ".backup"))
+}
+
+// move is used to move files into the repository.
+// It is a variable so that tests can simulate failures.
+var move = fsx.Move
+
+// commitFiles moves the given files from the staging area into the
+// repository. The files map relative target paths to staged files.
+//
+// Existing files are moved to the backup directory before they are replaced.
+// If any step fails, the files written so far are removed,
+// replaced files are restored and created directories are removed again,
+// leaving the repository as it was before.
+func (r *repo) commitFiles(id string, files map[string]string, backupDir string) (err error) {
+	var written []string
+	var createdDirs []string
+	backups := make(map[string]string)
+
+	defer func() {
+		if err == nil {
+			return
+		}
+		logging.Warning("Upload failed, roll back changes: %v", err)
+		for _, dst := range written {
+			rmErr := os.Remove(dst)
+			if rmErr != nil && !os.IsNotExist(rmErr) {
+				logging.Error("Failed to remove %q: %v", dst, rmErr)
+			}
+		}
+		for dst, bak := range backups {
+			mvErr := move(bak, dst)
+			if mvErr != nil {
+				logging.Error("Failed to restore %q from %q: %v", dst, bak, mvErr)
+			}
+		}
+		for i := len(createdDirs) - 1; i >= 0; i-- {
+			rmErr := os.Remove(createdDirs[i])
+			if rmErr != nil {
+				logging.Error("Failed to remove directory %q: %v", createdDirs[i], rmErr)
+			}
+		}
+	}()
+
+	mkdir := func(dir string) error {
+		err := os.Mkdir(dir, 0755)
+		if err != nil {
+			if os.IsExist(err) {
+				return nil
+			}
+			return err
+		}
+		createdDirs = append(createdDirs, dir)
+		return nil
+	}
 
 	// We always create the <ID>/ subdirectory, even if it will be empty.
 	// At least, this seems to be the behaviour of the remarkable tablet.
-	pagesDir := filepath.Join(r.base, d.ID())
-	err = os.Mkdir(pagesDir, 0755)
+	err = mkdir(filepath.Join(r.base, id))
 	if err != nil {
-		if !os.IsExist(err) {
-			return err
-		}
+		return err
+	}
+	err = os.Mkdir(backupDir, 0755)
+	if err != nil {
+		return err
 	}
 
-	// Move everything to the target directory.
-	logging.Debug("Move files to %q...", r.base)
 	for rel, src := range files {
 		dst := filepath.Join(r.base, rel)
 		// Create a subdirectory if needed.
 		dir, _ := filepath.Split(rel)
 		if dir != "" {
 			logging.Debug("Create subdirectory %q", dir)
-			absDir := filepath.Join(r.base, dir)
-			err := os.Mkdir(absDir, 0755)
+			err = mkdir(filepath.Join(r.base, dir))
 			if err != nil {
-				if !os.IsExist(err) {
-					return err
-				}
+				return err
+			}
+		}
+
+		// Leave files alone if they have not changed.
+		var same bool
+		same, err = fsx.SameContent(src, dst)
+		if err != nil {
+			return err
+		}
+		if same {
+			logging.Debug("Skip unchanged %v", rel)
+			continue
+		}
+
+		_, err = os.Stat(dst)
+		if err == nil {
+			bak := filepath.Join(backupDir, fmt.Sprintf("%d", len(backups)))
+			err = move(dst, bak)
+			if err != nil {
+				return err
 			}
+			backups[dst] = bak
+		} else if !os.IsNotExist(err) {
+			return err
 		}
+
 		logging.Debug("Move %v", rel)
+		written = append(written, dst)
+		err = move(src, dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *repo) Delete(m rmtool.Meta) error {
+	logging.Debug("Delete entry with id %q", m.ID())
+	if m.ID() == "" {
+		return fmt.Errorf("id must not be empty")
+	}
+
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if m.Type() == rmtool.CollectionType {
+		err = r.checkEmpty(m.ID())
+		if err != nil {
+			return err
+		}
+	}
+
+	// All files and directories for an item are prefixed with its ID,
+	// e.g. "<ID>.metadata", "<ID>.content", "<ID>/", "<ID>.thumbnails/".
+	paths, err := filepath.Glob(filepath.Join(r.base, m.ID()+".*"))
+	if err != nil {
+		return err
+	}
+	paths = append(paths, filepath.Join(r.base, m.ID()))
 
-		err = fsx.Move(src, dst)
+	for _, p := range paths {
+		logging.Debug("Remove %q", p)
+		err = os.RemoveAll(p)
 		if err != nil {
 			return err
 		}
@@ -257,6 +422,94 @@ func (r *repo) Upload(d *rmtool.Document) error {
 	return nil
 }
 
+func (r *repo) CreateFolder(parentID, name string) error {
+	err := r.checkParent(parentID)
+	if err != nil {
+		return err
+	}
+
+	id := uuid.New().String()
+	logging.Debug("Create folder %q with id %q", name, id)
+
+	meta := Metadata{
+		LastModified: Timestamp{time.Now()},
+		Version:      1,
+		Parent:       parentID,
+		Type:         rmtool.CollectionType,
+		VisibleName:  name,
+	}
+	err = meta.Validate()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Collections have an empty content object.
+	err = writeJSON(filepath.Join(r.base, id+".content"), struct{}{})
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(filepath.Join(r.base, id+".metadata"), &meta)
+}
+
+func (r *repo) Restore(id, parentID string) error {
+	logging.Debug("Restore entry with id %q to parent %q", id, parentID)
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	meta, err := readMetadata(filepath.Join(r.base, id+".metadata"))
+	if err != nil {
+		return err
+	}
+
+	if meta.Parent != rmtool.TrashFolder {
+		return fmt.Errorf("item with id %q is not in trash", id)
+	}
+
+	// update() checks if the new parent exists
+	meta.Parent = parentID
+	return r.update(metaWrapper{id: id, i: &meta, repo: r})
+}
+
+func (r *repo) Move(id, parentID string) error {
+	logging.Debug("Move entry with id %q to parent %q", id, parentID)
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	meta, err := readMetadata(filepath.Join(r.base, id+".metadata"))
+	if err != nil {
+		return err
+	}
+
+	// Early exit if there is no actual change
+	if meta.Parent == parentID {
+		return nil
+	}
+
+	if meta.Type == rmtool.CollectionType {
+		err = r.checkNotDescendant(id, parentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// update() checks if the new parent exists
+	meta.Parent = parentID
+	return r.update(metaWrapper{id: id, i: &meta, repo: r})
+}
+
 func (r repo) PagePrefix(id string, index int) string {
 	return id
 }
@@ -275,6 +528,67 @@ func (r *repo) Reader(id string, version uint, path ...string) (io.ReadCloser, e
 	return f, err
 }
 
+func (r *repo) ListFiles(id string, version uint) ([]string, error) {
+	if id == "" {
+		return nil, fmt.Errorf("id must not be empty")
+	}
+
+	// All files and directories for an item are prefixed with its ID,
+	// e.g. "<ID>.metadata", "<ID>.content", "<ID>/", "<ID>.thumbnails/".
+	paths, err := filepath.Glob(filepath.Join(r.base, id+".*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, errors.NewNotFound("no files for item with id %q", id)
+	}
+	paths = append(paths, filepath.Join(r.base, id))
+
+	names := make([]string, 0)
+	for _, p := range paths {
+		err = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(r.base, path)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
+
+func (r *repo) Size(id string, version uint) (int64, error) {
+	names, err := r.ListFiles(id, version)
+	if err != nil {
+		return rmtool.UnknownSize, err
+	}
+
+	var size int64
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(r.base, filepath.FromSlash(name)))
+		if err != nil {
+			return rmtool.UnknownSize, err
+		}
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
 func (r *repo) checkParent(parentID string) error {
 	if parentID == "" {
 		return nil
@@ -292,6 +606,57 @@ func (r *repo) checkParent(parentID string) error {
 	return nil
 }
 
+// checkNotDescendant returns an error if the parent is the item with the
+// given id or one of its descendants.
+func (r *repo) checkNotDescendant(id, parentID string) error {
+	items, err := r.List()
+	if err != nil {
+		return err
+	}
+
+	if rmtool.IsDescendant(items, parentID, id) {
+		return fmt.Errorf("cannot move %q into itself or one of its subfolders", id)
+	}
+
+	return nil
+}
+
+// checkEmpty returns an error if the collection with the given id
+// has any content.
+func (r *repo) checkEmpty(id string) error {
+	items, err := r.List()
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if item.Parent() == id {
+			return fmt.Errorf("collection is not empty")
+		}
+	}
+
+	return nil
+}
+
+// writeJSON writes the given value as JSON to a tempfile
+// and moves it to the given path when complete.
+func writeJSON(path string, v interface{}) error {
+	f, err := ioutil.TempFile("", "rm-*.json")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	logging.Debug("Write JSON to tempfile at %q", f.Name())
+	err = json.NewEncoder(f).Encode(v)
+	if err != nil {
+		return err
+	}
+
+	logging.Debug("Move JSON document to %q", path)
+	return fsx.Move(f.Name(), path)
+}
+
 func readMetadata(path string) (Metadata, error) {
 	var m Metadata
 	r, err := os.Open(path)
@@ -353,6 +718,14 @@ func (m metaWrapper) Parent() string {
 	return m.i.Parent
 }
 
+func (m metaWrapper) LastOpenedPage() int {
+	return int(m.i.LastOpenedPage)
+}
+
+func (m metaWrapper) SetLastOpenedPage(p int) {
+	m.i.LastOpenedPage = uint(p)
+}
+
 func (m metaWrapper) Validate() error {
 	return m.i.Validate()
 }
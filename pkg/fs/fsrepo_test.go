@@ -0,0 +1,630 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+
+	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/internal/errors"
+	fsx "github.com/akeil/rmtool/internal/fs"
+	"github.com/akeil/rmtool/pkg/lines"
+)
+
+func TestRestore(t *testing.T) {
+	dir := setupRepoDir(t)
+	defer os.RemoveAll(dir)
+	writeTestMetadata(t, dir, "doc", rmtool.DocumentType, rmtool.TrashFolder)
+	writeTestMetadata(t, dir, "folder", rmtool.CollectionType, "")
+	writeTestMetadata(t, dir, "other", rmtool.DocumentType, "")
+
+	r := NewRepository(dir).(rmtool.Restorer)
+
+	err := r.Restore("doc", "does-not-exist")
+	if err == nil {
+		t.Errorf("missing parent folder not detected")
+	}
+
+	err = r.Restore("doc", "folder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := readMetadata(filepath.Join(dir, "doc.metadata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Parent != "folder" {
+		t.Errorf("unexpected parent after restore: %q", m.Parent)
+	}
+
+	err = r.Restore("other", "")
+	if err == nil {
+		t.Errorf("restore for item not in trash not detected")
+	}
+}
+
+func TestMove(t *testing.T) {
+	dir := setupRepoDir(t)
+	defer os.RemoveAll(dir)
+	writeTestMetadata(t, dir, "doc", rmtool.DocumentType, "")
+	writeTestMetadata(t, dir, "folder", rmtool.CollectionType, "")
+	writeTestMetadata(t, dir, "sub", rmtool.CollectionType, "folder")
+
+	r := NewRepository(dir).(rmtool.Mover)
+
+	err := r.Move("doc", "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := readMetadata(filepath.Join(dir, "doc.metadata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Parent != "sub" {
+		t.Errorf("unexpected parent after move: %q", m.Parent)
+	}
+
+	err = r.Move("folder", "doc")
+	if err == nil {
+		t.Errorf("move into a document not detected")
+	}
+	err = r.Move("folder", "sub")
+	if err == nil {
+		t.Errorf("move into a subfolder not detected")
+	}
+	err = r.Move("folder", "folder")
+	if err == nil {
+		t.Errorf("move into itself not detected")
+	}
+	err = r.Move("doc", "does-not-exist")
+	if err == nil {
+		t.Errorf("missing parent folder not detected")
+	}
+
+	err = r.Move("sub", "")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func setupRepoDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "rm-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeTestMetadata(t *testing.T, dir, id string, nt rmtool.NotebookType, parentID string) {
+	m := Metadata{
+		Version:     1,
+		Parent:      parentID,
+		Type:        nt,
+		VisibleName: id,
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, id+".metadata"), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdatePreservesUnknownFields(t *testing.T) {
+	dir := setupRepoDir(t)
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "doc.metadata")
+	jsonStr := `{
+        "lastModified": "1608230074814",
+        "parent": "",
+        "type": "DocumentType",
+        "version": 1,
+        "visibleName": "Test",
+        "someNewField": {"x": 42}
+    }`
+	err := ioutil.WriteFile(p, []byte(jsonStr), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRepository(dir)
+	items, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := items[0]
+	m.SetName("Changed")
+	err = r.Update(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw["someNewField"]) != `{"x":42}` {
+		t.Errorf("unknown field was not preserved: %s", data)
+	}
+	if string(raw["visibleName"]) != `"Changed"` {
+		t.Errorf("update was not applied: %s", data)
+	}
+}
+
+func TestUpdateVersionConflict(t *testing.T) {
+	dir := setupRepoDir(t)
+	defer os.RemoveAll(dir)
+	writeTestMetadata(t, dir, "doc", rmtool.DocumentType, "")
+
+	r := NewRepository(dir)
+	items, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := items[0]
+
+	err = r.Update(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// m still refers to the old version
+	err = r.Update(m)
+	if !rmtool.IsVersionConflict(err) {
+		t.Errorf("expected version conflict, got %v", err)
+	}
+}
+
+func TestUploadRollback(t *testing.T) {
+	dir := setupRepoDir(t)
+	defer os.RemoveAll(dir)
+
+	r := NewRepository(dir)
+	doc := rmtool.NewNotebook("test", "")
+	doc.CreatePage()
+
+	// fail on the second file that is moved into the repository
+	count := 0
+	failing := func(src, dst string) error {
+		if filepath.Dir(dst) == dir {
+			count++
+			if count == 2 {
+				return fmt.Errorf("simulated failure")
+			}
+		}
+		return fsx.Move(src, dst)
+	}
+	move = failing
+	defer func() { move = fsx.Move }()
+
+	err := r.Upload(doc)
+	if err == nil {
+		t.Fatal("expected upload to fail")
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Errorf("unexpected leftover after failed upload: %q", e.Name())
+	}
+
+	// without failures, the upload should work
+	move = fsx.Move
+	err = r.Upload(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("expected one item after upload, got %d", len(items))
+	}
+
+	// replaced files are restored after a failure;
+	// only the metadata has changed, so fail on the first move
+	count = 1
+	move = failing
+	doc.SetName("changed")
+	err = r.Upload(doc)
+	if err == nil {
+		t.Fatal("expected upload to fail")
+	}
+	m, err := readMetadata(filepath.Join(dir, doc.ID()+".metadata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.VisibleName != "test" {
+		t.Errorf("metadata not restored after failed upload, name is %q", m.VisibleName)
+	}
+}
+
+func TestLocking(t *testing.T) {
+	dir := setupRepoDir(t)
+	defer os.RemoveAll(dir)
+	writeTestMetadata(t, dir, "doc", rmtool.DocumentType, "")
+
+	lockTimeout = 100 * time.Millisecond
+	defer func() { lockTimeout = 10 * time.Second }()
+
+	r := NewRepositoryWithLock(dir)
+	items, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate another process holding the lock
+	l, err := fsx.AcquireLock(filepath.Join(dir, lockFile), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Update(items[0])
+	if err == nil {
+		t.Errorf("update should fail while the lock is held")
+	}
+
+	// reading is still possible
+	_, err = r.List()
+	if err != nil {
+		t.Errorf("list should work while the lock is held: %v", err)
+	}
+
+	l.Release()
+	err = r.Update(items[0])
+	if err != nil {
+		t.Errorf("update failed after lock was released: %v", err)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, lockFile))
+	if !os.IsNotExist(err) {
+		t.Errorf("lock file not removed after update")
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	dir := setupRepoDir(t)
+	defer os.RemoveAll(dir)
+
+	r := NewRepository(dir)
+	doc := rmtool.NewNotebook("test", "")
+	err := r.Upload(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := r.ListFiles(doc.ID(), doc.Version())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	expected := []string{
+		doc.ID() + ".content",
+		doc.ID() + ".metadata",
+		doc.ID() + ".pagedata",
+	}
+	for _, pageID := range doc.Pages() {
+		expected = append(expected, doc.ID()+"/"+pageID+"-metadata.json")
+		expected = append(expected, doc.ID()+"/"+pageID+".rm")
+	}
+	sort.Strings(expected)
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected file list %v, want %v", names, expected)
+	}
+
+	_, err = r.ListFiles("does-not-exist", 0)
+	if err == nil {
+		t.Errorf("expected error for unknown id")
+	}
+}
+
+func TestSize(t *testing.T) {
+	dir := setupRepoDir(t)
+	defer os.RemoveAll(dir)
+
+	r := NewRepository(dir)
+	doc := rmtool.NewNotebook("test", "")
+	err := r.Upload(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := r.ListFiles(doc.ID(), doc.Version())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected int64
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected += info.Size()
+	}
+
+	items, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := rmtool.ReadDocument(r, items[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := read.EstimatedSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != expected {
+		t.Errorf("unexpected size %d, want %d", size, expected)
+	}
+
+	// same size from a zip archive
+	data := zipDocument(t, dir, doc, true)
+	zr, err := NewZipRepository(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err = zr.(rmtool.Sizer).Size(doc.ID(), doc.Version())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != expected {
+		t.Errorf("unexpected size from zip %d, want %d", size, expected)
+	}
+
+	_, err = r.(rmtool.Sizer).Size("does-not-exist", 0)
+	if err == nil {
+		t.Errorf("expected error for unknown id")
+	}
+	size, err = zr.(rmtool.Sizer).Size("does-not-exist", 0)
+	if !errors.IsNotFound(err) || size != rmtool.UnknownSize {
+		t.Errorf("expected not found and unknown size from zip, got %d, %v", size, err)
+	}
+}
+
+func TestUploadRenamedDocument(t *testing.T) {
+	dir := setupRepoDir(t)
+	defer os.RemoveAll(dir)
+
+	r := NewRepository(dir)
+	doc := rmtool.NewNotebook("Original", "")
+	doc.SetName("Renamed")
+
+	err := r.Upload(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("expected one item, got %d", len(items))
+	}
+	if items[0].Name() != "Renamed" {
+		t.Errorf("unexpected name in metadata: %q", items[0].Name())
+	}
+
+	read, err := rmtool.ReadDocument(r, items[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Name() != "Renamed" {
+		t.Errorf("unexpected name after reading document: %q", read.Name())
+	}
+}
+
+func TestCopy(t *testing.T) {
+	srcDir := setupRepoDir(t)
+	defer os.RemoveAll(srcDir)
+	dstDir := setupRepoDir(t)
+	defer os.RemoveAll(dstDir)
+	writeTestMetadata(t, dstDir, "folder", rmtool.CollectionType, "")
+
+	src := NewRepository(srcDir)
+	dst := NewRepository(dstDir)
+
+	doc := rmtool.NewNotebook("Copied", "")
+	doc.SetPinned(true)
+	drawing, err := doc.Drawing(doc.Pages()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	drawing.Layers[0].Strokes = append(drawing.Layers[0].Strokes, lines.Stroke{
+		BrushType:  lines.Fineliner,
+		BrushColor: lines.Black,
+		BrushSize:  lines.Medium,
+		Dots:       []lines.Dot{{X: 10, Y: 20, Width: 2, Pressure: 0.5}},
+	})
+	err = src.Upload(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rmtool.Copy(src, doc.ID(), dst, "folder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := dst.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var copied rmtool.Meta
+	for _, m := range items {
+		if m.Type() == rmtool.DocumentType {
+			copied = m
+		}
+	}
+	if copied == nil {
+		t.Fatal("document was not copied")
+	}
+	if copied.ID() == doc.ID() {
+		t.Errorf("expected a new ID for the copy")
+	}
+	if copied.Name() != "Copied" || !copied.Pinned() || copied.Parent() != "folder" {
+		t.Errorf("unexpected metadata for copy: %q, pinned=%v, parent=%q", copied.Name(), copied.Pinned(), copied.Parent())
+	}
+
+	read, err := rmtool.ReadDocument(dst, copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.PageCount() != 1 {
+		t.Fatalf("unexpected page count %d", read.PageCount())
+	}
+	d, err := read.Drawing(read.Pages()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Layers[0].Strokes) != 1 {
+		t.Errorf("drawing was not copied")
+	}
+
+	err = rmtool.Copy(src, "does-not-exist", dst, "")
+	if err == nil {
+		t.Errorf("expected error for unknown id")
+	}
+}
+
+func TestUploadLazyDocument(t *testing.T) {
+	srcDir := setupRepoDir(t)
+	defer os.RemoveAll(srcDir)
+	dstDir := setupRepoDir(t)
+	defer os.RemoveAll(dstDir)
+
+	src := NewRepository(srcDir)
+	dst := NewRepository(dstDir)
+
+	doc := rmtool.NewNotebook("Lazy", "")
+	for _, pageID := range doc.Pages() {
+		drawing, err := doc.Drawing(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drawing.Layers[0].Strokes = append(drawing.Layers[0].Strokes, lines.Stroke{
+			BrushType:  lines.Fineliner,
+			BrushColor: lines.Black,
+			BrushSize:  lines.Medium,
+			Dots:       []lines.Dot{{X: 10, Y: 20, Width: 2, Pressure: 0.5}},
+		})
+	}
+	err := src.Upload(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := src.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("unexpected number of items %d", len(items))
+	}
+
+	// no pages or drawings are loaded before the upload
+	lazy, err := rmtool.ReadDocument(src, items[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dst.Upload(lazy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err = dst.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("unexpected number of items %d", len(items))
+	}
+	read, err := rmtool.ReadDocument(dst, items[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.PageCount() != 1 {
+		t.Fatalf("unexpected page count %d", read.PageCount())
+	}
+	for _, pageID := range read.Pages() {
+		d, err := read.Drawing(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(d.Layers[0].Strokes) != 1 {
+			t.Errorf("drawing for page %q was not written", pageID)
+		}
+	}
+}
+
+func TestCopyPdf(t *testing.T) {
+	srcDir := setupRepoDir(t)
+	defer os.RemoveAll(srcDir)
+	dstDir := setupRepoDir(t)
+	defer os.RemoveAll(dstDir)
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+	var buf bytes.Buffer
+	err := pdf.Output(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	src := NewRepository(srcDir)
+	dst := NewRepository(dstDir)
+	doc, err := rmtool.NewPdf("Paper", "", func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = src.Upload(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rmtool.Copy(src, doc.ID(), dst, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := dst.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("expected one item, got %d", len(items))
+	}
+	copied, err := ioutil.ReadFile(filepath.Join(dstDir, items[0].ID()+".pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(copied, data) {
+		t.Errorf("attachment was not copied")
+	}
+}
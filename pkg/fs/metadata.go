@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/internal/errors"
+	"github.com/akeil/rmtool/internal/rawjson"
 )
 
 // Timestamp is the datatype for a UNIX timestamp in string format.
@@ -32,7 +34,7 @@ type Metadata struct {
 	// Pinned is the bookmark/start for a notebook.
 	Pinned bool `json:"pinned"`
 	// Type tells whether this is a document or a folder.
-	Type rm.NotebookType `json:"type"`
+	Type rmtool.NotebookType `json:"type"`
 	// VisibleName is the display name for this item.
 	VisibleName string `json:"visibleName"`
 	// Deleted seems to be used internally by the tablet(?).
@@ -43,18 +45,49 @@ type Metadata struct {
 	Modified bool `json:"modified"`
 	// Synced seems to be used internally by the tablet(?).
 	Synced bool `json:"synced"`
+
+	// extra holds fields from the JSON document that are not mapped to
+	// this struct, so that they are preserved when the metadata is written.
+	extra map[string]json.RawMessage
+}
+
+// UnmarshalJSON reads the metadata from JSON and remembers any unknown fields.
+func (m *Metadata) UnmarshalJSON(b []byte) error {
+	// use a type without the custom unmarshaler to avoid recursion
+	type metadata Metadata
+	var x metadata
+	err := json.Unmarshal(b, &x)
+	if err != nil {
+		return err
+	}
+
+	extra, err := rawjson.Unknown(b, x)
+	if err != nil {
+		return err
+	}
+
+	*m = Metadata(x)
+	m.extra = extra
+	return nil
+}
+
+// MarshalJSON writes the metadata to JSON, including any unknown fields
+// that were present when the metadata was read.
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	type metadata Metadata
+	return rawjson.Marshal(metadata(m), m.extra)
 }
 
 func (m *Metadata) Validate() error {
 	switch m.Type {
-	case rm.DocumentType, rm.CollectionType:
+	case rmtool.DocumentType, rmtool.CollectionType:
 		// ok
 	default:
-		return rm.NewValidationError("invalid type %v", m.Type)
+		return errors.NewValidationError("invalid type %v", m.Type)
 	}
 
 	if m.VisibleName == "" {
-		return rm.NewValidationError("visible name must not be emtpty")
+		return errors.NewValidationError("visible name must not be emtpty")
 	}
 
 	return nil
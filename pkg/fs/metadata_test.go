@@ -56,7 +56,7 @@ func TestReadMetadata(t *testing.T) {
 		t.Errorf("unexpected value for lastModified (Nanosecond): %v", m.LastModified.Nanosecond())
 	}
 
-	if m.Type != rm.DocumentType {
+	if m.Type != rmtool.DocumentType {
 		t.Errorf("unexpected value for type")
 	}
 }
@@ -69,7 +69,7 @@ func TestMarshalMetadata(t *testing.T) {
 		LastOpenedPage:   0,
 		Parent:           "parentID",
 		Pinned:           true,
-		Type:             rm.DocumentType,
+		Type:             rmtool.DocumentType,
 		VisibleName:      "Test Notebook",
 		Deleted:          true,
 		MetadataModified: false,
@@ -117,7 +117,7 @@ func TestMarshalMetadata(t *testing.T) {
 
 func TestValidateMetadata(t *testing.T) {
 	m := &Metadata{
-		Type:        rm.DocumentType,
+		Type:        rmtool.DocumentType,
 		VisibleName: "abc",
 	}
 
@@ -126,12 +126,12 @@ func TestValidateMetadata(t *testing.T) {
 		t.Errorf("Unexpected validation error: %v", err)
 	}
 
-	m.Type = rm.NotebookType(100)
+	m.Type = rmtool.NotebookType(100)
 	err = m.Validate()
 	if err == nil {
 		t.Errorf("Invalid type not detected")
 	}
-	m.Type = rm.CollectionType
+	m.Type = rmtool.CollectionType
 
 	m.VisibleName = ""
 	err = m.Validate()
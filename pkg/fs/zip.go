@@ -0,0 +1,186 @@
+package fs
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/internal/errors"
+	"github.com/akeil/rmtool/internal/logging"
+)
+
+// zipRepo is a read-only repository backed by a zip archive
+// with the same file layout as the tablet's storage directory.
+type zipRepo struct {
+	zr *zip.Reader
+}
+
+// NewZipRepository creates a read-only repository from a zip archive,
+// e.g. a document exported through the tablet's USB web interface.
+//
+// The archive should contain the files for one or more items with
+// the same layout as on the tablet, i.e. "<id>.content", "<id>.pagedata"
+// and a directory "<id>/" with the drawings.
+// If an item has no .metadata file, the ID is used as its name.
+//
+// Update and Upload are not supported and return an error.
+func NewZipRepository(r io.ReaderAt, size int64) (rmtool.Repository, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return &zipRepo{zr: zr}, nil
+}
+
+// ReadDocumentFromZip reads the document from a zip archive
+// with the tablet's file layout.
+//
+// Returns an error if the archive does not contain exactly one document.
+// See NewZipRepository for details.
+func ReadDocumentFromZip(r io.ReaderAt, size int64) (*rmtool.Document, error) {
+	repo, err := NewZipRepository(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := repo.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var doc rmtool.Meta
+	for _, m := range items {
+		if m.Type() != rmtool.DocumentType {
+			continue
+		}
+		if doc != nil {
+			return nil, fmt.Errorf("zip archive contains more than one document")
+		}
+		doc = m
+	}
+	if doc == nil {
+		return nil, errors.NewNotFound("no document in zip archive")
+	}
+
+	return rmtool.ReadDocument(repo, doc)
+}
+
+func (r *zipRepo) List() ([]rmtool.Meta, error) {
+	l := make([]rmtool.Meta, 0)
+	for _, zf := range r.zr.File {
+		// items are identified by their top-level .content file
+		if strings.Contains(zf.Name, "/") || path.Ext(zf.Name) != ".content" {
+			continue
+		}
+		id := strings.TrimSuffix(zf.Name, ".content")
+		m, err := r.readItem(id, zf)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, m)
+	}
+
+	return l, nil
+}
+
+// readItem reads the metadata for the item with the given id.
+// If there is no .metadata file, metadata is derived from the content entry.
+func (r *zipRepo) readItem(id string, content *zip.File) (rmtool.Meta, error) {
+	zf := r.find(id + ".metadata")
+	if zf == nil {
+		logging.Debug("No metadata for %q in zip archive", id)
+		meta := Metadata{
+			LastModified: Timestamp{content.Modified},
+			Version:      1,
+			Type:         rmtool.DocumentType,
+			VisibleName:  id,
+		}
+		return metaWrapper{id: id, i: &meta}, nil
+	}
+
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var meta Metadata
+	err = json.NewDecoder(rc).Decode(&meta)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read metadata for %q", id)
+	}
+
+	return metaWrapper{id: id, i: &meta}, nil
+}
+
+func (r *zipRepo) Update(m rmtool.Meta) error {
+	return fmt.Errorf("zip repository is read-only")
+}
+
+func (r *zipRepo) Upload(d *rmtool.Document) error {
+	return fmt.Errorf("zip repository is read-only")
+}
+
+// PagePrefix supports both layouts for page files: the tablet names them
+// by page ID, archives from the cloud API use the page index.
+func (r *zipRepo) PagePrefix(pageID string, pageIndex int) string {
+	for _, zf := range r.zr.File {
+		if path.Base(zf.Name) == pageID+".rm" || path.Base(zf.Name) == pageID+"-metadata.json" {
+			return pageID
+		}
+	}
+	return fmt.Sprintf("%d", pageIndex)
+}
+
+func (r *zipRepo) Reader(id string, version uint, p ...string) (io.ReadCloser, error) {
+	match := strings.Join(p, "/")
+	zf := r.find(match)
+	if zf == nil {
+		return nil, errors.NewNotFound("no zip entry found with name %q", match)
+	}
+	return zf.Open()
+}
+
+func (r *zipRepo) ListFiles(id string, version uint) ([]string, error) {
+	names := make([]string, 0)
+	for _, zf := range r.zr.File {
+		// skip directory entries
+		if strings.HasSuffix(zf.Name, "/") {
+			continue
+		}
+		if strings.HasPrefix(zf.Name, id+".") || strings.HasPrefix(zf.Name, id+"/") {
+			names = append(names, zf.Name)
+		}
+	}
+
+	return names, nil
+}
+
+func (r *zipRepo) Size(id string, version uint) (int64, error) {
+	var size int64
+	found := false
+	for _, zf := range r.zr.File {
+		if strings.HasPrefix(zf.Name, id+".") || strings.HasPrefix(zf.Name, id+"/") {
+			size += int64(zf.UncompressedSize64)
+			found = true
+		}
+	}
+	if !found {
+		return rmtool.UnknownSize, errors.NewNotFound("no zip entries for item %q", id)
+	}
+	return size, nil
+}
+
+// find returns the zip entry with the given name or nil if there is none.
+func (r *zipRepo) find(name string) *zip.File {
+	for _, zf := range r.zr.File {
+		if zf.Name == name {
+			return zf
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package fs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/internal/errors"
+)
+
+// zipDocument creates a zip archive with the files for the given document
+// from a file system repository.
+func zipDocument(t *testing.T, dir string, doc *rmtool.Document, withMetadata bool) []byte {
+	r := NewRepository(dir)
+	names, err := r.ListFiles(doc.ID(), doc.Version())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		if !withMetadata && name == doc.ID()+".metadata" {
+			continue
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadDocumentFromZip(t *testing.T) {
+	dir := setupRepoDir(t)
+	defer os.RemoveAll(dir)
+
+	orig := rmtool.NewNotebook("Exported", "")
+	orig.CreatePage()
+	err := NewRepository(dir).Upload(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, withMetadata := range []bool{true, false} {
+		data := zipDocument(t, dir, orig, withMetadata)
+		doc, err := ReadDocumentFromZip(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if doc.ID() != orig.ID() {
+			t.Errorf("unexpected id %q", doc.ID())
+		}
+		expectedName := "Exported"
+		if !withMetadata {
+			expectedName = orig.ID()
+		}
+		if doc.Name() != expectedName {
+			t.Errorf("unexpected name %q, want %q", doc.Name(), expectedName)
+		}
+		if doc.PageCount() != 2 {
+			t.Errorf("unexpected page count %v", doc.PageCount())
+		}
+		for _, pageID := range doc.Pages() {
+			_, err = doc.Drawing(pageID)
+			if err != nil {
+				t.Errorf("failed to read drawing for page %q: %v", pageID, err)
+			}
+		}
+	}
+
+	_, err = ReadDocumentFromZip(bytes.NewReader([]byte("not a zip")), 9)
+	if err == nil {
+		t.Errorf("expected error for invalid archive")
+	}
+}
+
+func TestZipRepositoryReadOnly(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	zw.Close()
+
+	r, err := NewZipRepository(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Upload(rmtool.NewNotebook("test", "")) == nil {
+		t.Errorf("expected error for upload")
+	}
+
+	_, err = ReadDocumentFromZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if !errors.IsNotFound(err) {
+		t.Errorf("expected not found for empty archive, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package lines
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// DrawingDiff describes the differences between two versions of a drawing.
+type DrawingDiff struct {
+	// Layers holds the differences for each layer, by layer index.
+	Layers []LayerDiff
+}
+
+// LayerDiff lists the strokes which were added to or removed from a layer.
+type LayerDiff struct {
+	Added   []Stroke
+	Removed []Stroke
+}
+
+// Empty tells if there are no differences between the two drawings.
+func (d DrawingDiff) Empty() bool {
+	for _, l := range d.Layers {
+		if len(l.Added) != 0 || len(l.Removed) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff compares two versions of a drawing and reports the strokes that were
+// added or removed in each layer.
+//
+// Strokes are compared by exact match; a stroke that was modified
+// is reported as removed from the old and added to the new version.
+// Layers which exist in only one of the drawings are compared with an empty
+// layer. Either drawing may be nil.
+func Diff(old, updated *Drawing) DrawingDiff {
+	var oldLayers, newLayers []Layer
+	if old != nil {
+		oldLayers = old.Layers
+	}
+	if updated != nil {
+		newLayers = updated.Layers
+	}
+
+	n := len(oldLayers)
+	if len(newLayers) > n {
+		n = len(newLayers)
+	}
+
+	diff := DrawingDiff{Layers: make([]LayerDiff, n)}
+	for i := 0; i < n; i++ {
+		var before, after []Stroke
+		if i < len(oldLayers) {
+			before = oldLayers[i].Strokes
+		}
+		if i < len(newLayers) {
+			after = newLayers[i].Strokes
+		}
+		diff.Layers[i] = diffStrokes(before, after)
+	}
+
+	return diff
+}
+
+func diffStrokes(before, after []Stroke) LayerDiff {
+	// count the strokes from the old version, allowing for duplicates
+	remaining := make(map[string]int)
+	for _, s := range before {
+		remaining[strokeKey(s)]++
+	}
+
+	var ld LayerDiff
+	for _, s := range after {
+		k := strokeKey(s)
+		if remaining[k] > 0 {
+			remaining[k]--
+		} else {
+			ld.Added = append(ld.Added, s)
+		}
+	}
+
+	for _, s := range before {
+		k := strokeKey(s)
+		if remaining[k] > 0 {
+			remaining[k]--
+			ld.Removed = append(ld.Removed, s)
+		}
+	}
+
+	return ld
+}
+
+// strokeKey creates a key which is identical for identical strokes.
+func strokeKey(s Stroke) string {
+	var buf bytes.Buffer
+	// writing to a bytes.Buffer does not fail
+	binary.Write(&buf, binary.LittleEndian, s.BrushType)
+	binary.Write(&buf, binary.LittleEndian, s.BrushColor)
+	binary.Write(&buf, binary.LittleEndian, s.Padding)
+	binary.Write(&buf, binary.LittleEndian, s.BrushSize)
+	binary.Write(&buf, binary.LittleEndian, s.Unknown)
+	binary.Write(&buf, binary.LittleEndian, s.Dots)
+	return buf.String()
+}
@@ -0,0 +1,66 @@
+package lines
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := Stroke{BrushType: Ballpoint, Dots: []Dot{Dot{X: 1, Y: 2}, Dot{X: 3, Y: 4}}}
+	b := Stroke{BrushType: Fineliner, Dots: []Dot{Dot{X: 5, Y: 6}}}
+	c := Stroke{BrushType: Marker, Dots: []Dot{Dot{X: 7, Y: 8}}}
+
+	old := NewDrawing()
+	old.Layers[0].Strokes = []Stroke{a, b}
+
+	updated := NewDrawing()
+	updated.Layers[0].Strokes = []Stroke{a, c}
+	updated.Layers = append(updated.Layers, Layer{Strokes: []Stroke{a}})
+
+	diff := Diff(old, updated)
+	if diff.Empty() {
+		t.Fatal("expected differences")
+	}
+	if len(diff.Layers) != 2 {
+		t.Fatalf("expected diff for 2 layers, got %d", len(diff.Layers))
+	}
+
+	l := diff.Layers[0]
+	if len(l.Added) != 1 || l.Added[0].BrushType != Marker {
+		t.Errorf("unexpected added strokes in layer 0: %v", l.Added)
+	}
+	if len(l.Removed) != 1 || l.Removed[0].BrushType != Fineliner {
+		t.Errorf("unexpected removed strokes in layer 0: %v", l.Removed)
+	}
+
+	l = diff.Layers[1]
+	if len(l.Added) != 1 || len(l.Removed) != 0 {
+		t.Errorf("unexpected diff for new layer: %v", l)
+	}
+
+	// a modified stroke is reported as removed + added
+	moved := Stroke{BrushType: Ballpoint, Dots: []Dot{Dot{X: 1, Y: 2}, Dot{X: 3, Y: 5}}}
+	updated = NewDrawing()
+	updated.Layers[0].Strokes = []Stroke{moved, b}
+	diff = Diff(old, updated)
+	l = diff.Layers[0]
+	if len(l.Added) != 1 || len(l.Removed) != 1 {
+		t.Errorf("expected modified stroke as added and removed, got %v", l)
+	}
+
+	// duplicate strokes are counted
+	updated = NewDrawing()
+	updated.Layers[0].Strokes = []Stroke{a, a, b}
+	diff = Diff(old, updated)
+	if len(diff.Layers[0].Added) != 1 || len(diff.Layers[0].Removed) != 0 {
+		t.Errorf("expected duplicate stroke to be added, got %v", diff.Layers[0])
+	}
+
+	if !Diff(old, old).Empty() {
+		t.Errorf("expected no differences when comparing a drawing with itself")
+	}
+
+	diff = Diff(nil, old)
+	if len(diff.Layers[0].Added) != 2 {
+		t.Errorf("expected all strokes added when comparing against nil")
+	}
+}
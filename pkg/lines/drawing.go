@@ -1,5 +1,10 @@
 package lines
 
+import (
+	"image"
+	"math"
+)
+
 // Header starting a .rm binary file. This can help recognizing a .rm file.
 const (
 	headerV3  = "reMarkable .lines file, version=3          "
@@ -15,13 +20,23 @@ const (
 	V5
 )
 
-// BrushColor defines the color of the brush (black, gray, white).
+// BrushColor defines the color of the brush.
+//
+// Older software versions support only black, gray and white.
+// Newer versions add more colors, mostly for the highlighter.
+// Other values may appear in files from newer software versions.
 type BrushColor uint32
 
 const (
-	Black BrushColor = 0
-	Gray  BrushColor = 1
-	White BrushColor = 2
+	Black       BrushColor = 0
+	Gray        BrushColor = 1
+	White       BrushColor = 2
+	Yellow      BrushColor = 3
+	Green       BrushColor = 4
+	Pink        BrushColor = 5
+	Blue        BrushColor = 6
+	Red         BrushColor = 7
+	GrayOverlap BrushColor = 8
 )
 
 // BrushType is one of the predefined brush types.
@@ -59,6 +74,10 @@ const (
 	Large  BrushSize = 2.125
 )
 
+// maxBrushSize is the largest brush size that is considered valid.
+// Scaled sizes are usually close to the base sizes.
+const maxBrushSize BrushSize = 10
+
 const (
 	// MaxWidth is the display width of the reMArkable tablet.
 	MaxWidth = 1404
@@ -93,6 +112,57 @@ func (d *Drawing) AddLayer(name string) {
 	d.Layers = append(d.Layers, Layer{})
 }
 
+// ClampToBounds moves the coordinates of all dots into the screen area
+// given by MaxWidth and MaxHeight.
+//
+// This can be used for drawings that were transformed or merged, where dots
+// at the very edge end up slightly outside of the valid range.
+// Returns the number of dots that were changed.
+func (d *Drawing) ClampToBounds() int {
+	n := 0
+	for _, l := range d.Layers {
+		for _, s := range l.Strokes {
+			for i := range s.Dots {
+				if s.Dots[i].clamp() {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// Bounds returns the smallest rectangle that contains all strokes
+// from all layers, including the width of the brush.
+//
+// Eraser strokes are not included as they add no visible content.
+// Returns an empty rectangle if the drawing has no strokes.
+func (d *Drawing) Bounds() image.Rectangle {
+	var r image.Rectangle
+	for _, l := range d.Layers {
+		for _, s := range l.Strokes {
+			if s.BrushType == Eraser || s.BrushType == EraseArea {
+				continue
+			}
+			for _, dot := range s.Dots {
+				half := float64(dot.Width) / 2
+				db := image.Rect(
+					int(math.Floor(float64(dot.X)-half)),
+					int(math.Floor(float64(dot.Y)-half)),
+					int(math.Ceil(float64(dot.X)+half)),
+					int(math.Ceil(float64(dot.Y)+half)),
+				)
+				// zero width dots still cover one pixel
+				if db.Empty() {
+					db.Max = db.Min.Add(image.Pt(1, 1))
+				}
+				r = r.Union(db)
+			}
+		}
+	}
+	return r
+}
+
 // Layer is one layer in a drawing.
 type Layer struct {
 	Strokes []Stroke
@@ -102,7 +172,7 @@ type Layer struct {
 type Stroke struct {
 	// BrushType is one of the predefined pencil types, e.g. "Ballpoint" or "PaintBrush"
 	BrushType BrushType
-	// BrushColor is one of the three available colors.
+	// BrushColor is one of the predefined colors.
 	BrushColor BrushColor
 	// Padding - we do not know what this means and it seems to be "0" all the time.
 	Padding uint32
@@ -131,3 +201,22 @@ type Dot struct {
 	// Value range is 0.0 trough 1.0
 	Pressure float32
 }
+
+// clamp moves the dot into the screen area
+// and tells whether the coordinates were changed.
+func (d *Dot) clamp() bool {
+	x, y := clamp(d.X, MaxWidth), clamp(d.Y, MaxHeight)
+	changed := x != d.X || y != d.Y
+	d.X, d.Y = x, y
+	return changed
+}
+
+func clamp(v, max float32) float32 {
+	if v < 0 {
+		return 0
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
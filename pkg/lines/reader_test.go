@@ -3,7 +3,10 @@ package lines
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -121,3 +124,133 @@ func TestWriteRead(t *testing.T) {
 		t.Errorf("dot mismatch afer r/w cycle")
 	}
 }
+
+func TestWriteVersion(t *testing.T) {
+	d := NewDrawing()
+	d.Layers[0].Strokes = []Stroke{
+		Stroke{
+			BrushType:  FinelinerV5,
+			BrushColor: Black,
+			BrushSize:  Medium,
+			Unknown:    1.5,
+			Dots:       []Dot{Dot{X: 100, Y: 200, Width: 2, Pressure: 0.5}},
+		},
+	}
+
+	var buf bytes.Buffer
+	err := WriteDrawingVersion(&buf, d, V3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Version != V5 || d.Layers[0].Strokes[0].BrushType != FinelinerV5 {
+		t.Errorf("WriteDrawingVersion modified the drawing")
+	}
+
+	x, err := ReadDrawing(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Version != V3 {
+		t.Errorf("unexpected version %v", x.Version)
+	}
+	s := x.Layers[0].Strokes[0]
+	if s.BrushType != Fineliner {
+		t.Errorf("brush type not converted, got %v", s.BrushType)
+	}
+	if len(s.Dots) != 1 || s.Dots[0].X != 100 || s.Dots[0].Y != 200 {
+		t.Errorf("unexpected dots after r/w cycle: %v", s.Dots)
+	}
+
+	// v5 only features
+	d.Layers[0].Strokes[0].BrushType = CalligraphyV5
+	err = WriteDrawingVersion(&buf, d, V3)
+	if err == nil {
+		t.Errorf("expected error for v5 only brush type")
+	}
+	d.Layers[0].Strokes[0].BrushType = Fineliner
+	d.Layers[0].Strokes[0].BrushColor = Yellow
+	buf.Reset()
+	err = WriteDrawingVersion(&buf, d, V3)
+	if err == nil {
+		t.Errorf("expected error for color not supported in v3")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("nothing should be written for unsupported drawings")
+	}
+}
+
+// TestGolden reads handcrafted .rm files, writes them back and compares
+// the result byte by byte.
+//
+// All fields are preserved, including Padding and the Unknown stroke
+// attribute (V5 only). Version 3 files have no Unknown attribute, it is
+// always zero after reading.
+func TestGolden(t *testing.T) {
+	cases := []struct {
+		name    string
+		version Version
+		layers  int
+	}{
+		{"golden_v3.rm", V3, 1},
+		{"golden_v5.rm", V5, 3},
+	}
+
+	for _, c := range cases {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", c.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := ReadDrawing(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+		if d.Version != c.version || d.NumLayers() != c.layers {
+			t.Errorf("%v: unexpected version %v or number of layers %v", c.name, d.Version, d.NumLayers())
+		}
+
+		var buf bytes.Buffer
+		err = WriteDrawing(&buf, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("%v: output differs from golden file", c.name)
+		}
+
+		// MarshalBinary and UnmarshalBinary should be equivalent
+		var u Drawing
+		err = u.UnmarshalBinary(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(&u, d) {
+			t.Errorf("%v: UnmarshalBinary differs from ReadDrawing", c.name)
+		}
+		m, err := u.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(m, data) {
+			t.Errorf("%v: MarshalBinary differs from golden file", c.name)
+		}
+	}
+
+	// spot check for fields that are easy to lose
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "golden_v5.rm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d Drawing
+	err = d.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := d.Layers[0].Strokes[0]
+	if s.BrushType != FinelinerV5 || s.Padding != 7 || s.Unknown != 0.25 {
+		t.Errorf("unexpected stroke attributes %v %v %v", s.BrushType, s.Padding, s.Unknown)
+	}
+	if len(d.Layers[1].Strokes) != 0 {
+		t.Errorf("expected an empty layer")
+	}
+}
@@ -3,10 +3,30 @@ package lines
 import (
 	"fmt"
 	"math"
+	"sync"
 
 	"github.com/akeil/rmtool/internal/errors"
+	"github.com/akeil/rmtool/internal/logging"
 )
 
+var (
+	// unknownColors records unknown brush colors that have been reported.
+	unknownColors   = make(map[BrushColor]bool)
+	unknownColorsMx sync.Mutex
+)
+
+// warnUnknownColor logs a warning for an unknown brush color,
+// once for each color value.
+func warnUnknownColor(bc BrushColor) {
+	unknownColorsMx.Lock()
+	defer unknownColorsMx.Unlock()
+	if unknownColors[bc] {
+		return
+	}
+	unknownColors[bc] = true
+	logging.Warning("Unknown brush color %v", bc)
+}
+
 // Validate checks this drawing and all layers, strokes and dots for valid data.
 // Returns an error if invalid data is found, nil if everything is fine.
 func (d *Drawing) Validate() error {
@@ -53,17 +73,17 @@ func (s *Stroke) Validate() error {
 		return err
 	}
 
+	// Unknown colors are accepted, they may come from a newer software
+	// version. The renderer uses a default color for them.
 	switch s.BrushColor {
-	case Black, Gray, White:
+	case Black, Gray, White, Yellow, Green, Pink, Blue, Red, GrayOverlap:
 		// valid
 	default:
-		return fmt.Errorf("invalid color: %v", s.BrushColor)
+		warnUnknownColor(s.BrushColor)
 	}
 
-	switch s.BrushSize {
-	case Small, Medium, Large:
-		// valid
-	default:
+	// Sizes other than Small, Medium and Large are possible through scaling
+	if s.BrushSize <= 0 || s.BrushSize > maxBrushSize {
 		return fmt.Errorf("invalid brush size: %v", s.BrushSize)
 	}
 
@@ -97,17 +117,10 @@ func (d *Dot) Validate() error {
 		return fmt.Errorf("invalid speed value: %v", d.Speed)
 	}
 
-	// TODO: Encountered tilt values outside of the intervals
-	// So thie below validations rules seem to be wrong?
-
-	// 0..90 degrees
-	max0 := rad(90)
-	interval0 := d.Tilt >= 0 && d.Tilt <= max0
-	// 270..360 degrees
-	min1 := rad(270)
-	max1 := rad(360)
-	interval1 := d.Tilt >= min1 && d.Tilt <= max1
-	if !(interval0 || interval1) {
+	// Tilt values are mostly within 0..90 or 270..360 degrees,
+	// but real drawings contain values in between.
+	// So we only check for a full circle.
+	if d.Tilt < 0 || d.Tilt > rad(360) {
 		return fmt.Errorf("invalid tilt value: %v", d.Tilt)
 	}
 
@@ -1,6 +1,8 @@
 package lines
 
 import (
+	"bytes"
+	"image"
 	"testing"
 )
 
@@ -56,26 +58,37 @@ func TestValidateStroke(t *testing.T) {
 		t.Errorf("valid brush type %v was not accepted: %v", s.BrushType, err)
 	}
 
+	// unknown colors may come from newer versions
 	s.BrushColor = BrushColor(100)
 	err = s.Validate()
-	if err == nil {
-		t.Errorf("failed to detect invalid brush color %v", s.BrushColor)
-	}
-	s.BrushColor = Gray
-	err = s.Validate()
 	if err != nil {
-		t.Errorf("valid brush color %v was not accepted: %v", s.BrushColor, err)
+		t.Errorf("unknown brush color %v was not accepted: %v", s.BrushColor, err)
+	}
+	if !unknownColors[BrushColor(100)] {
+		t.Errorf("unknown brush color %v was not recorded", s.BrushColor)
+	}
+	for _, c := range []BrushColor{Gray, Yellow, Blue, Red} {
+		s.BrushColor = c
+		err = s.Validate()
+		if err != nil {
+			t.Errorf("valid brush color %v was not accepted: %v", s.BrushColor, err)
+		}
 	}
 
-	s.BrushSize = BrushSize(0.1)
-	err = s.Validate()
-	if err == nil {
-		t.Errorf("failed to detect invalid brush size %v", s.BrushSize)
+	for _, size := range []BrushSize{0, -1, 100} {
+		s.BrushSize = size
+		err = s.Validate()
+		if err == nil {
+			t.Errorf("failed to detect invalid brush size %v", s.BrushSize)
+		}
 	}
-	s.BrushSize = Large
-	err = s.Validate()
-	if err != nil {
-		t.Errorf("valid brush size %v was not accepted: %v", s.BrushSize, err)
+	// scaled sizes are valid
+	for _, size := range []BrushSize{Large, 0.1, 2.5, Medium * 1.5} {
+		s.BrushSize = size
+		err = s.Validate()
+		if err != nil {
+			t.Errorf("valid brush size %v was not accepted: %v", s.BrushSize, err)
+		}
 	}
 
 }
@@ -99,15 +112,19 @@ func TestValidateDot(t *testing.T) {
 		t.Errorf("valid speed value %v was not accepted: %v", d.Speed, err)
 	}
 
-	d.Tilt = 3.0
-	err = d.Validate()
-	if err == nil {
-		t.Errorf("failed to detect invalid tilt value %v", d.Tilt)
+	for _, tilt := range []float32{-0.1, 7.0} {
+		d.Tilt = tilt
+		err = d.Validate()
+		if err == nil {
+			t.Errorf("failed to detect invalid tilt value %v", d.Tilt)
+		}
 	}
-	d.Tilt = rad(45)
-	err = d.Validate()
-	if err != nil {
-		t.Errorf("valid tilt value %v was not accepted: %v", d.Tilt, err)
+	for _, tilt := range []float32{rad(45), 3.0, rad(300)} {
+		d.Tilt = tilt
+		err = d.Validate()
+		if err != nil {
+			t.Errorf("valid tilt value %v was not accepted: %v", d.Tilt, err)
+		}
 	}
 
 	d.Width = -1
@@ -137,3 +154,92 @@ func TestValidateDot(t *testing.T) {
 		t.Errorf("valid pressure value %v was not accepted: %v", d.Pressure, err)
 	}
 }
+
+func TestClampToBounds(t *testing.T) {
+	d := NewDrawing()
+	d.Layers[0].Strokes = []Stroke{
+		Stroke{
+			BrushType: BallpointV5,
+			BrushSize: Medium,
+			Dots: []Dot{
+				Dot{X: -0.5, Y: 100},
+				Dot{X: 700, Y: 936},
+				Dot{X: MaxWidth + 0.8, Y: MaxHeight + 1},
+			},
+		},
+	}
+	if d.Validate() == nil {
+		t.Fatalf("expected out of range coordinates to be invalid")
+	}
+
+	// the writer can clamp without changing the drawing
+	var buf bytes.Buffer
+	err := WriteDrawingClamped(&buf, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Layers[0].Strokes[0].Dots[0].X != -0.5 {
+		t.Errorf("WriteDrawingClamped modified the drawing")
+	}
+	x, err := ReadDrawing(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = x.Validate()
+	if err != nil {
+		t.Errorf("clamped drawing is not valid: %v", err)
+	}
+
+	n := d.ClampToBounds()
+	if n != 2 {
+		t.Errorf("unexpected number of clamped dots %v", n)
+	}
+	err = d.Validate()
+	if err != nil {
+		t.Errorf("clamped drawing is not valid: %v", err)
+	}
+	dots := d.Layers[0].Strokes[0].Dots
+	if dots[0].X != 0 || dots[0].Y != 100 {
+		t.Errorf("unexpected dot after clamp: %v", dots[0])
+	}
+	if dots[1].X != 700 || dots[1].Y != 936 {
+		t.Errorf("dot within bounds was changed: %v", dots[1])
+	}
+	if dots[2].X != MaxWidth || dots[2].Y != MaxHeight {
+		t.Errorf("unexpected dot after clamp: %v", dots[2])
+	}
+}
+
+func TestBounds(t *testing.T) {
+	d := NewDrawing()
+	if !d.Bounds().Empty() {
+		t.Errorf("expected empty bounds for empty drawing, got %v", d.Bounds())
+	}
+
+	d.Layers[0].Strokes = []Stroke{
+		Stroke{
+			BrushType: BallpointV5,
+			Dots: []Dot{
+				Dot{X: 100, Y: 200, Width: 4},
+				Dot{X: 300.5, Y: 250, Width: 4},
+			},
+		},
+	}
+	d.AddLayer("second")
+	d.Layers[1].Strokes = []Stroke{
+		Stroke{
+			BrushType: FinelinerV5,
+			Dots:      []Dot{Dot{X: 150, Y: 400, Width: 2}},
+		},
+		// erasers are not part of the visible content
+		Stroke{
+			BrushType: Eraser,
+			Dots:      []Dot{Dot{X: 900, Y: 900, Width: 20}},
+		},
+	}
+
+	want := image.Rect(98, 198, 303, 401)
+	if d.Bounds() != want {
+		t.Errorf("unexpected bounds %v, want %v", d.Bounds(), want)
+	}
+}
@@ -10,7 +10,7 @@ import (
 // MarshalBinary returns the byte representation of the drawing.
 func (d *Drawing) MarshalBinary() ([]byte, error) {
 	buf := &bytes.Buffer{}
-	err := write(io.Writer(buf), d)
+	err := write(io.Writer(buf), d, d.Version, false)
 	if err != nil {
 		return nil, err
 	}
@@ -20,12 +20,41 @@ func (d *Drawing) MarshalBinary() ([]byte, error) {
 
 // WriteDrawing writes the given drawing to the given writer.
 func WriteDrawing(w io.Writer, d *Drawing) error {
-	return write(w, d)
+	return write(w, d, d.Version, false)
 }
 
-// Write writes the given drawing to the given writer
-func write(w io.Writer, d *Drawing) error {
-	err := writeHeader(w, d)
+// WriteDrawingVersion writes the given drawing to the given writer
+// in the format for the given version, regardless of the drawing's Version.
+//
+// When writing V3, brush types from V5 are converted to their V3 equivalents.
+// An error is returned if the drawing uses features which are not available
+// in V3, e.g. the calligraphy pen or colors other than black, gray and white.
+//
+// The drawing itself is not modified.
+func WriteDrawingVersion(w io.Writer, d *Drawing, v Version) error {
+	return write(w, d, v, false)
+}
+
+// WriteDrawingClamped writes the given drawing to the given writer
+// like WriteDrawing, but clamps all coordinates to the screen bounds.
+//
+// The drawing itself is not modified, see Drawing.ClampToBounds.
+func WriteDrawingClamped(w io.Writer, d *Drawing) error {
+	return write(w, d, d.Version, true)
+}
+
+// Write writes the given drawing to the given writer in the format
+// for the given version, optionally clamping coordinates.
+func write(w io.Writer, d *Drawing, v Version, clamp bool) error {
+	// check before anything is written
+	if v == V3 {
+		err := checkV3(d)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := writeHeader(w, v)
 	if err != nil {
 		return err
 	}
@@ -38,7 +67,7 @@ func write(w io.Writer, d *Drawing) error {
 	}
 
 	for _, l := range d.Layers {
-		err = writeLayer(w, l)
+		err = writeLayer(w, l, v, clamp)
 		if err != nil {
 			return err
 		}
@@ -47,22 +76,61 @@ func write(w io.Writer, d *Drawing) error {
 	return nil
 }
 
-func writeHeader(w io.Writer, d *Drawing) error {
+func writeHeader(w io.Writer, v Version) error {
 	var h string
-	switch d.Version {
+	switch v {
 	case V3:
 		h = headerV3
 	case V5:
 		h = headerV5
 	default:
-		return fmt.Errorf("invalid version %v", d.Version)
+		return fmt.Errorf("invalid version %v", v)
 	}
 
 	_, err := w.Write([]byte(h))
 	return err
 }
 
-func writeLayer(w io.Writer, l Layer) error {
+// v3BrushTypes maps brush types from V5 to their V3 equivalents.
+var v3BrushTypes = map[BrushType]BrushType{
+	PaintBrushV5:       PaintBrush,
+	MechanicalPencilV5: MechanicalPencil,
+	PencilV5:           Pencil,
+	BallpointV5:        Ballpoint,
+	MarkerV5:           Marker,
+	FinelinerV5:        Fineliner,
+	HighlighterV5:      Highlighter,
+}
+
+// checkV3 returns an error if the drawing cannot be written as V3.
+func checkV3(d *Drawing) error {
+	for _, l := range d.Layers {
+		for _, s := range l.Strokes {
+			_, err := toV3(s.BrushType)
+			if err != nil {
+				return err
+			}
+			if s.BrushColor > White {
+				return fmt.Errorf("brush color %v is not supported in version 3", s.BrushColor)
+			}
+		}
+	}
+	return nil
+}
+
+// toV3 converts a brush type to the equivalent V3 brush type.
+func toV3(b BrushType) (BrushType, error) {
+	if b <= EraseArea {
+		return b, nil
+	}
+	v3, ok := v3BrushTypes[b]
+	if !ok {
+		return b, fmt.Errorf("brush type %v is not supported in version 3", b)
+	}
+	return v3, nil
+}
+
+func writeLayer(w io.Writer, l Layer, v Version, clamp bool) error {
 	numStrokes := uint32(len(l.Strokes))
 	err := binary.Write(w, endianess, numStrokes)
 	if err != nil {
@@ -70,7 +138,7 @@ func writeLayer(w io.Writer, l Layer) error {
 	}
 
 	for _, s := range l.Strokes {
-		err = writeStroke(w, s)
+		err = writeStroke(w, s, v, clamp)
 		if err != nil {
 			return err
 		}
@@ -78,8 +146,17 @@ func writeLayer(w io.Writer, l Layer) error {
 	return nil
 }
 
-func writeStroke(w io.Writer, s Stroke) error {
-	err := binary.Write(w, endianess, s.BrushType)
+func writeStroke(w io.Writer, s Stroke, v Version, clamp bool) error {
+	bt := s.BrushType
+	if v == V3 {
+		var err error
+		bt, err = toV3(bt)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := binary.Write(w, endianess, bt)
 	if err != nil {
 		return err
 	}
@@ -99,9 +176,12 @@ func writeStroke(w io.Writer, s Stroke) error {
 		return err
 	}
 
-	err = binary.Write(w, endianess, s.Unknown)
-	if err != nil {
-		return err
+	// additional attribute in v5 only
+	if v == V5 {
+		err = binary.Write(w, endianess, s.Unknown)
+		if err != nil {
+			return err
+		}
 	}
 
 	numDots := uint32(len(s.Dots))
@@ -111,6 +191,9 @@ func writeStroke(w io.Writer, s Stroke) error {
 	}
 
 	for _, d := range s.Dots {
+		if clamp {
+			d.clamp()
+		}
 		err = writeDot(w, d)
 		if err != nil {
 			return err
@@ -1,14 +1,21 @@
 package render
 
 import (
+	"fmt"
 	"image"
+	"image/color"
 	"image/draw"
 	"image/png"
 	"io"
 	"math"
 
+	"github.com/llgcode/draw2d/draw2dimg"
+	xdraw "golang.org/x/image/draw"
+
 	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/internal/errors"
 	"github.com/akeil/rmtool/internal/imaging"
+	"github.com/akeil/rmtool/internal/logging"
 	"github.com/akeil/rmtool/pkg/lines"
 )
 
@@ -16,56 +23,133 @@ import (
 // result to the given writer.
 //
 // Unlike RenderDrawing, this includes the page's background template.
+//
+// This is a shortcut for Context.Page with the DefaultContext.
 func Page(doc *rmtool.Document, pageID string, w io.Writer) error {
 	c := DefaultContext()
 	return renderPage(c, doc, pageID, w)
 }
 
 func renderPage(c *Context, doc *rmtool.Document, pageID string, w io.Writer) error {
-	pg, err := doc.Page(pageID)
+	return renderPageLayer(c, doc, pageID, allLayers, w)
+}
+
+// allLayers is passed to renderPageLayer to paint every layer of a page.
+const allLayers = -1
+
+// renderPageLayer paints the background, template and the layer with the
+// given index for a single page and writes the resulting image.
+//
+// If the index is allLayers, all layers are painted.
+// If the Context is set to Transparent, background and template are skipped.
+func renderPageLayer(c *Context, doc *rmtool.Document, pageID string, layer int, w io.Writer) error {
+	dst, _, err := paintPageLayer(c, doc, pageID, layer)
 	if err != nil {
 		return err
 	}
 
-	rect := image.Rect(0, 0, lines.MaxWidth, lines.MaxHeight)
-	dst := image.NewRGBA(rect)
+	return c.encode(w, dst)
+}
 
-	if pg.HasTemplate() {
-		err = renderTemplate(c, dst, pg.Template(), doc.Orientation())
-		if err != nil {
-			return err
-		}
+// renderPageCropped paints a page and writes the region with the drawing,
+// plus the given margin. The full page is written if the drawing is empty
+// or lies outside the page.
+func renderPageCropped(c *Context, doc *rmtool.Document, pageID string, margin int, w io.Writer) error {
+	dst, d, err := paintPageLayer(c, doc, pageID, allLayers)
+	if err != nil {
+		return err
+	}
+
+	b := d.Bounds()
+	if !b.Empty() {
+		b = b.Inset(-margin).Intersect(dst.Bounds())
+	}
+	// empty drawings or drawings entirely outside the page
+	if b.Empty() {
+		return c.encode(w, dst)
+	}
+
+	// move the cropped region to the origin,
+	// background and template are cropped along with the drawing
+	cropped := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), dst, b.Min, draw.Src)
+
+	return c.encode(w, cropped)
+}
+
+// paintPageLayer paints a single page, see renderPageLayer.
+// Returns the image and the (transformed) drawing.
+func paintPageLayer(c *Context, doc *rmtool.Document, pageID string, layer int) (*image.RGBA, *lines.Drawing, error) {
+	pg, err := doc.Page(pageID)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	d, err := doc.Drawing(pageID)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	d = applyTransform(doc, d)
+
+	if layer != allLayers {
+		if layer >= d.NumLayers() {
+			return nil, nil, fmt.Errorf("invalid layer index %d for page with %d layers", layer, d.NumLayers())
+		}
+		d = &lines.Drawing{
+			Version: d.Version,
+			Layers:  []lines.Layer{d.Layers[layer]},
+		}
+	}
+
+	rect := image.Rect(0, 0, lines.MaxWidth, lines.MaxHeight)
+	dst := image.NewRGBA(rect)
+
+	if !c.Transparent {
+		renderBackground(c, dst)
+		if pg.HasTemplate() {
+			err = renderTemplate(c, dst, pg.Template(), doc.EffectiveOrientation(pageID))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
 	}
 
 	err = renderLayers(c, dst, d)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	return png.Encode(w, dst)
+	return dst, d, nil
 }
 
-// RenderPNG paints the given drawing to a PNG file and writes the PNG data
+// renderPNG paints the given drawing to a PNG file and writes the PNG data
 // to the given writer.
+//
+// This always produces a PNG, regardless of the ImageFormat.
 func renderPNG(c *Context, d *lines.Drawing, paintBg bool, w io.Writer) error {
+	img, err := renderDrawing(c, d, paintBg)
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(w, c.applyColorMode(img))
+}
+
+// renderDrawing paints the given drawing on a new image.
+func renderDrawing(c *Context, d *lines.Drawing, paintBg bool) (image.Image, error) {
 	rect := image.Rect(0, 0, lines.MaxWidth, lines.MaxHeight)
 	dst := image.NewRGBA(rect)
 
-	if paintBg {
+	if paintBg && !c.Transparent {
 		renderBackground(c, dst)
 	}
 
 	err := renderLayers(c, dst, d)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return png.Encode(w, dst)
+	return dst, nil
 }
 
 // renderTemplate paints the named background template on the given destination
@@ -73,10 +157,15 @@ func renderPNG(c *Context, d *lines.Drawing, paintBg bool, w io.Writer) error {
 //
 // The background image is loaded from the given Context.
 //
-// An error is returned ff the template cannot be loaded.
+// An error is returned if the template cannot be loaded.
+// If the Context has LenientTemplates enabled, a missing template
+// is skipped with a warning.
 func renderTemplate(c *Context, dst draw.Image, tpl string, layout rmtool.Orientation) error {
 	img, err := c.loadTemplate(tpl)
-	if err != nil {
+	if errors.IsNotFound(err) && c.LenientTemplates {
+		logging.Warning("Template %q not found, render as blank page", tpl)
+		return nil
+	} else if err != nil {
 		return err
 	}
 
@@ -95,28 +184,253 @@ func renderBackground(c *Context, dst draw.Image) {
 	draw.Draw(dst, dst.Bounds(), bg, image.Point{}, draw.Over)
 }
 
-// renderLayoers paints all layers on the destination image.
+// renderLayers paints all layers on the destination image.
+//
+// If the Context has DebugLayers enabled, this uses renderTinted.
 func renderLayers(c *Context, dst draw.Image, d *lines.Drawing) error {
+	if c.DebugLayers {
+		return renderTinted(c, dst, d)
+	}
+	return paintLayers(c, dst, d)
+}
+
+// paintLayers paints all layers on the destination image.
+//
+// If the Context has Antialias enabled, this uses renderSupersampled.
+func paintLayers(c *Context, dst draw.Image, d *lines.Drawing) error {
+	if c.Antialias {
+		return renderSupersampled(c, dst, d)
+	}
+	return renderStrokes(c, dst, d, 1)
+}
+
+// layerTints are the colors used to mark layers with DebugLayers.
+// If there are more layers than colors, the colors are repeated.
+var layerTints = []color.Color{
+	color.RGBA{230, 25, 75, 255},
+	color.RGBA{60, 180, 75, 255},
+	color.RGBA{0, 130, 200, 255},
+	color.RGBA{245, 130, 48, 255},
+	color.RGBA{145, 30, 180, 255},
+	color.RGBA{70, 240, 240, 255},
+}
+
+// renderTinted paints each layer on a separate image and uses that
+// as a mask to fill the destination with the color for that layer.
+//
+// Brush shapes are kept, but the stroke colors are replaced.
+func renderTinted(c *Context, dst draw.Image, d *lines.Drawing) error {
+	b := dst.Bounds()
+	for i, l := range d.Layers {
+		single := &lines.Drawing{
+			Version: d.Version,
+			Layers:  []lines.Layer{l},
+		}
+		tmp := image.NewRGBA(b)
+		err := paintLayers(c, tmp, single)
+		if err != nil {
+			return err
+		}
+
+		tint := image.NewUniform(layerTints[i%len(layerTints)])
+		draw.DrawMask(dst, b, tint, image.Point{}, tmp, b.Min, draw.Over)
+	}
+
+	return nil
+}
+
+// renderSupersampled paints all layers onto an enlarged temporary image
+// and scales the result down onto the destination image.
+func renderSupersampled(c *Context, dst draw.Image, d *lines.Drawing) error {
+	b := dst.Bounds()
+	large := image.NewRGBA(image.Rect(0, 0, b.Dx()*supersampling, b.Dy()*supersampling))
+
+	err := renderStrokes(c, large, d, supersampling)
+	if err != nil {
+		return err
+	}
+
+	xdraw.CatmullRom.Scale(dst, b, large, large.Bounds(), draw.Over, nil)
+	return nil
+}
+
+// renderStrokes paints all strokes from all layers on the destination image.
+// Coordinates and widths are multiplied with the given scale factor.
+func renderStrokes(c *Context, dst draw.Image, d *lines.Drawing, scale float32) error {
 	for _, l := range d.Layers {
-		for _, s := range l.Strokes {
-			// The erased content is deleted,
-			// but eraser strokes are recorded.
-			if s.BrushType == lines.Eraser || s.BrushType == lines.EraseArea {
-				continue
-			}
+		var err error
+		if hasEraseArea(l) {
+			err = renderErasedLayer(c, dst, l, scale)
+		} else {
+			err = renderLayer(c, dst, l, scale)
+		}
+		if err != nil {
+			return err
+		}
+	}
 
-			brush, err := c.loadBrush(s.BrushType, s.BrushColor)
-			if err != nil {
-				return err
-			}
+	return nil
+}
+
+// renderLayer paints the strokes from a single layer on the destination
+// image, in the order in which they were recorded.
+//
+// EraseArea strokes clear the area they enclose, which removes
+// everything painted on the destination before. Use renderErasedLayer
+// to limit that to the strokes of the layer.
+func renderLayer(c *Context, dst draw.Image, l lines.Layer, scale float32) error {
+	for _, s := range l.Strokes {
+		// The erased content is deleted,
+		// but eraser strokes are recorded.
+		if s.BrushType == lines.Eraser {
+			continue
+		}
+
+		if scale != 1 {
+			s = scaleStroke(s, scale)
+		}
 
-			brush.RenderStroke(dst, s)
+		if s.BrushType == lines.EraseArea {
+			eraseArea(dst, s)
+			continue
 		}
+
+		brush, err := c.loadBrush(s.BrushType, s.BrushColor)
+		if err != nil {
+			return err
+		}
+		brush.RenderStroke(dst, s)
 	}
 
 	return nil
 }
 
+// renderErasedLayer paints a layer with EraseArea strokes on a separate,
+// transparent image and composes the result onto the destination image.
+//
+// This way, erased areas do not affect the background, the template
+// or other layers.
+func renderErasedLayer(c *Context, dst draw.Image, l lines.Layer, scale float32) error {
+	b := dst.Bounds()
+	tmp := image.NewRGBA(b)
+	err := renderLayer(c, tmp, l, scale)
+	if err != nil {
+		return err
+	}
+
+	draw.Draw(dst, b, tmp, b.Min, draw.Over)
+	return nil
+}
+
+// hasEraseArea tells if the given layer contains an EraseArea stroke.
+func hasEraseArea(l lines.Layer) bool {
+	for _, s := range l.Strokes {
+		if s.BrushType == lines.EraseArea {
+			return true
+		}
+	}
+	return false
+}
+
+// eraseArea makes the area enclosed by the dots of the given
+// EraseArea stroke transparent.
+func eraseArea(dst draw.Image, s lines.Stroke) {
+	if len(s.Dots) < 3 {
+		return
+	}
+
+	b := dst.Bounds()
+	area := image.Rectangle{}
+	for _, d := range s.Dots {
+		p := image.Pt(int(d.X), int(d.Y))
+		area = area.Union(image.Rectangle{p, p.Add(image.Pt(1, 1))})
+	}
+	area = area.Intersect(b)
+	if area.Empty() {
+		return
+	}
+
+	// The mask covers only the erased area, its origin is at area.Min.
+	mask := image.NewRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	ox := float64(area.Min.X)
+	oy := float64(area.Min.Y)
+	gc := draw2dimg.NewGraphicContext(mask)
+	gc.SetFillColor(color.Black)
+	gc.BeginPath()
+	gc.MoveTo(float64(s.Dots[0].X)-ox, float64(s.Dots[0].Y)-oy)
+	for _, d := range s.Dots[1:] {
+		gc.LineTo(float64(d.X)-ox, float64(d.Y)-oy)
+	}
+	gc.Close()
+	gc.Fill()
+
+	// With a mask, draw.Src also clears everything outside the mask,
+	// so we keep what is outside the erased area instead.
+	keep := image.NewAlpha(area)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			a := mask.RGBAAt(x-area.Min.X, y-area.Min.Y).A
+			keep.SetAlpha(x, y, color.Alpha{255 - a})
+		}
+	}
+	kept := image.NewRGBA(area)
+	draw.DrawMask(kept, area, dst, area.Min, keep, area.Min, draw.Src)
+	draw.Draw(dst, area, kept, area.Min, draw.Src)
+}
+
+// applyTransform returns a copy of the given drawing with the document's
+// Transform applied to positions and widths.
+//
+// The drawing itself is returned for the identity transform.
+func applyTransform(doc *rmtool.Document, d *lines.Drawing) *lines.Drawing {
+	t := doc.Transform()
+	if t.IsIdentity() {
+		return d
+	}
+
+	scale := float32(t.Scale())
+	layers := make([]lines.Layer, len(d.Layers))
+	for i, l := range d.Layers {
+		strokes := make([]lines.Stroke, len(l.Strokes))
+		for j, s := range l.Strokes {
+			dots := make([]lines.Dot, len(s.Dots))
+			for k, dot := range s.Dots {
+				x, y := t.Map(float64(dot.X), float64(dot.Y))
+				dot.X = float32(x)
+				dot.Y = float32(y)
+				dot.Width *= scale
+				dots[k] = dot
+			}
+			s.Dots = dots
+			strokes[j] = s
+		}
+		l.Strokes = strokes
+		layers[i] = l
+	}
+
+	return &lines.Drawing{
+		Version: d.Version,
+		Layers:  layers,
+	}
+}
+
+// scaleStroke returns a copy of the given stroke with positions and widths
+// multiplied by the given factor.
+func scaleStroke(s lines.Stroke, f float32) lines.Stroke {
+	dots := make([]lines.Dot, len(s.Dots))
+	for i, d := range s.Dots {
+		d.X *= f
+		d.Y *= f
+		d.Width *= f
+		dots[i] = d
+	}
+	s.Dots = dots
+	return s
+}
+
+// supersampling is the scale factor used for anti-aliased rendering.
+const supersampling = 2
+
 func rad(deg float64) float64 {
 	return deg * (math.Pi / 180)
 }
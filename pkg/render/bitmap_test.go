@@ -0,0 +1,551 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/internal/sprites"
+	"github.com/akeil/rmtool/pkg/lines"
+)
+
+// testContext creates a rendering context with a generated spritesheet,
+// so that rendering does not depend on files from the data directory.
+func testContext() *Context {
+	sprites := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			sprites.Set(x, y, color.White)
+		}
+	}
+	index := make(map[string][]int)
+	for _, name := range brushNames {
+		index[name] = []int{0, 0, 64, 64}
+	}
+	p := NewPalette(color.White, color.RGBA{150, 150, 150, 255}, defaultColors)
+	return NewContextWithAssets(sprites, index, nil, p)
+}
+
+// testDrawing creates a drawing with a diagonal stroke for each brush type.
+func testDrawing() *lines.Drawing {
+	d := lines.NewDrawing()
+	i := 0
+	for bt := range brushNames {
+		offset := float32(i * 50)
+		s := lines.Stroke{BrushType: bt, BrushColor: lines.Black}
+		for j := 0; j < 20; j++ {
+			s.Dots = append(s.Dots, lines.Dot{
+				X:        offset + float32(j*10),
+				Y:        offset + float32(j*10),
+				Width:    3,
+				Pressure: 0.5,
+			})
+		}
+		d.Layers[0].Strokes = append(d.Layers[0].Strokes, s)
+		i++
+	}
+	return d
+}
+
+func TestRenderWithAssets(t *testing.T) {
+	c := testContext()
+
+	var buf bytes.Buffer
+	err := renderPNG(c, testDrawing(), true, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != lines.MaxWidth || img.Bounds().Dy() != lines.MaxHeight {
+		t.Errorf("unexpected image size %v", img.Bounds())
+	}
+
+	_, err = c.loadTemplate("P Lines medium")
+	if err == nil {
+		t.Errorf("expected error for missing template")
+	}
+}
+
+func BenchmarkRenderPNG(b *testing.B) {
+	c := testContext()
+	d := testDrawing()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := renderPNG(c, d, true, ioutil.Discard)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestLoadBrushMaskBounds(t *testing.T) {
+	c := testContext()
+
+	_, err := c.loadBrushMask("fineliner")
+	if err != nil {
+		t.Errorf("unexpected error for valid sprite: %v", err)
+	}
+
+	invalid := [][]int{
+		{32, 32, 96, 96},     // extends beyond the sheet
+		{100, 100, 110, 110}, // completely outside
+		{-5, 0, 10, 10},      // negative offset
+		{10, 10, 10, 20},     // empty
+	}
+	for _, idx := range invalid {
+		c.spriteIndex["fineliner"], _ = sprites.FromRect(idx)
+		_, err = c.loadBrushMask("fineliner")
+		if err == nil {
+			t.Errorf("expected error for sprite index %v", idx)
+		}
+	}
+}
+
+func TestLoadSpritesheet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rmtool-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := os.Create(filepath.Join(dir, "sprites.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, image.NewRGBA(image.Rect(0, 0, 48, 16)))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// older index files have [x0, y0, x1, y1] entries
+	index := `{"fineliner": [0, 0, 16, 16], "marker": {"x": 16, "y": 0, "width": 32, "height": 8}}`
+	err = ioutil.WriteFile(filepath.Join(dir, "sprites.json"), []byte(index), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewContext(dir, NewPalette(color.White, color.White, defaultColors))
+	expected := map[string]image.Rectangle{
+		"fineliner": image.Rect(0, 0, 16, 16),
+		"marker":    image.Rect(16, 0, 48, 8),
+	}
+	for name, want := range expected {
+		mask, err := c.loadBrushMask(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mask.Bounds() != want {
+			t.Errorf("unexpected bounds for %v: %v, want %v", name, mask.Bounds(), want)
+		}
+	}
+}
+
+func TestPrepareMaskLength(t *testing.T) {
+	// a wide mask, e.g. for a calligraphy nib
+	mask := image.NewRGBA(image.Rect(0, 0, 32, 8))
+
+	start := lines.Dot{X: 10, Y: 10}
+	for _, end := range []lines.Dot{{X: 90, Y: 10}, {X: 90, Y: 90}, {X: 10, Y: 90}} {
+		_, length := prepareMask(mask, 4, 1.0, start, end)
+		if length != 16 {
+			t.Errorf("unexpected stamp length %v for segment to %v,%v", length, end.X, end.Y)
+		}
+	}
+
+	// zero width strokes are skipped
+	dst := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	m, length := prepareMask(mask, 0, 1.0, start, lines.Dot{X: 90, Y: 10})
+	drawStamp(dst, m, length, image.NewUniform(color.Black), start, lines.Dot{X: 90, Y: 10}, 2.0)
+}
+
+func TestPaletteColor(t *testing.T) {
+	navy := color.RGBA{0, 0, 128, 255}
+	p := NewPalette(color.White, color.White, map[lines.BrushColor]color.Color{
+		lines.Black: navy,
+	})
+
+	if p.Color(lines.Black) != navy {
+		t.Errorf("palette color not used")
+	}
+	if p.Color(lines.Red) != defaultColors[lines.Red] {
+		t.Errorf("expected default color for red")
+	}
+	if p.Color(lines.BrushColor(100)) != navy {
+		t.Errorf("expected black from palette for unknown color")
+	}
+	p.Color(lines.BrushColor(100))
+	if len(p.unknown) != 1 || !p.unknown[lines.BrushColor(100)] {
+		t.Errorf("unexpected record of unknown colors: %v", p.unknown)
+	}
+}
+
+func TestHasTemplate(t *testing.T) {
+	c := testContext()
+	if c.HasTemplate("P Lines medium") {
+		t.Errorf("unexpected template in context without templates")
+	}
+
+	tpl := map[string]image.Image{"P Lines medium": image.NewRGBA(image.Rect(0, 0, 1, 1))}
+	c = NewContextWithAssets(c.sprites, nil, tpl, c.palette)
+	if !c.HasTemplate("P Lines medium") {
+		t.Errorf("expected embedded template")
+	}
+
+	dir, err := ioutil.TempDir("", "rmtool-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.Mkdir(filepath.Join(dir, "templates"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "templates", "P Dots top.png"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = NewContext(dir, c.palette)
+	if !c.HasTemplate("P Dots top") {
+		t.Errorf("expected template from data directory")
+	}
+	if c.HasTemplate("P Lines medium") {
+		t.Errorf("unexpected template %q", "P Lines medium")
+	}
+}
+
+func TestPageLayer(t *testing.T) {
+	c := testContext()
+	c.Transparent = true
+
+	doc := rmtool.NewNotebook("Layers", "")
+	pageID := doc.Pages()[0]
+	d, err := doc.Drawing(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AddLayer("Second")
+	// one stroke per layer, in different places
+	for i, y := range []float32{100, 500} {
+		s := lines.Stroke{BrushType: lines.Fineliner, BrushColor: lines.Black}
+		for x := float32(100); x < 300; x += 10 {
+			s.Dots = append(s.Dots, lines.Dot{X: x, Y: y, Width: 5, Pressure: 1})
+		}
+		d.Layers[i].Strokes = append(d.Layers[i].Strokes, s)
+	}
+
+	var buf bytes.Buffer
+	err = c.PageLayer(doc, pageID, 1, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := img.At(200, 100).RGBA(); a != 0 {
+		t.Errorf("unexpected stroke from first layer")
+	}
+	if _, _, _, a := img.At(200, 500).RGBA(); a == 0 {
+		t.Errorf("missing stroke from second layer")
+	}
+
+	for _, idx := range []int{-1, 2} {
+		err = c.PageLayer(doc, pageID, idx, ioutil.Discard)
+		if err == nil {
+			t.Errorf("expected error for layer index %d", idx)
+		}
+	}
+}
+
+func TestPageCropped(t *testing.T) {
+	c := testContext()
+
+	doc := rmtool.NewNotebook("Cropped", "")
+	pageID := doc.Pages()[0]
+
+	// empty pages are not cropped
+	var buf bytes.Buffer
+	err := c.PageCropped(doc, pageID, 10, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, lines.MaxWidth, lines.MaxHeight) {
+		t.Errorf("unexpected bounds for empty page %v", img.Bounds())
+	}
+
+	d, err := doc.Drawing(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := lines.Stroke{BrushType: lines.Fineliner, BrushColor: lines.Black}
+	for x := float32(100); x < 300; x += 10 {
+		s.Dots = append(s.Dots, lines.Dot{X: x, Y: 100, Width: 5, Pressure: 1})
+	}
+	d.Layers[0].Strokes = append(d.Layers[0].Strokes, s)
+
+	buf.Reset()
+	err = c.PageCropped(doc, pageID, 10, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// dots from x=100..290, y=100 with width 5, plus margin
+	want := image.Rect(0, 0, 216, 26)
+	if img.Bounds() != want {
+		t.Errorf("unexpected bounds %v, want %v", img.Bounds(), want)
+	}
+	// the stroke is moved along with the cropped region
+	if r, _, _, _ := img.At(103, 13).RGBA(); r == 0xffff {
+		t.Errorf("missing stroke in cropped image")
+	}
+
+	// strokes outside the page are not visible, write the full page
+	d.Layers[0].Strokes = []lines.Stroke{{
+		BrushType:  lines.Fineliner,
+		BrushColor: lines.Black,
+		Dots:       []lines.Dot{{X: -200, Y: -200, Width: 5}, {X: -100, Y: -200, Width: 5}},
+	}}
+	buf.Reset()
+	err = c.PageCropped(doc, pageID, 10, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, lines.MaxWidth, lines.MaxHeight) {
+		t.Errorf("unexpected bounds for strokes outside the page %v", img.Bounds())
+	}
+
+	err = c.PageCropped(doc, pageID, -1, ioutil.Discard)
+	if err == nil {
+		t.Errorf("expected error for negative margin")
+	}
+}
+
+func TestDebugLayers(t *testing.T) {
+	c := testContext()
+	c.Transparent = true
+	c.DebugLayers = true
+
+	d := lines.NewDrawing()
+	d.AddLayer("Second")
+	for i, y := range []float32{100, 500} {
+		s := lines.Stroke{BrushType: lines.Fineliner, BrushColor: lines.Black}
+		for x := float32(100); x < 300; x += 10 {
+			s.Dots = append(s.Dots, lines.Dot{X: x, Y: y, Width: 5, Pressure: 1})
+		}
+		d.Layers[i].Strokes = append(d.Layers[i].Strokes, s)
+	}
+
+	img, err := renderDrawing(c, d, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, y := range []int{100, 500} {
+		got := color.RGBAModel.Convert(img.At(200, y))
+		want := color.RGBAModel.Convert(layerTints[i])
+		if got != want {
+			t.Errorf("unexpected color for layer %d: %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestEraseArea(t *testing.T) {
+	f, err := os.Open("testdata/erase_area.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// A horizontal line at y=100, an EraseArea stroke for the rectangle
+	// (150,50) - (250,150) and a second line at y=130.
+	d, err := lines.ReadDrawing(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := testContext()
+	img, err := renderDrawing(c, d, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	white := color.RGBAModel.Convert(color.White)
+	if color.RGBAModel.Convert(img.At(200, 100)) != white {
+		t.Errorf("expected erased area to show the background")
+	}
+	if color.RGBAModel.Convert(img.At(120, 100)) == white {
+		t.Errorf("expected stroke outside the erased area")
+	}
+	if color.RGBAModel.Convert(img.At(200, 130)) == white {
+		t.Errorf("expected stroke painted after the EraseArea stroke")
+	}
+
+	// without the EraseArea stroke, the first line is complete
+	d.Layers[0].Strokes = append(d.Layers[0].Strokes[:1], d.Layers[0].Strokes[2:]...)
+	img, err = renderDrawing(c, d, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if color.RGBAModel.Convert(img.At(200, 100)) == white {
+		t.Errorf("expected stroke without EraseArea")
+	}
+}
+
+func TestEraseAreaPolygon(t *testing.T) {
+	dst := image.NewRGBA(image.Rect(0, 0, 400, 400))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	// a triangle, the lower left half of the box (100,100) - (300,300)
+	s := lines.Stroke{
+		BrushType: lines.EraseArea,
+		Dots:      []lines.Dot{{X: 100, Y: 100}, {X: 300, Y: 300}, {X: 100, Y: 300}},
+	}
+	eraseArea(dst, s)
+
+	if _, _, _, a := dst.At(150, 250).RGBA(); a != 0 {
+		t.Errorf("expected erased pixel inside the polygon")
+	}
+	if _, _, _, a := dst.At(250, 150).RGBA(); a == 0 {
+		t.Errorf("expected pixel outside the polygon to be kept")
+	}
+	if _, _, _, a := dst.At(50, 50).RGBA(); a == 0 {
+		t.Errorf("expected pixel outside the area to be kept")
+	}
+}
+
+func TestTransparent(t *testing.T) {
+	c := testContext()
+	doc := rmtool.NewNotebook("Transparent", "")
+	pageID := doc.Pages()[0]
+
+	for _, transparent := range []bool{false, true} {
+		c.Transparent = transparent
+
+		var buf bytes.Buffer
+		err := c.Page(doc, pageID, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, _, a := img.At(10, 10).RGBA()
+		if transparent && a != 0 {
+			t.Errorf("expected transparent background, got alpha %d", a)
+		} else if !transparent && a != 0xffff {
+			t.Errorf("expected opaque background, got alpha %d", a)
+		}
+	}
+}
+
+func TestApplyTransform(t *testing.T) {
+	doc := rmtool.NewNotebook("Transform", "")
+	d := lines.NewDrawing()
+	d.Layers[0].Strokes = append(d.Layers[0].Strokes, lines.Stroke{
+		BrushType: lines.Fineliner,
+		Dots:      []lines.Dot{{X: 100, Y: 200, Width: 4}},
+	})
+	if applyTransform(doc, d) != d {
+		t.Errorf("expected unchanged drawing for identity transform")
+	}
+
+	// documents have no setter for the transform
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte(`"m31":0`), []byte(`"m31":10`), 1)
+	data = bytes.Replace(data, []byte(`"m11":1`), []byte(`"m11":2`), 1)
+	data = bytes.Replace(data, []byte(`"m22":1`), []byte(`"m22":2`), 1)
+	err = json.Unmarshal(data, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dot := applyTransform(doc, d).Layers[0].Strokes[0].Dots[0]
+	if dot.X != 210 || dot.Y != 400 || dot.Width != 8 {
+		t.Errorf("unexpected transformed dot %+v", dot)
+	}
+	if d.Layers[0].Strokes[0].Dots[0].X != 100 {
+		t.Errorf("original drawing was modified")
+	}
+}
+
+func TestLenientTemplates(t *testing.T) {
+	// a context that reads from disk, with an empty data directory
+	dir, err := ioutil.TempDir("", "rmtool-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := NewContext(dir, testContext().palette)
+	dst := image.NewRGBA(image.Rect(0, 0, lines.MaxWidth, lines.MaxHeight))
+
+	err = renderTemplate(c, dst, "P Lines medium", rmtool.Portrait)
+	if err == nil {
+		t.Errorf("expected error for missing template")
+	}
+
+	c.LenientTemplates = true
+	err = renderTemplate(c, dst, "P Lines medium", rmtool.Portrait)
+	if err != nil {
+		t.Errorf("unexpected error with LenientTemplates: %v", err)
+	}
+}
+
+func TestImageFormat(t *testing.T) {
+	c := testContext()
+	c.ImageFormat = JPEG
+	c.Transparent = true
+
+	var buf bytes.Buffer
+	err := c.Drawing(testDrawing(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != lines.MaxWidth || img.Bounds().Dy() != lines.MaxHeight {
+		t.Errorf("unexpected image size %v", img.Bounds())
+	}
+	// transparent areas are filled with the background color
+	r, g, b, _ := img.At(lines.MaxWidth-10, 10).RGBA()
+	if r < 0xf000 || g < 0xf000 || b < 0xf000 {
+		t.Errorf("expected white background, got %v", img.At(lines.MaxWidth-10, 10))
+	}
+
+	c.ImageFormat = ImageFormat(99)
+	err = c.Drawing(testDrawing(), ioutil.Discard)
+	if err == nil {
+		t.Errorf("expected error for unsupported image format")
+	}
+}
@@ -40,9 +40,9 @@ func (b *BasePen) RenderStroke(dst draw.Image, s lines.Stroke) {
 func (b *BasePen) renderSegment(dst draw.Image, start, end lines.Dot) {
 	width := float64(start.Width)
 	opacity := 1.0
-	mask := prepareMask(b.mask, width, opacity, start, end)
+	mask, length := prepareMask(b.mask, width, opacity, start, end)
 	overlap := 2.0
-	drawStamp(dst, mask, b.fill, start, end, overlap)
+	drawStamp(dst, mask, length, b.fill, start, end, overlap)
 }
 
 // Ballpoint ------------------------------------------------------------------
@@ -93,9 +93,9 @@ func (p *Pencil) renderSegment(dst draw.Image, start, end lines.Dot) {
 	y := 0.1
 	opacity := x*y + 1 - y
 
-	mask := prepareMask(p.mask, width, opacity, start, end)
+	mask, length := prepareMask(p.mask, width, opacity, start, end)
 	overlap := 1.5
-	drawStamp(dst, mask, p.fill, start, end, overlap)
+	drawStamp(dst, mask, length, p.fill, start, end, overlap)
 }
 
 // Mechanical Pencil ----------------------------------------------------------
@@ -112,9 +112,9 @@ func (m *MechanicalPencil) RenderStroke(dst draw.Image, s lines.Stroke) {
 func (m *MechanicalPencil) renderSegment(dst draw.Image, start, end lines.Dot) {
 	width := float64(start.Width)
 	opacity := 1.0
-	mask := prepareMask(m.mask, width, opacity, start, end)
+	mask, length := prepareMask(m.mask, width, opacity, start, end)
 	overlap := 4.0
-	drawStamp(dst, mask, m.fill, start, end, overlap)
+	drawStamp(dst, mask, length, m.fill, start, end, overlap)
 }
 
 // Marker ---------------------------------------------------------------------
@@ -131,9 +131,9 @@ func (m *Marker) RenderStroke(dst draw.Image, s lines.Stroke) {
 func (m *Marker) renderSegment(dst draw.Image, start, end lines.Dot) {
 	width := float64(start.Width)
 	opacity := 1.0
-	mask := prepareMask(m.mask, width, opacity, start, end)
+	mask, length := prepareMask(m.mask, width, opacity, start, end)
 	overlap := 4.0
-	drawStamp(dst, mask, m.fill, start, end, overlap)
+	drawStamp(dst, mask, length, m.fill, start, end, overlap)
 }
 
 // Highlighter ----------------------------------------------------------------
@@ -166,9 +166,9 @@ func (h *Highlighter) RenderStroke(dst draw.Image, s lines.Stroke) {
 func (h *Highlighter) renderSegment(dst draw.Image, start, end lines.Dot) {
 	width := float64(start.Width)
 	opacity := 1.0
-	mask := prepareMask(h.mask, width, opacity, start, end)
+	mask, length := prepareMask(h.mask, width, opacity, start, end)
 	overlap := 1.0
-	drawStamp(dst, mask, h.fill, start, end, overlap)
+	drawStamp(dst, mask, length, h.fill, start, end, overlap)
 }
 
 // Paintbrush -----------------------------------------------------------------
@@ -196,8 +196,12 @@ func walkDots(dst draw.Image, s lines.Stroke, render segmentRenderer) {
 
 // Prepare the mask image by scaling it to the desired width, applying opacity.
 // and rotating it to align with the segment from start to end.
-func prepareMask(mask image.Image, width, opacity float64, start, end lines.Dot) image.Image {
+//
+// Also returns the length of the scaled mask along the stroke direction,
+// which is needed to space the stamps for non-square masks.
+func prepareMask(mask image.Image, width, opacity float64, start, end lines.Dot) (image.Image, float64) {
 	i := imaging.Resize(mask, width)
+	length := float64(i.Bounds().Dx())
 
 	if opacity != 1.0 {
 		i = imaging.ApplyOpacity(i, opacity)
@@ -206,12 +210,15 @@ func prepareMask(mask image.Image, width, opacity float64, start, end lines.Dot)
 	// Rotate the brush to align with the path.
 	// Brush images are alinged "left to right", i.e. the "front" is on the left.
 	angle := math.Atan2(float64(start.Y-end.Y), float64(start.X-end.X))
-	return imaging.Rotate(angle, i)
+	return imaging.Rotate(angle, i), length
 }
 
 // Draw a single line from start to end with a "stamp" image.
 // The stamp image is repeated along the line, taking the overlap factor into account.
-func drawStamp(dst draw.Image, mask image.Image, fill image.Image, start, end lines.Dot, overlap float64) {
+//
+// The stampLen is the length of the (unrotated) stamp along the line,
+// see prepareMask.
+func drawStamp(dst draw.Image, mask image.Image, stampLen float64, fill image.Image, start, end lines.Dot, overlap float64) {
 	rect := mask.Bounds()
 	w := rect.Max.X - rect.Min.X
 	h := rect.Max.Y - rect.Min.Y
@@ -222,7 +229,10 @@ func drawStamp(dst draw.Image, mask image.Image, fill image.Image, start, end li
 	cSquared := math.Pow(a, float64(2.0)) + math.Pow(b, float64(2.0))
 	length := math.Sqrt(cSquared)
 
-	stampSize := float64(h) / overlap // assumes stamps are quadratic
+	stampSize := stampLen / overlap
+	if stampSize <= 0 {
+		return
+	}
 	numStamps := math.Ceil((length / stampSize))
 	yFraction := a / numStamps
 	xFraction := b / numStamps
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
+	"image/jpeg"
 	"image/png"
 	"io"
 	"os"
@@ -12,8 +14,10 @@ import (
 	"sync"
 
 	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/internal/errors"
 	"github.com/akeil/rmtool/internal/imaging"
 	"github.com/akeil/rmtool/internal/logging"
+	"github.com/akeil/rmtool/internal/sprites"
 	"github.com/akeil/rmtool/pkg/lines"
 )
 
@@ -36,22 +40,136 @@ var brushNames = map[lines.BrushType]string{
 }
 
 var defaultColors = map[lines.BrushColor]color.Color{
-	lines.Black: color.Black,
-	lines.Gray:  color.RGBA{150, 150, 150, 255},
-	lines.White: color.White,
+	lines.Black:       color.Black,
+	lines.Gray:        color.RGBA{150, 150, 150, 255},
+	lines.White:       color.White,
+	lines.Yellow:      color.RGBA{255, 237, 117, 255},
+	lines.Green:       color.RGBA{172, 255, 133, 255},
+	lines.Pink:        color.RGBA{255, 133, 198, 255},
+	lines.Blue:        color.RGBA{78, 105, 201, 255},
+	lines.Red:         color.RGBA{179, 62, 57, 255},
+	lines.GrayOverlap: color.RGBA{125, 125, 125, 255},
 }
 
+// ColorMode determines the colors used in rendered images.
+type ColorMode int
+
+const (
+	// Color renders images with the colors from the Palette.
+	Color ColorMode = iota
+	// Grayscale renders images in shades of gray.
+	Grayscale
+	// Mono renders images in black and white only.
+	Mono
+)
+
+// ImageFormat is the file format for rendered images.
+type ImageFormat int
+
+const (
+	// PNG is a lossless format with support for transparency.
+	PNG ImageFormat = iota
+	// JPEG is a lossy format with smaller files, e.g. for previews.
+	// JPEG does not support transparency, transparent areas are
+	// filled with the background color.
+	JPEG
+)
+
+// PageFit determines how drawings are placed on PDF pages.
+type PageFit int
+
+const (
+	// FitAspect scales drawings to the usable page area, keeping the aspect
+	// ratio of the tablet screen. The drawing is centered on the page.
+	FitAspect PageFit = iota
+	// FitWidth scales drawings to the usable page width.
+	// Depending on the page size, the drawing may overlap the bottom margin.
+	FitWidth
+	// FitPage stretches drawings to cover the complete page,
+	// ignoring margins and aspect ratio.
+	FitPage
+)
+
+// PageBox selects the page boundary of a source PDF that is used
+// when drawings are overlaid on an existing PDF.
+type PageBox int
+
+const (
+	// MediaBox uses the full physical page.
+	MediaBox PageBox = iota
+	// CropBox uses the visible region of the page,
+	// which is what PDF viewers usually display.
+	CropBox
+	// TrimBox uses the intended size of the finished page.
+	TrimBox
+)
+
+// name is the PDF name for this box, e.g. "/MediaBox".
+func (b PageBox) name() string {
+	switch b {
+	case CropBox:
+		return "/CropBox"
+	case TrimBox:
+		return "/TrimBox"
+	default:
+		return "/MediaBox"
+	}
+}
+
+// monoThreshold is the gray value below which pixels become black
+// in Mono mode.
+const monoThreshold = 128
+
 // Context holds parameters and cached data for rendering operations.
 //
 // If multiple drawings are rendered, they should use the same Context.
 type Context struct {
-	DataDir     string
+	DataDir string
+	// ColorMode controls the colors for rendered images, default is Color.
+	ColorMode ColorMode
+	// Antialias enables supersampling for strokes.
+	// Strokes are rendered at a higher resolution and then scaled down,
+	// which gives smoother lines but makes rendering several times slower.
+	Antialias bool
+	// DebugLayers paints the strokes of each layer in a different color
+	// to show which strokes belong to which layer. This is meant for
+	// debugging and disabled by default. Use with ColorMode Color.
+	DebugLayers bool
+	// Transparent skips the background color and template for PNG output,
+	// so that only the strokes are painted, e.g. to composite handwriting
+	// over other images. Default is an opaque background.
+	Transparent bool
+	// SkipBadPages renders a placeholder for pages whose drawing cannot be
+	// read when exporting PDFs, instead of failing. The failed pages are
+	// reported with a PartialError after the PDF has been written.
+	SkipBadPages bool
+	// LenientTemplates renders pages with a missing background template
+	// as if they had a blank template, instead of failing with an error.
+	LenientTemplates bool
+	// ImageFormat is the file format for rendered pages and drawings,
+	// default is PNG.
+	ImageFormat ImageFormat
+	// Quality is the quality for lossy image formats, from 1 to 100.
+	// If zero, a default quality is used.
+	Quality int
+	// Fit controls how drawings are placed on PDF pages, default is FitAspect.
+	Fit PageFit
+	// PageSize is the page size for PDF output, default is "A4".
+	// Can be a named size like "A4" or "Letter" or a custom size
+	// in points, given as "width,height".
+	PageSize string
+	// Author is added to the metadata of exported PDFs, if set.
+	Author string
+	// Box is the page boundary of the source PDF that is used
+	// for overlays, default is MediaBox.
+	Box         PageBox
 	palette     *Palette
 	sprites     *image.RGBA
-	spriteIndex map[string][]int
+	spriteIndex map[string]sprites.Sprite
 	spriteMx    sync.Mutex
 	tplCache    map[string]image.Image
 	tplMx       sync.Mutex
+	embedded    bool
 }
 
 // NewContext sets up a new rendering context.
@@ -65,18 +183,97 @@ func NewContext(dataDir string, p *Palette) *Context {
 	}
 }
 
+// NewContextWithAssets sets up a new rendering context with the given
+// spritesheet and templates. This context does not read from disk.
+//
+// The spriteIndex maps brush names to the sprite's rectangle within the
+// spritesheet as [x0, y0, x1, y1]. Templates are mapped by name;
+// templates can be nil if no background templates are needed.
+func NewContextWithAssets(sheet *image.RGBA, spriteIndex map[string][]int, templates map[string]image.Image, p *Palette) *Context {
+	tpl := make(map[string]image.Image)
+	for k, v := range templates {
+		tpl[k] = v
+	}
+
+	index := make(map[string]sprites.Sprite)
+	for k, v := range spriteIndex {
+		s, err := sprites.FromRect(v)
+		if err != nil {
+			logging.Warning("Invalid sprite entry for brush %q: %v", k, err)
+			continue
+		}
+		index[k] = s
+	}
+
+	return &Context{
+		palette:     p,
+		sprites:     sheet,
+		spriteIndex: index,
+		tplCache:    tpl,
+		embedded:    true,
+	}
+}
+
 // DefaultContext creates a new rendering context with default settings.
+//
+// Assets are loaded from DefaultDataDir().
 func DefaultContext() *Context {
 	gray := color.RGBA{150, 150, 150, 255}
-	// TODO hardcoded path - choose a more sensible value
-	return NewContext("./data", NewPalette(color.White, gray, defaultColors))
+	return NewContext(DefaultDataDir(), NewPalette(color.White, gray, defaultColors))
 }
 
-// Page draws a single page to a PNG and writes it to the given writer.
+// DefaultDataDir returns the default directory for rendering assets
+// (spritesheet and templates).
+//
+// This is "rmtool" in $XDG_DATA_HOME or in ~/.local/share if that
+// variable is not set.
+func DefaultDataDir() string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			logging.Warning("Could not determine data directory: %v", err)
+			return "rmtool"
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "rmtool")
+}
+
+// Page draws a single page to an image and writes it to the given writer.
+//
+// The image format is determined by ImageFormat.
 func (c *Context) Page(doc *rmtool.Document, pageID string, w io.Writer) error {
 	return renderPage(c, doc, pageID, w)
 }
 
+// PageCropped draws a single page like Page, but writes only the region
+// that contains the drawing, extended by margin pixels on each side.
+//
+// This is useful to share a small sketch from a large page.
+// The background template is cropped along with the drawing.
+// If the page has no strokes, the full page is written.
+func (c *Context) PageCropped(doc *rmtool.Document, pageID string, margin int, w io.Writer) error {
+	if margin < 0 {
+		return fmt.Errorf("invalid margin %d", margin)
+	}
+	return renderPageCropped(c, doc, pageID, margin, w)
+}
+
+// PageLayer draws a single layer from a page to an image
+// and writes it to the given writer.
+//
+// Like Page, the result includes the page's background template
+// unless the Context is set to Transparent.
+// The layerIndex is 0-based, an error is returned if the page
+// has no layer with that index.
+func (c *Context) PageLayer(doc *rmtool.Document, pageID string, layerIndex int, w io.Writer) error {
+	if layerIndex < 0 {
+		return fmt.Errorf("invalid layer index %d", layerIndex)
+	}
+	return renderPageLayer(c, doc, pageID, layerIndex, w)
+}
+
 // Pdf renders all pages from a document to a PDF file.
 //
 // The resulting PDF document is written to the given writer.
@@ -84,6 +281,71 @@ func (c *Context) Pdf(doc *rmtool.Document, w io.Writer) error {
 	return renderPdf(c, doc, w)
 }
 
+// Drawing renders a single drawing to an image with a white background
+// (unless Transparent is set) and writes it to the given writer.
+//
+// Unlike Page, this does not need a Document, e.g. for loose .rm files.
+func (c *Context) Drawing(d *lines.Drawing, w io.Writer) error {
+	img, err := renderDrawing(c, d, true)
+	if err != nil {
+		return err
+	}
+	return c.encode(w, img)
+}
+
+// DrawingPdf renders a single drawing into a one-page PDF
+// and writes it to the given writer.
+func (c *Context) DrawingPdf(d *lines.Drawing, w io.Writer) error {
+	pdf, err := setupPdf(c.PageSize, c.Author, nil)
+	if err != nil {
+		return err
+	}
+
+	pdf.AddPage()
+	err = drawingToPdf(c, pdf, d)
+	if err != nil {
+		return err
+	}
+
+	return pdf.Output(w)
+}
+
+// encode applies the ColorMode to the given image
+// and writes it in the configured ImageFormat.
+func (c *Context) encode(w io.Writer, img image.Image) error {
+	img = c.applyColorMode(img)
+
+	switch c.ImageFormat {
+	case PNG:
+		return png.Encode(w, img)
+	case JPEG:
+		// JPEG has no alpha channel, transparent pixels would turn black
+		flat := image.NewRGBA(img.Bounds())
+		renderBackground(c, flat)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+
+		q := c.Quality
+		if q == 0 {
+			q = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(w, flat, &jpeg.Options{Quality: q})
+	default:
+		return fmt.Errorf("unsupported image format %v", c.ImageFormat)
+	}
+}
+
+// applyColorMode converts the given image according to the ColorMode.
+func (c *Context) applyColorMode(img image.Image) image.Image {
+	switch c.ColorMode {
+	case Grayscale:
+		return imaging.Desaturate(img)
+	case Mono:
+		return imaging.ToMono(img, monoThreshold)
+	default:
+		return img
+	}
+}
+
 func (c *Context) loadBrush(bt lines.BrushType, bc lines.BrushColor) (Brush, error) {
 	col := c.palette.Color(bc)
 	if col == nil {
@@ -151,18 +413,20 @@ func (c *Context) loadBrushMask(name string) (image.Image, error) {
 		return nil, err
 	}
 
-	idx := c.spriteIndex[name]
-	if idx == nil {
+	sprite, ok := c.spriteIndex[name]
+	if !ok {
 		return nil, fmt.Errorf("no sprite image for brush %q", name)
-	} else if len(idx) != 4 {
-		return nil, fmt.Errorf("invalid sprite entry for brush %q", name)
 	}
 
-	rect := image.Rect(idx[0], idx[1], idx[2], idx[3])
+	// brush images can have any size, use the one from the index
+	rect := sprite.Rect()
 
-	// sanity check
-	if rect.Dx() > c.sprites.Bounds().Dx() || rect.Dy() > c.sprites.Bounds().Dy() {
-		return nil, fmt.Errorf("sprite bounds not within spritesheet dimensions")
+	// sanity check, catches mismatched sprites.json and sprites.png
+	if sprite.Width <= 0 || sprite.Height <= 0 {
+		return nil, fmt.Errorf("empty sprite rectangle %v for brush %q", rect, name)
+	}
+	if !rect.In(c.sprites.Bounds()) {
+		return nil, fmt.Errorf("sprite rectangle %v for brush %q not within spritesheet bounds %v", rect, name, c.sprites.Bounds())
 	}
 
 	return c.sprites.SubImage(rect), nil
@@ -175,6 +439,9 @@ func (c *Context) lazyLoadSpritesheet() error {
 		// already loaded
 		return nil
 	}
+	if c.embedded {
+		return fmt.Errorf("no spritesheet in rendering context")
+	}
 
 	// index map
 	jsonPath := filepath.Join(c.DataDir, "sprites.json")
@@ -206,6 +473,24 @@ func (c *Context) lazyLoadSpritesheet() error {
 	return nil
 }
 
+// HasTemplate tells if the background template with the given name
+// is available, e.g. to check the templates from
+// Document.UsedTemplates before rendering.
+func (c *Context) HasTemplate(name string) bool {
+	c.tplMx.Lock()
+	cached := c.tplCache[name]
+	c.tplMx.Unlock()
+	if cached != nil {
+		return true
+	}
+	if c.embedded {
+		return false
+	}
+
+	_, err := os.Stat(filepath.Join(c.DataDir, "templates", name+".png"))
+	return err == nil
+}
+
 func (c *Context) loadTemplate(name string) (image.Image, error) {
 	c.tplMx.Lock()
 	defer c.tplMx.Unlock()
@@ -216,9 +501,14 @@ func (c *Context) loadTemplate(name string) (image.Image, error) {
 	if cached != nil {
 		return cached, nil
 	}
+	if c.embedded {
+		return nil, errors.NewNotFound("no template with name %q", name)
+	}
 
 	img, err := readPNG(c.DataDir, "templates", name+".png")
-	if err != nil {
+	if os.IsNotExist(err) {
+		return nil, errors.NewNotFound("no template with name %q", name)
+	} else if err != nil {
 		return nil, err
 	}
 
@@ -243,11 +533,15 @@ func readPNG(path ...string) (image.Image, error) {
 // Palette holds the colors used for rendering.
 //
 // You can use a palette to map from the default colors of the reMarkable
-// tablet (black, gray, white) ro another color scheme.
+// tablet (black, gray, white and others) to another color scheme.
 type Palette struct {
 	Background  color.Color
 	Highlighter color.Color
 	colors      map[lines.BrushColor]color.Color
+	// unknown records unknown colors that have been reported,
+	// so that each one is logged only once.
+	unknown   map[lines.BrushColor]bool
+	unknownMx sync.Mutex
 }
 
 // NewPalette creates a new palette with the given color scheme.
@@ -261,10 +555,33 @@ func NewPalette(bg color.Color, highlighter color.Color, brushColors map[lines.B
 
 // Color is used by the renderer to retrieve the color value to use
 // for a specific Brush Color.
+//
+// Colors that are not part of the palette are taken from the defaults.
+// Unknown colors are rendered like Black.
 func (p *Palette) Color(bc lines.BrushColor) color.Color {
 	c, ok := p.colors[bc]
 	if ok {
 		return c
 	}
-	return defaultColors[bc]
+	c, ok = defaultColors[bc]
+	if ok {
+		return c
+	}
+	p.warnUnknown(bc)
+	return p.Color(lines.Black)
+}
+
+// warnUnknown logs a warning for an unknown brush color,
+// once for each color value.
+func (p *Palette) warnUnknown(bc lines.BrushColor) {
+	p.unknownMx.Lock()
+	defer p.unknownMx.Unlock()
+	if p.unknown[bc] {
+		return
+	}
+	if p.unknown == nil {
+		p.unknown = make(map[lines.BrushColor]bool)
+	}
+	p.unknown[bc] = true
+	logging.Warning("Unknown brush color %v, use black", bc)
 }
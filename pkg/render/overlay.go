@@ -10,11 +10,17 @@ import (
 	"github.com/jung-kurt/gofpdf/contrib/gofpdi"
 
 	"github.com/akeil/rmtool"
-	"github.com/akeil/rmtool/internal/errors"
 	"github.com/akeil/rmtool/internal/logging"
+	"github.com/akeil/rmtool/internal/pdfinfo"
 )
 
-func overlayPdf(c *Context, doc *rmtool.Document, pdf *gofpdf.Fpdf) error {
+// overlayPdf adds the pages from the document's PDF attachment
+// and paints the drawings on top.
+//
+// Source pages are imported with gofpdi as form XObjects which keeps their
+// content streams, i.e. the text stays searchable. Annotations like links
+// are stored outside the content stream and are lost.
+func overlayPdf(c *Context, doc *rmtool.Document, pdf *gofpdf.Fpdf, failed *PartialError) error {
 	logging.Debug("Render PDF with overlay")
 
 	// Read the underlaying PDF doc
@@ -32,70 +38,132 @@ func overlayPdf(c *Context, doc *rmtool.Document, pdf *gofpdf.Fpdf) error {
 	}
 	rs := io.ReadSeeker(bytes.NewReader(data))
 
+	// Pages are mapped 1:1 to the source PDF, but the page counts can differ,
+	// e.g. if the attachment was replaced.
+	dims, err := pdfinfo.PageDims(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	numSource := len(dims)
+	numPages := len(doc.Pages())
+	if numSource != numPages {
+		logging.Warning("Document %q has %d pages, but the PDF has %d pages", doc.ID(), numPages, numSource)
+	}
+	if numSource > numPages {
+		logging.Warning("Skip %d PDF pages without corresponding page in the document", numSource-numPages)
+	}
+
 	im := gofpdi.NewImporter()
 	pdf.OpenLayerPane() // controls behavior of the PDF viewer
 	docLayer := pdf.AddLayer("Document", true)
 	drawLayer := pdf.AddLayer("Drawing", true)
 
+	// Before the first page is added, this is the default (portrait) size.
+	wPage, hPage := pdf.GetPageSize()
+	defaultSize := gofpdf.SizeType{Wd: wPage, Ht: hPage}
+	box := c.Box.name()
+
 	for i, pageID := range doc.Pages() {
-		pdf.AddPage()
+		if i >= numSource {
+			// No source page, render the drawing on an empty page
+			logging.Warning("No PDF page for page %d, use an empty page", i+1)
+			pdf.AddPageFormat(orientationStr(doc.EffectiveOrientation(pageID)), defaultSize)
+			err = overlayDrawing(c, doc, pageID, i, pdf, drawLayer, failed)
+			if err != nil {
+				return err
+			}
+			continue
+		}
 
 		var tplID int
+		var sizes map[int]map[string]map[string]float64
 		err = dontPanic(func() {
-			// TODO: how do we know which box to use?
-			tplID = im.ImportPageFromStream(pdf, &rs, i+1, "/MediaBox")
+			tplID = im.ImportPageFromStream(pdf, &rs, i+1, box)
+			sizes = im.GetPageSizes()
 		})
 		if err != nil {
 			return err
 		}
-		// Setting h, w to 0 fills the page
+
+		// Use the size of the selected box for the page, so that the
+		// imported page and the drawing are placed relative to the same area.
+		// Otherwise, keep the layout of the source page.
+		dim := sizes[i+1][box]
+		if dim["w"] > 0 && dim["h"] > 0 {
+			pdf.AddPageFormat("P", gofpdf.SizeType{Wd: dim["w"], Ht: dim["h"]})
+		} else {
+			pdf.AddPageFormat(orientationStr(doc.EffectiveOrientation(pageID)), defaultSize)
+		}
+
+		// Setting h, w to 0 uses the size of the template
 		pdf.BeginLayer(docLayer)
 		im.UseImportedTemplate(pdf, tplID, 0, 0, 0, 0)
 		pdf.EndLayer()
 
-		// Paint the drawing over the original
-		d, err := doc.Drawing(pageID)
-		if errors.IsNotFound(err) {
-			// Not every page has a drawing
-			logging.Info("Skip page %d without drawing", i)
-			continue
-		} else if err != nil {
+		err = overlayDrawing(c, doc, pageID, i, pdf, drawLayer, failed)
+		if err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
 
-		logging.Debug("overlay the drawing for page %v", i)
+// overlayDrawing paints the drawing for the given page, if it has one,
+// on the current page of the PDF.
+func overlayDrawing(c *Context, doc *rmtool.Document, pageID string, i int, pdf *gofpdf.Fpdf, layer int, failed *PartialError) error {
+	// Not every page has a drawing
+	hasDrawing, err := doc.HasDrawing(pageID)
+	if err != nil {
+		return err
+	}
+	if !hasDrawing {
+		logging.Info("Skip page %d without drawing", i)
+		return nil
+	}
 
-		pdf.BeginLayer(drawLayer)
-		err = drawingToPdf(c, pdf, d)
+	// Paint the drawing over the original
+	d, err := readDrawing(c, doc, pageID, i, failed)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		pdf.BeginLayer(layer)
+		placeholder(pdf, i)
 		pdf.EndLayer()
-		if err != nil {
-			return err
-		}
+		return nil
 	}
+	d = applyTransform(doc, d)
 
-	return nil
+	logging.Debug("overlay the drawing for page %v", i)
+
+	pdf.BeginLayer(layer)
+	err = drawingToPdf(c, pdf, d)
+	pdf.EndLayer()
+	return err
 }
 
-// dontPanic executes the given function in a separate goroutine.
-// If that panics, it will recover and return the panic as an error.
-func dontPanic(f func()) error {
-	rv := make(chan error)
-
-	go func() {
-		// this will "catch" any panic and send its mssage to the error channel
-		defer func() {
-			x := recover()
-			if x != nil {
-				logging.Warning("Panic occured (revoered): %v", x)
-				rv <- fmt.Errorf("recovered from: %v", x)
-			}
-			rv <- nil
-		}()
+// orientationStr is the gofpdf orientation for the given layout.
+func orientationStr(o rmtool.Orientation) string {
+	if o == rmtool.Landscape {
+		return "L"
+	}
+	return "P"
+}
 
-		// the actual call that might panic
-		f()
+// dontPanic executes the given function and recovers if it panics.
+// The panic is returned as an error.
+//
+// This is needed because gofpdi panics instead of returning errors.
+func dontPanic(f func()) (err error) {
+	defer func() {
+		x := recover()
+		if x != nil {
+			logging.Warning("Panic occured (recovered): %v", x)
+			err = fmt.Errorf("recovered from: %v", x)
+		}
 	}()
 
-	// wait for the result
-	return <-rv
+	f()
+	return nil
 }
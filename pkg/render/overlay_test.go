@@ -0,0 +1,51 @@
+package render
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/jung-kurt/gofpdf/contrib/gofpdi"
+)
+
+func TestDontPanic(t *testing.T) {
+	err := dontPanic(func() {
+		panic("import failed")
+	})
+	if err == nil {
+		t.Errorf("expected error for panic")
+	}
+
+	called := false
+	err = dontPanic(func() {
+		called = true
+	})
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if !called {
+		t.Errorf("function was not called")
+	}
+}
+
+func TestDontPanicImport(t *testing.T) {
+	src := gofpdf.New("P", "pt", "A4", "")
+	src.AddPage()
+	var buf bytes.Buffer
+	err := src.Output(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := io.ReadSeeker(bytes.NewReader(buf.Bytes()))
+
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	im := gofpdi.NewImporter()
+	err = dontPanic(func() {
+		// there is no page 3
+		im.ImportPageFromStream(pdf, &rs, 3, "/MediaBox")
+	})
+	if err == nil {
+		t.Errorf("expected error for invalid page number")
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/jung-kurt/gofpdf"
 
 	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/internal/errors"
 	"github.com/akeil/rmtool/internal/logging"
 	"github.com/akeil/rmtool/pkg/lines"
 )
@@ -18,9 +22,21 @@ const (
 	defaultPageSize = "A4"
 )
 
+// knownPageSizes are the named page sizes supported by gofpdf.
+var knownPageSizes = map[string]bool{
+	"a3":      true,
+	"a4":      true,
+	"a5":      true,
+	"letter":  true,
+	"legal":   true,
+	"tabloid": true,
+}
+
 // Pdf renders all pages of the given document to a PDF file.
 //
 // The result is written to the given writer.
+//
+// This is a shortcut for Context.Pdf with the DefaultContext.
 func Pdf(d *rmtool.Document, w io.Writer) error {
 	c := DefaultContext()
 	return renderPdf(c, d, w)
@@ -28,14 +44,87 @@ func Pdf(d *rmtool.Document, w io.Writer) error {
 
 // PdfPage renders a single drawing into a single one-page PDF.
 func PdfPage(c *Context, d *rmtool.Document, pageID string, w io.Writer) error {
-	pdf := setupPdf(defaultPageSize, nil)
+	pdf, err := setupPdf(c.PageSize, c.Author, nil)
+	if err != nil {
+		return err
+	}
+
+	var failed PartialError
+	err = doRenderPdfPage(c, pdf, d, pageID, 0, &failed)
+	if err != nil {
+		return err
+	}
 
-	err := doRenderPdfPage(c, pdf, d, pageID, 0)
+	err = pdf.Output(w)
 	if err != nil {
 		return err
 	}
+	return failed.orNil()
+}
 
-	return pdf.Output(w)
+// A PageError describes a page that could not be rendered
+// because its drawing could not be read.
+type PageError struct {
+	// PageID is the ID of the page.
+	PageID string
+	// Number is the 1-based page number.
+	Number int
+	// Err is the error from reading the drawing.
+	Err error
+}
+
+func (e PageError) Error() string {
+	return fmt.Sprintf("page %d: %v", e.Number, e.Err)
+}
+
+// PartialError is returned when a PDF is rendered with SkipBadPages
+// and some of the pages could not be read.
+//
+// The PDF has been written completely, with a placeholder
+// for each of the failed pages. Use a type assertion to check for this:
+//
+//	if partial, ok := err.(*render.PartialError); ok {
+//	    // use partial.Pages
+//	}
+type PartialError struct {
+	Pages []PageError
+}
+
+func (e *PartialError) Error() string {
+	msgs := make([]string, len(e.Pages))
+	for i, p := range e.Pages {
+		msgs[i] = p.Error()
+	}
+	return fmt.Sprintf("failed to read %d page(s): %v", len(e.Pages), strings.Join(msgs, "; "))
+}
+
+// orNil returns the PartialError if there are failed pages and nil otherwise.
+func (e *PartialError) orNil() error {
+	if len(e.Pages) == 0 {
+		return nil
+	}
+	return e
+}
+
+// readDrawing reads the drawing for the page with the given index.
+//
+// If the Context has SkipBadPages enabled, read errors are added to failed
+// and a nil drawing is returned without an error.
+func readDrawing(c *Context, doc *rmtool.Document, pageID string, i int, failed *PartialError) (*lines.Drawing, error) {
+	d, err := doc.Drawing(pageID)
+	if err != nil && c.SkipBadPages {
+		logging.Warning("Failed to read page %d, use a placeholder: %v", i+1, err)
+		failed.Pages = append(failed.Pages, PageError{PageID: pageID, Number: i + 1, Err: err})
+		return nil, nil
+	}
+	return d, err
+}
+
+// placeholder marks the current page of the PDF as a page
+// that could not be read.
+func placeholder(pdf *gofpdf.Fpdf, i int) {
+	pdf.SetXY(24, 24)
+	pdf.Cellf(0, 10, "failed to read page %d", i+1)
 }
 
 func renderPdf(c *Context, d *rmtool.Document, w io.Writer) error {
@@ -44,24 +133,31 @@ func renderPdf(c *Context, d *rmtool.Document, w io.Writer) error {
 	}
 
 	logging.Debug("Render PDF for document %q, type %q", d.ID(), d.FileType())
-	pdf := setupPdf(defaultPageSize, d)
+	pdf, err := setupPdf(c.PageSize, c.Author, d)
+	if err != nil {
+		return err
+	}
 
-	var err error
+	var failed PartialError
 	if d.FileType() == rmtool.Pdf {
-		err = overlayPdf(c, d, pdf)
+		err = overlayPdf(c, d, pdf, &failed)
 	} else {
-		err = drawingsPdf(c, pdf, d)
+		err = drawingsPdf(c, pdf, d, &failed)
 	}
 
 	if err != nil {
 		return err
 	}
-	return pdf.Output(w)
+	err = pdf.Output(w)
+	if err != nil {
+		return err
+	}
+	return failed.orNil()
 }
 
-func drawingsPdf(c *Context, pdf *gofpdf.Fpdf, d *rmtool.Document) error {
+func drawingsPdf(c *Context, pdf *gofpdf.Fpdf, d *rmtool.Document, failed *PartialError) error {
 	for i, pageID := range d.Pages() {
-		err := doRenderPdfPage(c, pdf, d, pageID, i)
+		err := doRenderPdfPage(c, pdf, d, pageID, i, failed)
 		if err != nil {
 			return err
 		}
@@ -70,8 +166,8 @@ func drawingsPdf(c *Context, pdf *gofpdf.Fpdf, d *rmtool.Document) error {
 	return nil
 }
 
-func doRenderPdfPage(c *Context, pdf *gofpdf.Fpdf, doc *rmtool.Document, pageID string, i int) error {
-	d, err := doc.Drawing(pageID)
+func doRenderPdfPage(c *Context, pdf *gofpdf.Fpdf, doc *rmtool.Document, pageID string, i int, failed *PartialError) error {
+	d, err := readDrawing(c, doc, pageID, i, failed)
 	if err != nil {
 		return err
 	}
@@ -80,6 +176,12 @@ func doRenderPdfPage(c *Context, pdf *gofpdf.Fpdf, doc *rmtool.Document, pageID
 	// and set the page to Landscape
 	pdf.AddPage()
 
+	if d == nil {
+		placeholder(pdf, i)
+		return nil
+	}
+	d = applyTransform(doc, d)
+
 	// TODO: add the background template
 
 	return drawingToPdf(c, pdf, d)
@@ -103,14 +205,7 @@ func drawingToPdf(c *Context, pdf *gofpdf.Fpdf, d *lines.Drawing) error {
 	// pdf.ImageOptions(...) will read frm the registered reader
 	pdf.RegisterImageOptionsReader(id, opts, &buf)
 
-	// The drawing will be scaled to the (usable) page width
-	wPage, _ := pdf.GetPageSize()
-	left, _, right, _ := pdf.GetMargins()
-	w := wPage - left - right
-
-	x := 0.0
-	y := 0.0
-	h := 0.0
+	x, y, w, h := placement(c.Fit, pdf)
 	flow := false
 	link := 0
 	linkStr := ""
@@ -119,17 +214,52 @@ func drawingToPdf(c *Context, pdf *gofpdf.Fpdf, d *lines.Drawing) error {
 	return nil
 }
 
-func setupPdf(pageSize string, d *rmtool.Document) *gofpdf.Fpdf {
-	orientation := "P" // [P]ortrait or [L]andscape
-	sizeUnit := "pt"
-	fontDir := ""
-	pdf := gofpdf.New(orientation, sizeUnit, pageSize, fontDir)
+// placement calculates the position and size for a drawing on the current
+// page of the given PDF.
+//
+// A height of zero means that the height is calculated from the width.
+func placement(fit PageFit, pdf *gofpdf.Fpdf) (x, y, w, h float64) {
+	wPage, hPage := pdf.GetPageSize()
+	left, top, right, bottom := pdf.GetMargins()
+	wUsable := wPage - left - right
+	hUsable := hPage - top - bottom
+
+	switch fit {
+	case FitPage:
+		return 0, 0, wPage, hPage
+	case FitWidth:
+		return left, top, wUsable, 0
+	default:
+		ratio := float64(lines.MaxWidth) / float64(lines.MaxHeight)
+		w = wUsable
+		h = w / ratio
+		if h > hUsable {
+			h = hUsable
+			w = h * ratio
+		}
+		x = left + (wUsable-w)/2
+		y = top + (hUsable-h)/2
+		return x, y, w, h
+	}
+}
+
+func setupPdf(pageSize, author string, d *rmtool.Document) (*gofpdf.Fpdf, error) {
+	init, err := pageSizeInit(pageSize)
+	if err != nil {
+		return nil, err
+	}
+	init.OrientationStr = "P" // [P]ortrait or [L]andscape
+	init.UnitStr = "pt"
+	pdf := gofpdf.NewCustom(init)
 
 	//pdf.SetMargins(0, 8, 0) // left, top, right
 	pdf.AliasNbPages("{totalPages}")
 	pdf.SetFont("helvetica", "", 8)
 	pdf.SetTextColor(127, 127, 127)
 	pdf.SetProducer("rmtool", true)
+	if author != "" {
+		pdf.SetAuthor(author, true)
+	}
 
 	// If we are rendering a complete notebook, add metadata
 	if d != nil {
@@ -137,6 +267,10 @@ func setupPdf(pageSize string, d *rmtool.Document) *gofpdf.Fpdf {
 		modified := d.LastModified().UTC()
 		pdf.SetModificationDate(modified)
 		pdf.SetCreationDate(modified)
+		kw := keywords(d.Labels())
+		if kw != "" {
+			pdf.SetKeywords(kw, true)
+		}
 
 		pdf.SetFooterFunc(func() {
 			pdf.SetY(-20)
@@ -149,5 +283,58 @@ func setupPdf(pageSize string, d *rmtool.Document) *gofpdf.Fpdf {
 		})
 	}
 
-	return pdf
+	return pdf, nil
+}
+
+// keywords creates the PDF keywords from the given document labels.
+// Labels are formatted as "key=value", sorted by key and separated by commas.
+// Returns an empty string if there are no labels.
+func keywords(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kw := make([]string, len(keys))
+	for i, k := range keys {
+		kw[i] = k + "=" + labels[k]
+	}
+	return strings.Join(kw, ", ")
+}
+
+// pageSizeInit creates the init options for the given page size.
+//
+// The size is either the name of a standard page size (e.g. "A4", "Letter")
+// or the width and height in points, separated by a comma (e.g. "600,800").
+// An empty string selects the default page size.
+func pageSizeInit(s string) (*gofpdf.InitType, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		s = defaultPageSize
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) == 1 {
+		if !knownPageSizes[strings.ToLower(s)] {
+			return nil, errors.NewValidationError("unknown page size %q", s)
+		}
+		return &gofpdf.InitType{SizeStr: s}, nil
+	} else if len(parts) != 2 {
+		return nil, errors.NewValidationError("invalid page size %q", s)
+	}
+
+	w, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return nil, errors.NewValidationError("invalid page width in %q", s)
+	}
+	h, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return nil, errors.NewValidationError("invalid page height in %q", s)
+	}
+	if w <= 0 || h <= 0 {
+		return nil, errors.NewValidationError("page dimensions must be positive, got %q", s)
+	}
+
+	return &gofpdf.InitType{Size: gofpdf.SizeType{Wd: w, Ht: h}}, nil
 }
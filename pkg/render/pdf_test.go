@@ -0,0 +1,414 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+
+	"github.com/akeil/rmtool"
+	"github.com/akeil/rmtool/pkg/lines"
+)
+
+func TestPlacement(t *testing.T) {
+	pdf, err := setupPdf(defaultPageSize, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf.AddPage()
+	wPage, hPage := pdf.GetPageSize()
+	left, top, right, bottom := pdf.GetMargins()
+
+	x, y, w, h := placement(FitPage, pdf)
+	if x != 0 || y != 0 || w != wPage || h != hPage {
+		t.Errorf("FitPage: unexpected placement %v, %v, %v, %v", x, y, w, h)
+	}
+
+	x, y, w, h = placement(FitWidth, pdf)
+	if x != left || w != wPage-left-right || h != 0 {
+		t.Errorf("FitWidth: unexpected placement %v, %v, %v, %v", x, y, w, h)
+	}
+
+	x, y, w, h = placement(FitAspect, pdf)
+	ratio := float64(lines.MaxWidth) / float64(lines.MaxHeight)
+	if math.Abs(w/h-ratio) > 0.0001 {
+		t.Errorf("FitAspect: aspect ratio not preserved, got %v, want %v", w/h, ratio)
+	}
+	if x < left || x+w > wPage-right+0.0001 || y < top || y+h > hPage-bottom+0.0001 {
+		t.Errorf("FitAspect: drawing exceeds usable area: %v, %v, %v, %v", x, y, w, h)
+	}
+	// centered
+	if math.Abs((x-left)-(wPage-right-x-w)) > 0.0001 {
+		t.Errorf("FitAspect: drawing not centered horizontally")
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	cases := []struct {
+		size  string
+		valid bool
+		w, h  float64
+	}{
+		{"", true, 595.28, 841.89},
+		{"A4", true, 595.28, 841.89},
+		{"letter", true, 612, 792},
+		{"600,800", true, 600, 800},
+		{" 600 , 800 ", true, 600, 800},
+		{"B7", false, 0, 0},
+		{"600", false, 0, 0},
+		{"600,x", false, 0, 0},
+		{"0,800", false, 0, 0},
+		{"1,2,3", false, 0, 0},
+	}
+
+	for _, c := range cases {
+		pdf, err := setupPdf(c.size, "", nil)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("expected error for page size %q", c.size)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for page size %q: %v", c.size, err)
+			continue
+		}
+		pdf.AddPage()
+		w, h := pdf.GetPageSize()
+		if math.Abs(w-c.w) > 0.01 || math.Abs(h-c.h) > 0.01 {
+			t.Errorf("page size %q: got %vx%v, want %vx%v", c.size, w, h, c.w, c.h)
+		}
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	kw := keywords(map[string]string{"project": "rmtool", "client": "ACME"})
+	if kw != "client=ACME, project=rmtool" {
+		t.Errorf("unexpected keywords %q", kw)
+	}
+
+	if kw := keywords(nil); kw != "" {
+		t.Errorf("expected no keywords, got %q", kw)
+	}
+}
+
+func TestOverlayOrientation(t *testing.T) {
+	src := gofpdf.New("P", "pt", "A4", "")
+	src.AddPage()
+	src.AddPageFormat("L", src.GetPageSizeStr("A4"))
+	var buf bytes.Buffer
+	err := src.Output(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := rmtool.NewPdf("Mixed", "", func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = renderPdf(DefaultContext(), doc, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := pdfcpu.Read(bytes.NewReader(out.Bytes()), pdfcpu.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dims, err := ctx.PageDims()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dims) != 2 {
+		t.Fatalf("unexpected page count %v", len(dims))
+	}
+	if dims[0].Width > dims[0].Height {
+		t.Errorf("expected portrait for first page, got %v", dims[0])
+	}
+	if dims[1].Width < dims[1].Height {
+		t.Errorf("expected landscape for second page, got %v", dims[1])
+	}
+}
+
+func TestOverlayKeepsText(t *testing.T) {
+	src := gofpdf.New("P", "pt", "A4", "")
+	src.SetFont("Helvetica", "", 12)
+	src.AddPage()
+	src.Text(72, 72, "Searchable research paper")
+	var buf bytes.Buffer
+	err := src.Output(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := rmtool.NewPdf("Paper", "", func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = renderPdf(DefaultContext(), doc, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The imported page is embedded as a form XObject,
+	// so the text operators from the source are still there.
+	ctx, err := pdfcpu.Read(bytes.NewReader(out.Bytes()), pdfcpu.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, entry := range ctx.Table {
+		sd, ok := entry.Object.(pdfcpu.StreamDict)
+		if !ok {
+			continue
+		}
+		err = sd.Decode()
+		if err != nil {
+			continue
+		}
+		if bytes.Contains(sd.Content, []byte("(Searchable research paper) Tj")) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("text from the source PDF not found in the exported PDF")
+	}
+}
+
+func TestOverlayBox(t *testing.T) {
+	src := gofpdf.New("P", "pt", "A4", "")
+	src.SetPageBox("crop", 50, 50, 400, 600)
+	src.AddPage()
+	var buf bytes.Buffer
+	err := src.Output(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := rmtool.NewPdf("Cropped", "", func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		box  PageBox
+		w, h float64
+	}{
+		{MediaBox, 595.28, 841.89},
+		{CropBox, 400, 600},
+	}
+	for _, tc := range cases {
+		c := DefaultContext()
+		c.Box = tc.box
+		var out bytes.Buffer
+		err = renderPdf(c, doc, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, err := pdfcpu.Read(bytes.NewReader(out.Bytes()), pdfcpu.NewDefaultConfiguration())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dims, err := ctx.PageDims()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(dims[0].Width-tc.w) > 0.01 || math.Abs(dims[0].Height-tc.h) > 0.01 {
+			t.Errorf("%v: unexpected page size %v, want %vx%v", tc.box.name(), dims[0], tc.w, tc.h)
+		}
+	}
+}
+
+func TestOverlayPageCountMismatch(t *testing.T) {
+	makePdf := func(pages int) []byte {
+		src := gofpdf.New("P", "pt", "A4", "")
+		for i := 0; i < pages; i++ {
+			src.AddPage()
+		}
+		var buf bytes.Buffer
+		err := src.Output(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	cases := []struct {
+		docPages, srcPages int
+	}{
+		{2, 1}, // more document pages, rendered on empty pages
+		{1, 3}, // more source pages, skipped
+	}
+	for _, tc := range cases {
+		data := makePdf(tc.docPages)
+		doc, err := rmtool.NewPdf("Mismatch", "", func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// replace the attachment after the pages were created
+		data = makePdf(tc.srcPages)
+
+		var out bytes.Buffer
+		err = renderPdf(DefaultContext(), doc, &out)
+		if err != nil {
+			t.Errorf("%d/%d pages: %v", tc.docPages, tc.srcPages, err)
+			continue
+		}
+
+		ctx, err := pdfcpu.Read(bytes.NewReader(out.Bytes()), pdfcpu.NewDefaultConfiguration())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ctx.EnsurePageCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ctx.PageCount != tc.docPages {
+			t.Errorf("%d/%d pages: unexpected page count %v", tc.docPages, tc.srcPages, ctx.PageCount)
+		}
+	}
+}
+
+func TestDrawingPdf(t *testing.T) {
+	c := testContext()
+
+	var buf bytes.Buffer
+	err := c.DrawingPdf(testDrawing(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := pdfcpu.Read(bytes.NewReader(buf.Bytes()), pdfcpu.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.EnsurePageCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.PageCount != 1 {
+		t.Errorf("unexpected page count %v", ctx.PageCount)
+	}
+}
+
+func TestSkipBadPages(t *testing.T) {
+	repo := &brokenRepo{
+		good: "good-page",
+		bad:  "bad-page",
+	}
+	meta := rmtool.NewNotebook("Broken", "").Meta
+	repo.id = meta.ID()
+	doc, err := rmtool.ReadDocument(repo, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := testContext()
+	err = c.Pdf(doc, ioutil.Discard)
+	if err == nil {
+		t.Fatalf("expected error for page with invalid drawing")
+	}
+	if _, ok := err.(*PartialError); ok {
+		t.Errorf("unexpected partial result without SkipBadPages")
+	}
+
+	c.SkipBadPages = true
+	var buf bytes.Buffer
+	err = c.Pdf(doc, &buf)
+	partial, ok := err.(*PartialError)
+	if !ok {
+		t.Fatalf("expected PartialError, got %v", err)
+	}
+	if len(partial.Pages) != 1 || partial.Pages[0].PageID != repo.bad || partial.Pages[0].Number != 2 {
+		t.Errorf("unexpected failed pages %v", partial.Pages)
+	}
+
+	ctx, err := pdfcpu.Read(bytes.NewReader(buf.Bytes()), pdfcpu.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.EnsurePageCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.PageCount != 2 {
+		t.Errorf("unexpected page count %v", ctx.PageCount)
+	}
+}
+
+// brokenRepo is a Repository with a single notebook
+// with one valid and one invalid drawing.
+type brokenRepo struct {
+	id   string
+	good string
+	bad  string
+}
+
+func (r *brokenRepo) List() ([]rmtool.Meta, error) {
+	return nil, nil
+}
+
+func (r *brokenRepo) Update(m rmtool.Meta) error {
+	return nil
+}
+
+func (r *brokenRepo) Reader(id string, version uint, path ...string) (io.ReadCloser, error) {
+	var data []byte
+	switch strings.Join(path, "/") {
+	case id + ".content":
+		c := rmtool.NewContent(rmtool.Notebook)
+		c.Pages = []string{r.good, r.bad}
+		c.PageCount = len(c.Pages)
+		var err error
+		data, err = json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+	case id + ".pagedata":
+		data = []byte("Blank\nBlank\n")
+	case id + "/" + r.good + ".rm":
+		var buf bytes.Buffer
+		err := lines.WriteDrawing(&buf, testDrawing())
+		if err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	case id + "/" + r.bad + ".rm":
+		data = []byte("not a drawing")
+	default:
+		return nil, fmt.Errorf("not found: %v", path)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (r *brokenRepo) PagePrefix(pageID string, pageIndex int) string {
+	return pageID
+}
+
+func (r *brokenRepo) Upload(d *rmtool.Document) error {
+	return nil
+}
+
+func (r *brokenRepo) ListFiles(id string, version uint) ([]string, error) {
+	return nil, nil
+}
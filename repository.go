@@ -13,6 +13,8 @@ import (
 
 	"github.com/akeil/rmtool/internal/errors"
 	"github.com/akeil/rmtool/internal/logging"
+	"github.com/akeil/rmtool/internal/pdfinfo"
+	"github.com/akeil/rmtool/pkg/lines"
 )
 
 type WriterFunc func(path ...string) (io.WriteCloser, error)
@@ -57,6 +59,79 @@ type Repository interface {
 
 	// Upload creates the given document in the repository.
 	Upload(d *Document) error
+
+	// ListFiles returns the names of all files that belong to an item,
+	// e.g. content, metadata, pagedata and the drawings for each page.
+	//
+	// Paths are relative to the storage root and use "/" as separator,
+	// so they can be passed to Reader().
+	ListFiles(id string, version uint) ([]string, error)
+}
+
+// Not all operations are supported by every backend.
+// Repositories can implement one or more of the optional interfaces below
+// and callers can use a type assertion to check for a capability:
+//
+//   d, ok := repo.(rmtool.Deleter)
+//   if !ok {
+//       return fmt.Errorf("repository does not support delete")
+//   }
+
+// Deleter is implemented by repositories which can delete items.
+type Deleter interface {
+	// Delete removes a document or an empty folder from the repository.
+	Delete(m Meta) error
+}
+
+// FolderCreator is implemented by repositories which can create folders.
+type FolderCreator interface {
+	// CreateFolder creates a new folder with the given name.
+	// The parentID can be empty (root folder) or refer to another folder.
+	CreateFolder(parentID, name string) error
+}
+
+// Restorer is implemented by repositories which can restore deleted items.
+type Restorer interface {
+	// Restore moves the item with the given ID out of the trash folder
+	// and into the given parent folder.
+	// The parentID can be empty (root folder) or refer to another folder.
+	Restore(id, parentID string) error
+}
+
+// Mover is implemented by repositories which can move items
+// to another folder.
+type Mover interface {
+	// Move changes the parent folder for the item with the given ID.
+	// The parentID can be empty (root folder) or refer to another folder.
+	// A folder cannot be moved into itself or one of its subfolders.
+	Move(id, parentID string) error
+}
+
+// UnknownSize is returned as the size of an item
+// if the size cannot be determined.
+const UnknownSize int64 = -1
+
+// Sizer is implemented by repositories which can tell the size of an item
+// without reading its content.
+type Sizer interface {
+	// Size returns the size in bytes for all files that belong to an item,
+	// or UnknownSize. The value is an estimate, e.g. it can be the size
+	// of the compressed archive for remote items.
+	Size(id string, version uint) (int64, error)
+}
+
+// A ChangeHandler is called by a Notifier when the item with the given ID
+// has been added or changed. The deleted flag is set if the item was removed.
+type ChangeHandler func(id string, deleted bool)
+
+// Notifier is implemented by repositories which can report changes
+// as they happen.
+type Notifier interface {
+	// Watch starts listening for changes and calls the given handler
+	// for each change. Calling Watch again replaces the current handler.
+	Watch(h ChangeHandler) error
+	// Unwatch stops listening for changes.
+	Unwatch()
 }
 
 // Meta is the interface for a single entry (a nodebook or folder) in a
@@ -75,12 +150,22 @@ type Meta interface {
 	SetPinned(p bool)
 	LastModified() time.Time
 	Parent() string
+	// LastOpenedPage is the index of the page that was last viewed
+	// or 0 if unknown.
+	LastOpenedPage() int
+	SetLastOpenedPage(p int)
 
 	// Validate checks the internal state of this item
 	// and returns an error if it is not valid.
 	Validate() error
 }
 
+// IsVersionConflict tells if the given error was caused by updating an item
+// with an outdated version. Callers can reload the item and try again.
+func IsVersionConflict(err error) bool {
+	return errors.IsVersionConflict(err)
+}
+
 // ReadDocument is a helper function to read a full Document from a repository entry.
 // TODO make this a method of the repository, transfer implementation to internal/
 func ReadDocument(r Repository, m Meta) (*Document, error) {
@@ -109,13 +194,74 @@ func ReadDocument(r Repository, m Meta) (*Document, error) {
 	}, nil
 }
 
+// Copy reads the document with the given ID from the src repository
+// and uploads it to the dst repository, into the folder with the given
+// dstParentID (empty for root).
+//
+// The copy gets a new ID, the name and pinned state are preserved.
+// Attachments (PDF, EPUB) are read from src as they are written to dst.
+func Copy(src Repository, srcID string, dst Repository, dstParentID string) error {
+	items, err := src.List()
+	if err != nil {
+		return err
+	}
+
+	var m Meta
+	for _, item := range items {
+		if item.ID() == srcID {
+			m = item
+			break
+		}
+	}
+	if m == nil {
+		return errors.NewNotFound("no item with id %q", srcID)
+	}
+
+	doc, err := ReadDocument(src, m)
+	if err != nil {
+		return err
+	}
+
+	// Load all pages and drawings into the cache,
+	// the copy has a new ID and is not backed by the src repository.
+	err = doc.EachPage(func(p *Page, d *lines.Drawing) error {
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	meta := newDocMeta(DocumentType, m.Name(), dstParentID)
+	meta.SetPinned(m.Pinned())
+	content := *doc.content
+	c := &Document{
+		Meta:     meta,
+		content:  &content,
+		pagedata: doc.pagedata,
+		pages:    doc.pages,
+		drawings: doc.drawings,
+	}
+	if doc.FileType() == Pdf || doc.FileType() == Epub {
+		c.attachmentReader = doc.AttachmentReader
+	}
+
+	logging.Debug("Copy %q as %q", srcID, c.ID())
+	return dst.Upload(c)
+}
+
 // Page describes a single page within a document.
 type Page struct {
+	id       string
 	index    int
 	meta     *PageMetadata
 	pagedata string
 }
 
+// ID is the unique identifier for this page.
+func (p *Page) ID() string {
+	return p.id
+}
+
 // Number is the 1-based page number.
 func (p *Page) Number() uint {
 	return uint(p.index + 1)
@@ -130,9 +276,13 @@ func (p *Page) Template() string {
 // TODO set template
 
 // HasTemplate tells if this page is associated with a background template.
-// Returns false for the "Blank" template.
+// Returns false for the "Blank" template, with or without orientation prefix.
 func (p *Page) HasTemplate() bool {
-	return p.pagedata != "" && p.pagedata != blankTemplate
+	pd, err := ParsePagedataLine(p.pagedata)
+	if err != nil {
+		return false
+	}
+	return pd.Template != blankTemplate
 }
 
 // Layers is the metadata for the layers in this page.
@@ -152,6 +302,7 @@ type docMeta struct {
 	pinned       bool
 	lastModified time.Time
 	parent       string
+	lastOpened   int
 }
 
 func newDocMeta(t NotebookType, name, parentID string) Meta {
@@ -200,6 +351,14 @@ func (d *docMeta) Parent() string {
 	return d.parent
 }
 
+func (d *docMeta) LastOpenedPage() int {
+	return d.lastOpened
+}
+
+func (d *docMeta) SetLastOpenedPage(p int) {
+	d.lastOpened = p
+}
+
 func (d *docMeta) Reader(path ...string) (io.ReadCloser, error) {
 	return nil, fmt.Errorf("not implemented")
 }
@@ -225,27 +384,16 @@ func (d *docMeta) Validate() error {
 
 // PDF Helper -----------------------------------------------------------------
 
-func countPdfPages(rc io.ReadCloser) (int, error) {
+// readPdfPageDims reads the media box dimensions for each page of a PDF file.
+// The number of pages is the length of the returned slice.
+func readPdfPageDims(rc io.ReadCloser) ([]pdfcpu.Dim, error) {
 	data, err := ioutil.ReadAll(rc)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	rc.Close()
-	rs := io.ReadSeeker(bytes.NewReader(data))
-
-	cfg := pdfcpu.NewDefaultConfiguration()
-	ctx, err := pdfcpu.Read(rs, cfg)
-	if err != nil {
-		return 0, err
-	}
-
-	// This *must* be called before accessing page count
-	err = ctx.EnsurePageCount()
-	if err != nil {
-		return 0, err
-	}
 
-	return ctx.PageCount, nil
+	return pdfinfo.PageDims(bytes.NewReader(data))
 }
 
 // EPUB Helper ----------------------------------------------------------------
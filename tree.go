@@ -56,6 +56,70 @@ func (n *Node) Path() []string {
 	return p
 }
 
+// Ancestors returns the parent, grandparent etc. of this node,
+// starting with the root node. The node itself is not included.
+//
+// Returns an empty list for the root node.
+func (n *Node) Ancestors() []*Node {
+	a := make([]*Node, 0)
+	for ctx := n.ParentNode; ctx != nil; ctx = ctx.ParentNode {
+		a = append([]*Node{ctx}, a...)
+	}
+	return a
+}
+
+// Root returns the root node of the tree that contains this node.
+// For the root node, this is the node itself.
+func (n *Node) Root() *Node {
+	ctx := n
+	for ctx.ParentNode != nil {
+		ctx = ctx.ParentNode
+	}
+	return ctx
+}
+
+// FindByID returns the node with the given ID from the subtree starting at
+// this node (including this node) or nil if there is no such node.
+//
+// This also finds the virtual nodes, i.e. the trash folder (TrashFolder)
+// and the root node from BuildTree (empty ID).
+func (n *Node) FindByID(id string) *Node {
+	if n.ID() == id {
+		return n
+	}
+
+	for _, c := range n.Children {
+		found := c.FindByID(id)
+		if found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+// Count returns the number of documents and folders in the subtree below
+// this node, not including the node itself.
+//
+// The trash folder and its content are not counted,
+// unless Count is called on the trash folder itself.
+func (n *Node) Count() (documents, folders int) {
+	for _, c := range n.Children {
+		if c.ID() == TrashFolder {
+			continue
+		}
+		if c.Type() == CollectionType {
+			folders++
+		} else {
+			documents++
+		}
+		d, f := c.Count()
+		documents += d
+		folders += f
+	}
+	return documents, folders
+}
+
 // Walk applies the given function to the subtree starting at this node,
 // (including this node). Returns the first error that is encountered or nil.
 func (n *Node) Walk(f func(n *Node) error) error {
@@ -74,6 +138,42 @@ func (n *Node) Walk(f func(n *Node) error) error {
 	return nil
 }
 
+// WalkDocuments reads the document for each leaf node in the subtree starting
+// at this node and applies the given function to it.
+// Folders are not passed to the function.
+//
+// If skipTrash is set, deleted items (everything inside the trash folder)
+// are skipped.
+//
+// Returns the first error that is encountered or nil.
+func (n *Node) WalkDocuments(repo Repository, skipTrash bool, f func(d *Document) error) error {
+	if skipTrash && n.isTrashed() {
+		return nil
+	}
+
+	if n.Type() == DocumentType {
+		doc, err := ReadDocument(repo, n.Meta)
+		if err != nil {
+			return err
+		}
+		return f(doc)
+	}
+
+	for _, c := range n.Children {
+		err := c.WalkDocuments(repo, skipTrash, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tell if this node is the trash folder or an item inside the trash.
+func (n *Node) isTrashed() bool {
+	return n.ID() == TrashFolder || n.Parent() == TrashFolder
+}
+
 // addChild adds a child node to this node and sets the Parent field
 // of the child.
 func (n *Node) addChild(child *Node) {
@@ -99,9 +199,64 @@ func (n *Node) put(other *Node) bool {
 	return false
 }
 
+// OrphanMode determines how BuildTree handles orphaned items,
+// i.e. items whose parent folder does not exist.
+type OrphanMode int
+
+const (
+	// OrphansToRoot adds orphaned items to the root folder.
+	OrphansToRoot OrphanMode = iota
+	// OrphansToFolder adds orphaned items to a virtual folder
+	// with the ID OrphanedFolder.
+	OrphansToFolder
+	// OrphansDrop leaves orphaned items out of the tree.
+	OrphansDrop
+)
+
+// OrphanedFolder is the ID for the virtual folder that holds orphaned items
+// (see OrphansToFolder).
+const OrphanedFolder = "orphaned"
+
+// IsDescendant tells if the item with the given id is the item with
+// ancestorID or one of its descendants, according to the parent references
+// in items. Cyclic parent references are handled.
+//
+// This can be used to check that a folder is not moved into itself
+// or one of its subfolders.
+func IsDescendant(items []Meta, id, ancestorID string) bool {
+	parents := make(map[string]string)
+	for _, item := range items {
+		parents[item.ID()] = item.Parent()
+	}
+
+	// follow the parent references up to root, guard against cycles
+	visited := make(map[string]bool)
+	for p := id; p != "" && !visited[p]; p = parents[p] {
+		if p == ancestorID {
+			return true
+		}
+		visited[p] = true
+	}
+
+	return false
+}
+
 // BuildTree creates a tree view of all items in the given repository.
 // Returns the root node.
+//
+// Items whose parent folder does not exist are added to the root folder,
+// cyclic parent references are broken up.
 func BuildTree(items []Meta) *Node {
+	return BuildTreeWithOrphans(items, OrphansToRoot)
+}
+
+// BuildTreeWithOrphans creates a tree view of all items in the given
+// repository and handles orphaned items according to the given mode.
+// Returns the root node.
+//
+// If items reference each other as parents in a cycle, one of the items
+// is added to the root folder to break the cycle.
+func BuildTreeWithOrphans(items []Meta, mode OrphanMode) *Node {
 	root := newNode(&nodeMeta{name: "root", nbType: CollectionType})
 	root.addChild(newNode(&nodeMeta{
 		id:     TrashFolder,
@@ -115,6 +270,89 @@ func BuildTree(items []Meta) *Node {
 	}
 
 	// build a tree structure from the flat list
+	nodes = fitNodes(root, nodes)
+
+	// The remaining nodes are either orphans, descendants of orphans
+	// or part of a cycle.
+	var orphanParent *Node
+	for len(nodes) != 0 {
+		remaining := make(map[string]*Node)
+		for _, n := range nodes {
+			remaining[n.ID()] = n
+		}
+
+		others := make([]*Node, 0)
+		found := false
+		for _, n := range nodes {
+			if remaining[n.Parent()] != nil {
+				others = append(others, n)
+				continue
+			}
+
+			found = true
+			switch mode {
+			case OrphansDrop:
+				logging.Warning("Drop item %q, parent %q not found", n.ID(), n.Parent())
+			case OrphansToFolder:
+				if orphanParent == nil {
+					orphanParent = newNode(&nodeMeta{
+						id:     OrphanedFolder,
+						name:   "Orphaned",
+						nbType: CollectionType,
+					})
+					root.addChild(orphanParent)
+				}
+				logging.Debug("Parent %q for item %q not found", n.Parent(), n.ID())
+				orphanParent.addChild(n)
+			default:
+				logging.Debug("Parent %q for item %q not found", n.Parent(), n.ID())
+				root.addChild(n)
+			}
+		}
+
+		// No orphans means that all remaining nodes are part of or below
+		// a cycle. Break the cycle by moving one of its items to root.
+		if !found {
+			n := findCycle(remaining, nodes[0])
+			logging.Warning("Item %q is its own ancestor, add it to root", n.ID())
+			root.addChild(n)
+			others = withoutNode(others, n)
+		}
+
+		nodes = fitNodes(root, others)
+	}
+
+	return root
+}
+
+// findCycle follows the parent references from the given start node
+// and returns the first node that is visited twice.
+//
+// All parents must be present in the given map.
+func findCycle(nodes map[string]*Node, start *Node) *Node {
+	visited := make(map[string]bool)
+	n := start
+	for !visited[n.ID()] {
+		visited[n.ID()] = true
+		n = nodes[n.Parent()]
+	}
+	return n
+}
+
+// withoutNode returns the given list of nodes without the given node.
+func withoutNode(nodes []*Node, skip *Node) []*Node {
+	result := make([]*Node, 0, len(nodes))
+	for _, n := range nodes {
+		if n != skip {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// fitNodes adds the given nodes to the tree below root, where possible.
+// Returns the nodes that could not be added.
+func fitNodes(root *Node, nodes []*Node) []*Node {
 	var change bool
 	for {
 		change = false
@@ -131,14 +369,46 @@ func BuildTree(items []Meta) *Node {
 			break
 		}
 	}
+	return nodes
+}
 
-	if len(nodes) != 0 {
-		logging.Warning("could not fit all notes into the tree")
+// WithoutTrash returns a new node that is the root of a subtree starting at
+// this node, with the trash folder and all deleted items removed.
+//
+// Use this on the result of BuildTree() to exclude deleted items up front.
+func (n *Node) WithoutTrash() *Node {
+	root := newNode(n.Meta)
+	for _, child := range n.Children {
+		if child.isTrashed() {
+			continue
+		}
+		root.addChild(child.WithoutTrash())
 	}
-
 	return root
 }
 
+// TrashedItems returns the items from the trash folder within the subtree
+// starting at this node, most recently deleted first.
+//
+// There is no deletion date, so the LastModified date is used instead.
+// Only the items that are immediate children of the trash folder are
+// returned, that is, the content of deleted folders is not included.
+func (n *Node) TrashedItems() []*Node {
+	items := make([]*Node, 0)
+	n.Walk(func(x *Node) error {
+		if x.ID() == TrashFolder {
+			items = append(items, x.Children...)
+		}
+		return nil
+	})
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].LastModified().After(items[j].LastModified())
+	})
+
+	return items
+}
+
 // NodeComparator is used to sort nodes in a tree.
 // It should return true if "one" comes before "other".
 type NodeComparator func(one, other *Node) bool
@@ -164,18 +434,9 @@ func (n *Node) Sort(compare NodeComparator) {
 // The "Trash" folder comes last.
 func DefaultSort(one, other *Node) bool {
 	// tell if  one <  other
-	// special case - Trash goes last
-	if one.ID() == TrashFolder {
-		return false
-	} else if other.ID() == TrashFolder {
-		return true
-	}
-
-	// collections before content
-	if one.IsLeaf() && !other.IsLeaf() {
-		return false
-	} else if other.IsLeaf() && !one.IsLeaf() {
-		return true
+	less, ok := sortFolders(one, other)
+	if ok {
+		return less
 	}
 
 	// pinned before unpinned
@@ -194,6 +455,111 @@ func DefaultSort(one, other *Node) bool {
 	return strings.ToLower(one.Name()) < strings.ToLower(other.Name())
 }
 
+// SortByModified is a comparison function to sort nodes by their last
+// modification date, newest first.
+// Like DefaultSort, folders come before documents and "Trash" comes last.
+func SortByModified(one, other *Node) bool {
+	less, ok := sortFolders(one, other)
+	if ok {
+		return less
+	}
+
+	a := one.LastModified()
+	b := other.LastModified()
+	if a.Equal(b) {
+		return DefaultSort(one, other)
+	}
+
+	return a.After(b)
+}
+
+// SortByPageCount creates a comparison function to sort documents by their
+// number of pages, largest first.
+// Like DefaultSort, folders come before documents and "Trash" comes last.
+//
+// The page count is read from the given repository as needed.
+// Documents for which the page count cannot be determined are sorted as if
+// they had no pages.
+func SortByPageCount(repo Repository) NodeComparator {
+	counts := make(map[string]int)
+	count := func(n *Node) int {
+		c, ok := counts[n.ID()]
+		if ok {
+			return c
+		}
+
+		d, err := ReadDocument(repo, n.Meta)
+		if err != nil {
+			logging.Warning("Could not read page count for %q: %v", n.ID(), err)
+		} else {
+			c = d.PageCount()
+		}
+		counts[n.ID()] = c
+
+		return c
+	}
+
+	return func(one, other *Node) bool {
+		less, ok := sortFolders(one, other)
+		if ok {
+			return less
+		}
+
+		if one.Type() != DocumentType || other.Type() != DocumentType {
+			return DefaultSort(one, other)
+		}
+
+		a := count(one)
+		b := count(other)
+		if a == b {
+			return DefaultSort(one, other)
+		}
+
+		return a > b
+	}
+}
+
+// Reverse creates a comparison function which inverts the sort order
+// of the given comparator.
+//
+// The shared rules are not reversed: folders still come before documents
+// and "Trash" comes last.
+func Reverse(compare NodeComparator) NodeComparator {
+	return func(one, other *Node) bool {
+		less, ok := sortFolders(one, other)
+		if ok {
+			return less
+		}
+
+		// Swap the arguments instead of negating the result,
+		// so that equal nodes still compare as "not less".
+		return compare(other, one)
+	}
+}
+
+// sortFolders applies the sort rules that are shared by all comparators:
+// The "Trash" folder comes last and folders come before documents.
+//
+// Returns the result for "one < other" and a flag which is false if the order
+// is not decided by these rules.
+func sortFolders(one, other *Node) (bool, bool) {
+	// special case - Trash goes last
+	if one.ID() == TrashFolder {
+		return false, true
+	} else if other.ID() == TrashFolder {
+		return true, true
+	}
+
+	// collections before content
+	if one.IsLeaf() && !other.IsLeaf() {
+		return false, true
+	} else if other.IsLeaf() && !one.IsLeaf() {
+		return true, true
+	}
+
+	return false, false
+}
+
 // A NodeFilter is a function that can be used to test whether a node should
 // be included in a filtered subset or not.
 type NodeFilter func(n *Node) bool
@@ -313,6 +679,71 @@ func IsPinned(n *Node) bool {
 	return n.Pinned()
 }
 
+// ModifiedAfter creates a node filter that matches items which were
+// last modified after the given time.
+func ModifiedAfter(t time.Time) NodeFilter {
+	return func(n *Node) bool {
+		return n.LastModified().After(t)
+	}
+}
+
+// IsReal creates a node filter that excludes dummy (placeholder) documents.
+// Folders are always matched.
+//
+// The content for each document is read from the given repository,
+// so this filter should come after cheaper filters.
+// Documents that cannot be read are matched.
+func IsReal(repo Repository) NodeFilter {
+	return func(n *Node) bool {
+		if n.Type() != DocumentType {
+			return true
+		}
+
+		d, err := ReadDocument(repo, n.Meta)
+		if err != nil {
+			logging.Warning("Could not read content for %q: %v", n.ID(), err)
+			return true
+		}
+
+		return !d.IsDummy()
+	}
+}
+
+// MatchAny creates a node filter that matches if at least one of the given
+// filters matches.
+// If no filters are given, nothing is matched.
+func MatchAny(filters ...NodeFilter) NodeFilter {
+	return func(n *Node) bool {
+		for _, accept := range filters {
+			if accept(n) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchAll creates a node filter that matches only if all of the given
+// filters match.
+// If no filters are given, everything is matched.
+func MatchAll(filters ...NodeFilter) NodeFilter {
+	return func(n *Node) bool {
+		for _, accept := range filters {
+			if !accept(n) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Not creates a node filter that matches if the given filter does not match.
+func Not(f NodeFilter) NodeFilter {
+	return func(n *Node) bool {
+		return !f(n)
+	}
+}
+
 // implements the Meta interface for "virtual" nodes
 // (root and "trash").
 type nodeMeta struct {
@@ -354,6 +785,12 @@ func (n *nodeMeta) Parent() string {
 	return n.parent
 }
 
+func (n *nodeMeta) LastOpenedPage() int {
+	return 0
+}
+
+func (n *nodeMeta) SetLastOpenedPage(p int) {}
+
 func (n *nodeMeta) Reader(path ...string) (io.ReadCloser, error) {
 	return nil, fmt.Errorf("not implemented for virtual nodes")
 }
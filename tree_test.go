@@ -1,8 +1,14 @@
 package rmtool
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -32,6 +38,44 @@ func TestWalkTree(t *testing.T) {
 	assert.Equal(1, counter)
 }
 
+func TestWalkDocuments(t *testing.T) {
+	assert := assert.New(t)
+	root := sampleTree()
+	trash := node(TrashFolder, "Trash", CollectionType)
+	trash.addChild(newNode(&nodeMeta{"t0", TrashFolder, "t0", DocumentType}))
+	root.addChild(trash)
+
+	repo := &testRepo{}
+
+	actual := make([]string, 0)
+	err := root.WalkDocuments(repo, true, func(d *Document) error {
+		actual = append(actual, d.ID())
+		return nil
+	})
+	assert.Nil(err)
+	expected := []string{"a0", "a1", "a2", "c0", "c1", "c2"}
+	assert.ElementsMatch(expected, actual, "unexpected documents visited")
+
+	actual = make([]string, 0)
+	err = root.WalkDocuments(repo, false, func(d *Document) error {
+		actual = append(actual, d.ID())
+		return nil
+	})
+	assert.Nil(err)
+	expected = append(expected, "t0")
+	assert.ElementsMatch(expected, actual, "trashed documents should be included")
+
+	// error from visitor function should be returned
+	expectedErr := errors.New("some error")
+	counter := 0
+	actualErr := root.WalkDocuments(repo, true, func(d *Document) error {
+		counter++
+		return expectedErr
+	})
+	assert.Equal(expectedErr, actualErr)
+	assert.Equal(1, counter)
+}
+
 func TestMatchName(t *testing.T) {
 	assert := assert.New(t)
 	n := node("foobar", "Foo Bar", DocumentType)
@@ -58,6 +102,69 @@ func TestMatchType(t *testing.T) {
 	assert.True(IsFolder(folder))
 }
 
+func TestModifiedAfter(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	old := newNode(&docMeta{id: "old", name: "old", nbType: DocumentType, lastModified: now.Add(-time.Hour)})
+	recent := newNode(&docMeta{id: "new", name: "new", nbType: DocumentType, lastModified: now})
+
+	f := ModifiedAfter(now.Add(-time.Minute))
+	assert.False(f(old))
+	assert.True(f(recent))
+}
+
+func TestIsReal(t *testing.T) {
+	assert := assert.New(t)
+	f := IsReal(&testRepo{})
+
+	assert.True(f(node("doc", "Doc", DocumentType)))
+	assert.False(f(node("dummy-doc", "Dummy", DocumentType)))
+	assert.True(f(node("folder", "Folder", CollectionType)))
+}
+
+func TestIsDescendant(t *testing.T) {
+	assert := assert.New(t)
+	items := []Meta{
+		&nodeMeta{"a", "", "A", CollectionType},
+		&nodeMeta{"b", "a", "B", CollectionType},
+		&nodeMeta{"c", "b", "C", CollectionType},
+		&nodeMeta{"d", "", "D", CollectionType},
+		// cycle
+		&nodeMeta{"x", "y", "X", CollectionType},
+		&nodeMeta{"y", "x", "Y", CollectionType},
+	}
+
+	assert.True(IsDescendant(items, "a", "a"), "an item is its own descendant")
+	assert.True(IsDescendant(items, "b", "a"))
+	assert.True(IsDescendant(items, "c", "a"))
+	assert.False(IsDescendant(items, "a", "c"))
+	assert.False(IsDescendant(items, "d", "a"))
+	assert.False(IsDescendant(items, "", "a"), "root is no descendant")
+	assert.True(IsDescendant(items, "x", "y"))
+	assert.False(IsDescendant(items, "x", "a"), "cycles must terminate")
+}
+
+func TestMatchCombinators(t *testing.T) {
+	assert := assert.New(t)
+	doc := node("foo", "Foo", DocumentType)
+	folder := node("bar", "Bar", CollectionType)
+
+	anyOf := MatchAny(IsFolder, MatchName("foo"))
+	assert.True(anyOf(doc))
+	assert.True(anyOf(folder))
+	assert.False(anyOf(node("baz", "Baz", DocumentType)))
+	assert.False(MatchAny()(doc), "empty MatchAny should match nothing")
+
+	allOf := MatchAll(IsDocument, MatchName("foo"))
+	assert.True(allOf(doc))
+	assert.False(allOf(folder))
+	assert.False(allOf(node("baz", "Baz", DocumentType)))
+	assert.True(MatchAll()(doc), "empty MatchAll should match all")
+
+	assert.False(Not(IsDocument)(doc))
+	assert.True(Not(IsDocument)(folder))
+}
+
 func TestFilterTree(t *testing.T) {
 	assert := assert.New(t)
 	root := sampleTree()
@@ -77,6 +184,50 @@ func TestFilterTree(t *testing.T) {
 	assert.Equal("c0", f.Children[1].Children[0].ID())
 }
 
+func TestWithoutTrash(t *testing.T) {
+	assert := assert.New(t)
+	root := BuildTree([]Meta{
+		&nodeMeta{"a0", "", "a0", DocumentType},
+		&nodeMeta{"b0", "", "b0", CollectionType},
+		&nodeMeta{"c0", "b0", "c0", DocumentType},
+		&nodeMeta{"t0", TrashFolder, "t0", DocumentType},
+	})
+	assert.Equal(3, len(root.Children), "precondition failed")
+
+	x := root.WithoutTrash()
+	assert.Equal(2, len(x.Children))
+	for _, c := range x.Children {
+		assert.NotEqual(TrashFolder, c.ID(), "trash folder not removed")
+	}
+	assert.Equal(3, len(root.Children), "original tree was modified")
+
+	// nested items should be preserved
+	x.Sort(DefaultSort)
+	assert.Equal("b0", x.Children[0].ID())
+	assert.Equal(1, len(x.Children[0].Children))
+	assert.Equal([]string{"root", "b0"}, x.Children[0].Children[0].Path())
+}
+
+func TestTrashedItems(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	root := BuildTree([]Meta{
+		&docMeta{id: "a0", name: "a0", nbType: DocumentType, lastModified: now},
+		&docMeta{id: "t0", name: "t0", nbType: DocumentType, parent: TrashFolder, lastModified: now.Add(-time.Hour)},
+		&docMeta{id: "t1", name: "t1", nbType: DocumentType, parent: TrashFolder, lastModified: now},
+		&docMeta{id: "t2", name: "t2", nbType: CollectionType, parent: TrashFolder, lastModified: now.Add(-2 * time.Hour)},
+		&docMeta{id: "x0", name: "x0", nbType: DocumentType, parent: "t2", lastModified: now},
+	})
+
+	items := root.TrashedItems()
+	assert.Equal(3, len(items))
+	assert.Equal("t1", items[0].ID(), "most recent first")
+	assert.Equal("t0", items[1].ID())
+	assert.Equal("t2", items[2].ID())
+
+	assert.Equal(0, len(root.WithoutTrash().TrashedItems()))
+}
+
 func TestSortTree(t *testing.T) {
 	assert := assert.New(t)
 	root := sampleTree()
@@ -88,6 +239,68 @@ func TestSortTree(t *testing.T) {
 	assert.Equal(root.Children[1].ID(), "a0", "documents by name")
 }
 
+func TestSortByModified(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	root := newNode(&nodeMeta{"root", "", "root", CollectionType})
+	root.addChild(newNode(&docMeta{id: "old", name: "a", nbType: DocumentType, lastModified: now.Add(-time.Hour)}))
+	root.addChild(newNode(&docMeta{id: "new", name: "b", nbType: DocumentType, lastModified: now}))
+	root.addChild(newNode(&docMeta{id: "dir", name: "c", nbType: CollectionType, lastModified: now.Add(-2 * time.Hour)}))
+
+	root.Sort(SortByModified)
+	assert.Equal("dir", root.Children[0].ID(), "Folders must come before documents")
+	assert.Equal("new", root.Children[1].ID(), "newest first")
+	assert.Equal("old", root.Children[2].ID(), "oldest last")
+}
+
+func TestSortReverse(t *testing.T) {
+	assert := assert.New(t)
+	root := sampleTree()
+
+	root.addChild(node(TrashFolder, "Trash", CollectionType))
+	root.addChild(node("d0", "d0", CollectionType))
+
+	root.Sort(Reverse(DefaultSort))
+	assert.Equal("d0", root.Children[0].ID(), "Folders must come before documents")
+	assert.Equal("b0", root.Children[1].ID(), "folders by name, reversed")
+	assert.Equal("a2", root.Children[2].ID(), "documents by name, reversed")
+	assert.Equal("a0", root.Children[4].ID(), "documents by name, reversed")
+	assert.Equal(TrashFolder, root.Children[5].ID(), "Trash must come last")
+	assert.Equal("c2", root.Children[1].Children[0].ID(), "nested nodes should be sorted")
+
+	// equal nodes are not less than each other
+	n := root.Children[0]
+	assert.False(Reverse(DefaultSort)(n, n))
+}
+
+func TestSortByPageCount(t *testing.T) {
+	assert := assert.New(t)
+	repo := &testRepo{pageCounts: map[string]int{
+		"few":   1,
+		"many":  5,
+		"many2": 5,
+	}}
+
+	root := node("root", "root", CollectionType)
+	root.addChild(node(TrashFolder, "Trash", CollectionType))
+	root.addChild(node("broken", "broken", DocumentType))
+	root.addChild(node("few", "few", DocumentType))
+	root.addChild(node("empty", "empty", DocumentType))
+	root.addChild(node("many2", "many2", DocumentType))
+	root.addChild(node("folder", "folder", CollectionType))
+	root.addChild(node("many", "many", DocumentType))
+
+	root.Sort(SortByPageCount(repo))
+	actual := make([]string, 0)
+	for _, c := range root.Children {
+		actual = append(actual, c.ID())
+	}
+	// equal page counts are sorted by name,
+	// unreadable documents count as empty
+	expected := []string{"folder", "many", "many2", "few", "broken", "empty", TrashFolder}
+	assert.Equal(expected, actual)
+}
+
 func TestTreePath(t *testing.T) {
 	assert := assert.New(t)
 	root := sampleTree()
@@ -104,6 +317,123 @@ func TestTreePath(t *testing.T) {
 	assert.Equal(root.Children[0].Children[0].Path(), []string{"root", "b0"})
 }
 
+func TestAncestors(t *testing.T) {
+	assert := assert.New(t)
+	root := sampleTree()
+	b0 := root.Children[3]
+	c1 := b0.Children[1]
+
+	assert.Empty(root.Ancestors())
+	assert.Equal([]*Node{root}, b0.Ancestors())
+	assert.Equal([]*Node{root, b0}, c1.Ancestors())
+
+	assert.Equal(root, root.Root())
+	assert.Equal(root, c1.Root())
+}
+
+func TestCount(t *testing.T) {
+	assert := assert.New(t)
+	root := sampleTree()
+	trash := node(TrashFolder, "Trash", CollectionType)
+	trash.addChild(newNode(&nodeMeta{"t0", TrashFolder, "t0", DocumentType}))
+	trash.addChild(newNode(&nodeMeta{"t1", TrashFolder, "t1", CollectionType}))
+	root.addChild(trash)
+
+	docs, folders := root.Count()
+	assert.Equal(6, docs)
+	assert.Equal(1, folders, "trash should not be counted")
+
+	docs, folders = root.Children[3].Count()
+	assert.Equal(3, docs)
+	assert.Equal(0, folders)
+
+	docs, folders = trash.Count()
+	assert.Equal(1, docs)
+	assert.Equal(1, folders)
+
+	docs, folders = root.Children[0].Count()
+	assert.Equal(0, docs+folders)
+}
+
+func TestBuildTreeOrphans(t *testing.T) {
+	assert := assert.New(t)
+	items := []Meta{
+		&nodeMeta{"d0", "", "d0", DocumentType},
+		&nodeMeta{"o0", "missing", "o0", DocumentType},
+		&nodeMeta{"f0", "missing", "f0", CollectionType},
+		&nodeMeta{"c0", "f0", "c0", DocumentType},
+	}
+
+	root := BuildTree(items)
+	assert.Equal(root, root.FindByID("o0").ParentNode, "orphan attached to root")
+	assert.Equal(root, root.FindByID("f0").ParentNode, "orphan attached to root")
+	assert.Equal("f0", root.FindByID("c0").ParentNode.ID(), "child of orphan keeps its parent")
+
+	root = BuildTreeWithOrphans(items, OrphansToFolder)
+	orphaned := root.FindByID(OrphanedFolder)
+	if assert.NotNil(orphaned) {
+		assert.Equal(orphaned, root.FindByID("o0").ParentNode)
+		assert.Equal(orphaned, root.FindByID("f0").ParentNode)
+	}
+	assert.NotNil(root.FindByID("c0"))
+
+	root = BuildTreeWithOrphans(items, OrphansDrop)
+	assert.Nil(root.FindByID("o0"))
+	assert.Nil(root.FindByID("c0"))
+	assert.NotNil(root.FindByID("d0"))
+	assert.Nil(root.FindByID(OrphanedFolder))
+}
+
+func TestBuildTreeCycle(t *testing.T) {
+	assert := assert.New(t)
+	items := []Meta{
+		&nodeMeta{"d0", "", "d0", DocumentType},
+		&nodeMeta{"f0", "f1", "f0", CollectionType},
+		&nodeMeta{"f1", "f0", "f1", CollectionType},
+		&nodeMeta{"c0", "f1", "c0", DocumentType},
+		&nodeMeta{"s0", "s0", "s0", CollectionType},
+	}
+
+	root := BuildTree(items)
+
+	count := 0
+	root.Walk(func(n *Node) error {
+		count++
+		return nil
+	})
+	// root + trash + all items
+	assert.Equal(len(items)+2, count, "all items should be in the tree")
+
+	f0 := root.FindByID("f0")
+	f1 := root.FindByID("f1")
+	if assert.NotNil(f0) && assert.NotNil(f1) {
+		assert.True(f0.ParentNode == root || f1.ParentNode == root, "cycle should be broken at root")
+	}
+	assert.Equal(root, root.FindByID("s0").ParentNode, "self reference should be broken")
+	assert.Equal("f1", root.FindByID("c0").ParentNode.ID())
+}
+
+func TestFindByID(t *testing.T) {
+	assert := assert.New(t)
+	root := sampleTree()
+
+	assert.Equal(root, root.FindByID("root"), "find the current node")
+	assert.Equal(root.Children[3].Children[1], root.FindByID("c1"))
+	assert.Nil(root.FindByID("xx"))
+	assert.Nil(root.Children[3].FindByID("a0"), "only search the subtree")
+
+	tree := BuildTree([]Meta{
+		&nodeMeta{"d0", "", "d0", DocumentType},
+		&nodeMeta{"t0", TrashFolder, "t0", DocumentType},
+	})
+	assert.Equal(tree, tree.FindByID(""), "find the virtual root node")
+	trash := tree.FindByID(TrashFolder)
+	if assert.NotNil(trash, "find the trash folder") {
+		assert.Equal("Trash", trash.Name())
+	}
+	assert.Equal("t0", tree.FindByID("t0").ID())
+}
+
 func TestMatchPath(t *testing.T) {
 	assert := assert.New(t)
 	root := sampleTree()
@@ -156,3 +486,48 @@ func sampleTree() *Node {
 func node(id, name string, t NotebookType) *Node {
 	return newNode(&nodeMeta{id, "", name, t})
 }
+
+// testRepo is a minimal Repository that serves an empty notebook for each
+// requested item. Items with an ID starting with "dummy" are placeholders,
+// the content of items with an ID starting with "broken" cannot be read.
+//
+// The page count for an item can be set with pageCounts.
+type testRepo struct {
+	pageCounts map[string]int
+}
+
+func (r *testRepo) List() ([]Meta, error) {
+	return make([]Meta, 0), nil
+}
+
+func (r *testRepo) Update(m Meta) error {
+	return nil
+}
+
+func (r *testRepo) Reader(id string, version uint, path ...string) (io.ReadCloser, error) {
+	p := strings.Join(path, "/")
+	if p != id+".content" || strings.HasPrefix(id, "broken") {
+		return nil, errors.New("not found")
+	}
+
+	c := NewContent(Notebook)
+	c.DummyDocument = strings.HasPrefix(id, "dummy")
+	c.PageCount = r.pageCounts[id]
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (r *testRepo) PagePrefix(pageID string, pageIndex int) string {
+	return pageID
+}
+
+func (r *testRepo) Upload(d *Document) error {
+	return nil
+}
+
+func (r *testRepo) ListFiles(id string, version uint) ([]string, error) {
+	return []string{id + ".content"}, nil
+}